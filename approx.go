@@ -0,0 +1,55 @@
+package lmstree
+
+// ApproximateSize estimates the bytes the default column family holds in
+// [start, end), every version and tombstone included. SSTables are estimated
+// from their index blocks and MemTables from a sample of their skip lists, so no
+// data block is read. A nil start or end leaves that side unbounded.
+func (t *LSMTree) ApproximateSize(start, end []byte) int64 {
+	size, _ := t.defaultCF.approximate(start, end)
+	return size
+}
+
+// ApproximateCount estimates the entries the default column family holds in
+// [start, end), like ApproximateSize. Keys written several times or deleted are
+// counted once per version until compaction merges them.
+func (t *LSMTree) ApproximateCount(start, end []byte) int64 {
+	_, count := t.defaultCF.approximate(start, end)
+	return count
+}
+
+// ApproximateSize estimates the bytes the family holds in [start, end), like
+// LSMTree.ApproximateSize.
+func (cf *ColumnFamily) ApproximateSize(start, end []byte) int64 {
+	size, _ := cf.approximate(start, end)
+	return size
+}
+
+// ApproximateCount estimates the entries the family holds in [start, end), like
+// LSMTree.ApproximateCount.
+func (cf *ColumnFamily) ApproximateCount(start, end []byte) int64 {
+	_, count := cf.approximate(start, end)
+	return count
+}
+
+// approximate sums the estimates of cf's MemTables and SSTables for [start, end).
+func (cf *ColumnFamily) approximate(start, end []byte) (size, count int64) {
+	cf.tree.mu.RLock()
+	defer cf.tree.mu.RUnlock()
+	if cf.dropped {
+		return 0, 0
+	}
+	add := func(s, c int64) {
+		size += s
+		count += c
+	}
+	add(cf.memTable.ApproximateRange(start, end))
+	if cf.immutable != nil {
+		add(cf.immutable.ApproximateRange(start, end))
+	}
+	for _, level := range cf.levels {
+		for _, sst := range level {
+			add(sst.reader.ApproximateRange(start, end))
+		}
+	}
+	return size, count
+}
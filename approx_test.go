@@ -0,0 +1,38 @@
+package lmstree
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLSMTree_Approximate(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.MemTableSize = 16 * 1024 // most keys end up in SSTables, the rest in MemTables
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	value := make([]byte, 100)
+	for i := 0; i < 5000; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%05d", i)), value); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	near := func(got, want int64) bool { return got >= want*3/4 && got <= want*5/4 }
+	if count := tree.ApproximateCount(nil, nil); !near(count, 5000) {
+		t.Errorf("ApproximateCount(all) = %d, want about 5000", count)
+	}
+	if count := tree.ApproximateCount([]byte("key01000"), []byte("key02000")); !near(count, 1000) {
+		t.Errorf("ApproximateCount(key01000, key02000) = %d, want about 1000", count)
+	}
+	total := tree.ApproximateSize(nil, nil)
+	if size := tree.ApproximateSize([]byte("key00000"), []byte("key02500")); !near(size, total/2) {
+		t.Errorf("ApproximateSize of half the keys = %d of %d", size, total)
+	}
+	if size := tree.ApproximateSize([]byte("zzz"), nil); size != 0 {
+		t.Errorf("ApproximateSize past the last key = %d, want 0", size)
+	}
+}
@@ -0,0 +1,60 @@
+package lmstree
+
+import (
+	"bytes"
+
+	"github.com/maksymus/lmstree/entry"
+)
+
+// WriteBatch collects Put and Delete operations that LSMTree.Write applies
+// atomically: the whole batch is logged as one WAL record and inserted into the
+// MemTable under a single lock, so after a crash either every operation in the
+// batch is recovered or none is. Later operations on the same key win.
+//
+// The zero value is an empty batch ready to use. A WriteBatch is not safe for
+// concurrent use.
+type WriteBatch struct {
+	entries []*entry.Entry
+}
+
+// NewWriteBatch returns an empty WriteBatch.
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+// Put queues key → value. Key and value are copied, so the caller may reuse them.
+func (b *WriteBatch) Put(key, value []byte) {
+	b.entries = append(b.entries, &entry.Entry{Key: bytes.Clone(key), Value: bytes.Clone(value)})
+}
+
+// Delete queues a tombstone for key.
+func (b *WriteBatch) Delete(key []byte) {
+	b.entries = append(b.entries, &entry.Entry{Key: bytes.Clone(key), Value: []byte{}, Tombstone: true})
+}
+
+// DeleteRange queues a range tombstone for every key in [start, end). Write
+// fails with ErrInvalidRange if the range is empty.
+func (b *WriteBatch) DeleteRange(start, end []byte) {
+	b.entries = append(b.entries, &entry.Entry{Key: bytes.Clone(start), Value: bytes.Clone(end), RangeDelete: true})
+}
+
+// PutCF queues key → value in column family cf. A batch may span families; it
+// still commits atomically.
+func (b *WriteBatch) PutCF(cf *ColumnFamily, key, value []byte) {
+	b.entries = append(b.entries, &entry.Entry{Key: bytes.Clone(key), Value: bytes.Clone(value), Family: cf.id})
+}
+
+// DeleteCF queues a tombstone for key in column family cf.
+func (b *WriteBatch) DeleteCF(cf *ColumnFamily, key []byte) {
+	b.entries = append(b.entries, &entry.Entry{Key: bytes.Clone(key), Value: []byte{}, Tombstone: true, Family: cf.id})
+}
+
+// Clear removes all queued operations so the batch can be reused.
+func (b *WriteBatch) Clear() {
+	b.entries = b.entries[:0]
+}
+
+// Count returns the number of queued operations.
+func (b *WriteBatch) Count() int {
+	return len(b.entries)
+}
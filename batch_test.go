@@ -0,0 +1,100 @@
+package lmstree
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLSMTree_WriteBatch(t *testing.T) {
+	tree, err := Open(DefaultOptions(tempDir(t)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	tree.Put([]byte("gone"), []byte("x"))
+
+	batch := NewWriteBatch()
+	batch.Put([]byte("a"), []byte("1"))
+	batch.Put([]byte("b"), []byte("2"))
+	batch.Put([]byte("a"), []byte("3")) // later operation on the same key wins
+	batch.Delete([]byte("gone"))
+	if batch.Count() != 4 {
+		t.Fatalf("Count = %d, want 4", batch.Count())
+	}
+	if err := tree.Write(batch); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	for key, want := range map[string]string{"a": "3", "b": "2"} {
+		got, ok := tree.Get([]byte(key))
+		if !ok || !bytes.Equal(got, []byte(want)) {
+			t.Fatalf("Get %s: got (%q, %v), want %q", key, got, ok, want)
+		}
+	}
+	if _, ok := tree.Get([]byte("gone")); ok {
+		t.Fatal("Get gone: expected deleted")
+	}
+
+	batch.Clear()
+	if batch.Count() != 0 {
+		t.Fatalf("Count after Clear = %d, want 0", batch.Count())
+	}
+	if err := tree.Write(batch); err != nil {
+		t.Fatalf("Write empty batch: %v", err)
+	}
+}
+
+func TestLSMTree_WriteBatchRecoveryIsAtomic(t *testing.T) {
+	dir := tempDir(t)
+
+	// The first tree is never closed, simulating a crash that leaves its WAL behind.
+	crashed, err := Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	first := NewWriteBatch()
+	first.Put([]byte("a"), []byte("1"))
+	first.Put([]byte("b"), []byte("2"))
+	second := NewWriteBatch()
+	second.Put([]byte("c"), []byte("3"))
+	second.Put([]byte("d"), []byte("4"))
+	if err := crashed.Write(first); err != nil {
+		t.Fatalf("Write first: %v", err)
+	}
+	if err := crashed.Write(second); err != nil {
+		t.Fatalf("Write second: %v", err)
+	}
+
+	// Tear the tail of the second record, as a power loss mid-write would.
+	wals, _ := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if len(wals) != 1 {
+		t.Fatalf("expected 1 WAL file, found %d", len(wals))
+	}
+	info, err := os.Stat(wals[0])
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if err := os.Truncate(wals[0], info.Size()-3); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+
+	tree, err := Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+	defer tree.Close()
+
+	for _, key := range []string{"a", "b"} {
+		if _, ok := tree.Get([]byte(key)); !ok {
+			t.Fatalf("Get %s: complete batch not recovered", key)
+		}
+	}
+	for _, key := range []string{"c", "d"} {
+		if _, ok := tree.Get([]byte(key)); ok {
+			t.Fatalf("Get %s: torn batch partially recovered", key)
+		}
+	}
+}
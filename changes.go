@@ -0,0 +1,365 @@
+package lmstree
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/maksymus/lmstree/entry"
+	"github.com/maksymus/lmstree/internal/vlog"
+	walPkg "github.com/maksymus/lmstree/internal/wal"
+)
+
+// ErrChangesNotRetained is returned by Subscribe, or by Subscription.Err, when
+// changes the subscriber asked for were in WAL segments already deleted, or
+// their separated values in value-log files already reclaimed by ValueLogGC.
+var ErrChangesNotRetained = errors.New("lmstree: changes no longer retained")
+
+// errSubscriptionDone stops a changeReader once its subscription or the tree
+// is closed.
+var errSubscriptionDone = errors.New("lmstree: subscription done")
+
+// walArchiveDir holds flushed WAL segments kept for Subscribe.
+const walArchiveDir = "wal-archive"
+
+// subscriptionBuffer is the number of changes a Subscription reads ahead of its
+// consumer.
+const subscriptionBuffer = 128
+
+// Subscription streams committed changes of one column family in commit order.
+// It reads them from the WAL on its own goroutine, so a consumer that falls
+// behind delays only itself, never writers.
+type Subscription struct {
+	changes chan *entry.Entry
+	stop    chan struct{}
+	once    sync.Once
+	err     error // set before changes is closed
+}
+
+// Changes returns the channel of changes. Each is an entry.Entry as written —
+// a put, tombstone, merge operand or range tombstone — with its sequence number
+// and the value of a separated value read back from the value log. The channel
+// is closed when the subscription ends: on Close, when the tree is closed, or on
+// an error reported by Err.
+func (s *Subscription) Changes() <-chan *entry.Entry { return s.changes }
+
+// Err returns the error that ended the subscription, once Changes is closed.
+func (s *Subscription) Err() error { return s.err }
+
+// Close ends the subscription. Changes is closed shortly after.
+func (s *Subscription) Close() error {
+	s.once.Do(func() { close(s.stop) })
+	return nil
+}
+
+// Subscribe streams the committed changes of the default column family with a
+// sequence number >= fromSeq whose key starts with prefix, first those already
+// in the WAL, then new ones as they are written. A range tombstone is delivered
+// if its range overlaps the prefix. fromSeq 0 starts at the oldest change
+// retained. A change is delivered once its write is applied and, unless the
+// WALSyncMode is WALSyncNone, synced; Subscribe fails with ErrClosed on a closed
+// tree.
+//
+// Changes stay available as long as their WAL segment does: until it is flushed,
+// or, with Options.WALRetentionSize set, until it is pruned from the archive. A
+// separated value must also still be in its value-log file, which ValueLogGC
+// may reclaim first.
+// A consumer that records the sequence number of the last change it handled can
+// therefore resume from the next one after a restart. Subscribe fails with
+// ErrChangesNotRetained if changes from fromSeq on are no longer all retained.
+func (t *LSMTree) Subscribe(fromSeq uint64, prefix []byte) (*Subscription, error) {
+	return t.subscribe(t.defaultCF, fromSeq, prefix)
+}
+
+// Subscribe streams the committed changes of the family, like LSMTree.Subscribe.
+func (cf *ColumnFamily) Subscribe(fromSeq uint64, prefix []byte) (*Subscription, error) {
+	return cf.tree.subscribe(cf, fromSeq, prefix)
+}
+
+// subscribe starts a Subscription to the changes of cf.
+func (t *LSMTree) subscribe(cf *ColumnFamily, fromSeq uint64, prefix []byte) (*Subscription, error) {
+	segments, err := t.walSegments()
+	if err != nil {
+		return nil, err
+	}
+	if fromSeq > 0 {
+		oldest, err := t.oldestRetainedSeq(segments)
+		if err != nil {
+			return nil, err
+		}
+		if fromSeq < oldest {
+			return nil, ErrChangesNotRetained
+		}
+	}
+
+	s := &Subscription{
+		changes: make(chan *entry.Entry, subscriptionBuffer),
+		stop:    make(chan struct{}),
+	}
+	r := &changeReader{
+		tree:     t,
+		sub:      s,
+		family:   cf.id,
+		bytewise: cf.opts.Comparator.Name() == BytewiseComparator.Name(),
+		prefix:   bytes.Clone(prefix),
+		next:     max(fromSeq, 1),
+		read:     max(fromSeq, 1) - 1,
+	}
+	t.mu.Lock()
+	select {
+	case <-t.done:
+		t.mu.Unlock()
+		return nil, ErrClosed
+	default:
+	}
+	t.wg.Add(1)
+	t.mu.Unlock()
+	go func() {
+		defer t.wg.Done()
+		if err := r.run(); !errors.Is(err, errSubscriptionDone) {
+			s.err = err
+		}
+		close(s.changes)
+	}()
+	return s, nil
+}
+
+// walSegments returns the archived and live WAL segments, oldest first.
+func (t *LSMTree) walSegments() ([]string, error) {
+	return walPkg.Segments(filepath.Join(t.opts.Dir, walArchiveDir), t.opts.Dir)
+}
+
+// oldestRetainedSeq returns the sequence number of the first change in segments,
+// or the next one to be written if they hold none.
+func (t *LSMTree) oldestRetainedSeq(segments []string) (uint64, error) {
+	for _, path := range segments {
+		seq, ok, err := firstSeq(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // deleted since it was listed
+		}
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return seq, nil
+		}
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.seq + 1, nil
+}
+
+// firstSeq returns the sequence number of the first change in the WAL segment
+// at path, if it holds any.
+func firstSeq(path string) (uint64, bool, error) {
+	tail, err := walPkg.OpenTail(path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tail.Close()
+	batch, err := tail.Next()
+	if errors.Is(err, io.EOF) || (err == nil && len(batch) == 0) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return batch[0].Seq, true, nil
+}
+
+// retireWAL disposes of a WAL whose data is all in SSTables: it is deleted, or,
+// with WALRetentionSize set, archived for Subscribe, pruning the oldest archived
+// segments beyond that size.
+func (t *LSMTree) retireWAL(w *walPkg.WAL) error {
+	if t.opts.WALRetentionSize <= 0 {
+		return w.Delete()
+	}
+	dir := filepath.Join(t.opts.Dir, walArchiveDir)
+	if err := w.Archive(dir); err != nil {
+		return err
+	}
+	return walPkg.PruneArchive(dir, t.opts.WALRetentionSize)
+}
+
+// watch returns a channel closed by the next write.
+func (t *LSMTree) watch() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.watched = true
+	return t.changed
+}
+
+// notifyWatchers wakes the subscribers waiting in watch. Must be called with t.mu
+// held for writing.
+func (t *LSMTree) notifyWatchers() {
+	if t.watched {
+		close(t.changed)
+		t.changed = make(chan struct{})
+		t.watched = false
+	}
+}
+
+// changeReader follows the WAL segments for one Subscription.
+type changeReader struct {
+	tree     *LSMTree
+	sub      *Subscription
+	family   uint32
+	bytewise bool // the family's keys are in byte order
+	prefix   []byte
+	next     uint64 // lowest sequence number still to deliver
+	read     uint64 // highest sequence number read, delivered or not
+	segment  string // base name of the segment being read
+	tail     *walPkg.Tail
+	checkGap bool           // the segment's first change must follow the last one read
+	pending  []*entry.Entry // a record read but not yet committed
+}
+
+// run delivers changes until the subscription or the tree is closed, or an
+// error occurs.
+func (r *changeReader) run() error {
+	defer func() {
+		if r.tail != nil {
+			r.tail.Close()
+		}
+	}()
+	for {
+		segments, err := r.tree.walSegments()
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(segments, func(path string) bool { return filepath.Base(path) > r.segment })
+		if i >= 0 && r.tail != nil {
+			// The segment being read is complete once a newer one exists. Finish
+			// it before moving on.
+			if err := r.drain(); err != nil {
+				return err
+			}
+		}
+		if i >= 0 && r.pending == nil {
+			if err := r.open(segments, i); err != nil {
+				return err
+			}
+			continue
+		}
+
+		changed := r.tree.watch()
+		if r.tail != nil {
+			if err := r.drain(); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-changed:
+		case <-r.sub.stop:
+			return nil
+		case <-r.tree.done:
+			return nil
+		}
+	}
+}
+
+// open switches to segments[i]; one deleted since it was listed is left for the
+// next listing to skip. Once changes have been read, the first change of the new
+// segment must directly follow the last one, or a segment in between was deleted
+// before it was read; drain checks this.
+func (r *changeReader) open(segments []string, i int) error {
+	tail, err := walPkg.OpenTail(segments[i])
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if r.tail != nil {
+		r.tail.Close()
+	}
+	r.tail, r.segment = tail, filepath.Base(segments[i])
+	r.checkGap = r.read > 0
+	return nil
+}
+
+// drain delivers the changes of every complete record left in the current
+// segment, up to the first not yet committed: a record reaches the WAL before
+// it is applied and, in the sync modes, synced. That record is kept in pending
+// until it is.
+func (r *changeReader) drain() error {
+	r.tree.mu.RLock()
+	committed := r.tree.committedSeq()
+	r.tree.mu.RUnlock()
+	for {
+		if r.pending == nil {
+			batch, err := r.tail.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if r.checkGap && len(batch) > 0 {
+				if batch[0].Seq > r.read+1 {
+					return ErrChangesNotRetained
+				}
+				r.checkGap = false
+			}
+			r.pending = batch
+		}
+		if n := len(r.pending); n > 0 && r.pending[n-1].Seq > committed {
+			return nil
+		}
+		for _, e := range r.pending {
+			if err := r.deliver(e); err != nil {
+				return err
+			}
+		}
+		r.pending = nil
+	}
+}
+
+// deliver sends e to the subscriber if it is wanted and not yet delivered. It
+// blocks while the subscriber's buffer is full.
+func (r *changeReader) deliver(e *entry.Entry) error {
+	r.read = max(r.read, e.Seq)
+	if e.Seq < r.next || e.Family != r.family || !r.matches(e) {
+		return nil
+	}
+	if e.ValuePointer {
+		value, err := r.tree.value(e)
+		if errors.Is(err, vlog.ErrRemoved) {
+			return ErrChangesNotRetained
+		}
+		if err != nil {
+			return err
+		}
+		e.Value, e.ValuePointer = value, false
+	}
+	r.next = e.Seq + 1
+	select {
+	case r.sub.changes <- e:
+		return nil
+	case <-r.sub.stop:
+		return errSubscriptionDone
+	case <-r.tree.done:
+		return errSubscriptionDone
+	}
+}
+
+// matches reports whether e falls under the subscription's prefix. Under a
+// comparator other than BytewiseComparator the keys with a prefix need not be
+// contiguous, so every range tombstone is taken to overlap them.
+func (r *changeReader) matches(e *entry.Entry) bool {
+	if len(r.prefix) == 0 {
+		return true
+	}
+	if !e.RangeDelete {
+		return bytes.HasPrefix(e.Key, r.prefix)
+	}
+	if !r.bytewise {
+		return true
+	}
+	end := prefixEnd(r.prefix)
+	return bytes.Compare(e.Value, r.prefix) > 0 && (end == nil || bytes.Compare(e.Key, end) < 0)
+}
@@ -0,0 +1,224 @@
+package lmstree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/maksymus/lmstree/entry"
+	walPkg "github.com/maksymus/lmstree/internal/wal"
+)
+
+// receive reads n changes from sub, failing the test if they do not arrive.
+func receive(t *testing.T, sub *Subscription, n int) []*entry.Entry {
+	t.Helper()
+	var changes []*entry.Entry
+	timeout := time.After(5 * time.Second)
+	for len(changes) < n {
+		select {
+		case e, ok := <-sub.Changes():
+			if !ok {
+				t.Fatalf("subscription ended after %d of %d changes: %v", len(changes), n, sub.Err())
+			}
+			changes = append(changes, e)
+		case <-timeout:
+			t.Fatalf("received %d of %d changes", len(changes), n)
+		}
+	}
+	return changes
+}
+
+func TestLSMTree_Subscribe(t *testing.T) {
+	dir := tempDir(t)
+	opts := DefaultOptions(dir)
+	opts.MemTableSize = 512 // several flushes, so changes span archived segments
+	opts.WALRetentionSize = 1 << 20
+	opts.ValueThreshold = 64
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	sub, err := tree.Subscribe(0, []byte("user:"))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		tree.Put([]byte(fmt.Sprintf("user:%02d", i)), []byte(fmt.Sprintf("v%d", i)))
+		tree.Put([]byte(fmt.Sprintf("other:%02d", i)), []byte("x"))
+	}
+	tree.Put([]byte("user:big"), bytes.Repeat([]byte("b"), 100))
+	tree.Delete([]byte("user:01"))
+	tree.DeleteRange([]byte("a"), []byte("user:10")) // overlaps the prefix
+
+	changes := receive(t, sub, 53)
+	for i, e := range changes {
+		if i > 0 && e.Seq <= changes[i-1].Seq {
+			t.Errorf("change %d has seq %d after %d", i, e.Seq, changes[i-1].Seq)
+		}
+		if !e.RangeDelete && !bytes.HasPrefix(e.Key, []byte("user:")) {
+			t.Errorf("change %d for %s, outside the prefix", i, e.Key)
+		}
+	}
+	if e := changes[50]; string(e.Key) != "user:big" || len(e.Value) != 100 || e.ValuePointer {
+		t.Errorf("separated value delivered as %+v", e)
+	}
+	if !changes[51].Tombstone || !changes[52].RangeDelete {
+		t.Errorf("last changes = %+v, %+v; want the delete and the range delete", changes[51], changes[52])
+	}
+	sub.Close()
+	for range sub.Changes() {
+	}
+	if err := sub.Err(); err != nil {
+		t.Errorf("Err after Close = %v", err)
+	}
+
+	// A slow consumer does not hold up writers.
+	slow, err := tree.Subscribe(0, nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	for i := 0; i < 2*subscriptionBuffer; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("other:%03d", i)), []byte("y")); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	slow.Close()
+	tree.Close()
+	if segments, _ := walPkg.Segments(filepath.Join(dir, walArchiveDir)); len(segments) < 2 {
+		t.Errorf("%d WAL segments archived, want the flushed ones kept", len(segments))
+	}
+
+	// Resume after a restart from the change after the 20th.
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer tree.Close()
+	sub, err = tree.Subscribe(changes[19].Seq+1, []byte("user:"))
+	if err != nil {
+		t.Fatalf("Subscribe after reopen: %v", err)
+	}
+	defer sub.Close()
+	resumed := receive(t, sub, len(changes)-20)
+	for i, e := range resumed {
+		if want := changes[20+i]; e.Seq != want.Seq || !bytes.Equal(e.Key, want.Key) {
+			t.Errorf("resumed change %d = %s@%d, want %s@%d", i, e.Key, e.Seq, want.Key, want.Seq)
+		}
+	}
+	tree.Put([]byte("user:new"), []byte("after restart"))
+	if e := receive(t, sub, 1)[0]; string(e.Key) != "user:new" {
+		t.Errorf("live change after resume = %s", e.Key)
+	}
+}
+
+func TestLSMTree_SubscribeCommitted(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.WALSyncMode = WALSyncInterval
+	opts.WALSyncInterval = time.Hour
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	sub, err := tree.Subscribe(0, nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// The write is in the WAL but not synced, so it is not delivered yet.
+	tree.Put([]byte("k"), []byte("v"))
+	select {
+	case e := <-sub.Changes():
+		t.Fatalf("delivered %s before it was synced", e.Key)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if e := receive(t, sub, 1)[0]; string(e.Key) != "k" {
+		t.Errorf("delivered %s, want k", e.Key)
+	}
+
+	tree.Close()
+	for range sub.Changes() {
+	}
+	if _, err := tree.Subscribe(0, nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("Subscribe after Close error = %v, want ErrClosed", err)
+	}
+}
+
+func TestLSMTree_SubscribeValueLogGC(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.MemTableSize = 1024
+	opts.ValueThreshold = 16
+	opts.ValueLogFileSize = 1024
+	opts.WALRetentionSize = 1 << 20
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+	for version := byte(1); version <= 2; version++ {
+		for i := 0; i < 50; i++ {
+			tree.Put([]byte(fmt.Sprintf("key%02d", i)), bytes.Repeat([]byte{version}, 100))
+		}
+	}
+	if ok, err := tree.ValueLogGC(0.5); !ok || err != nil {
+		t.Fatalf("ValueLogGC = %v, %v; want a file reclaimed", ok, err)
+	}
+
+	// The first changes point into the reclaimed file.
+	sub, err := tree.Subscribe(1, nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	timeout := time.After(5 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-sub.Changes():
+		case <-timeout:
+			t.Fatal("subscription still running")
+		}
+	}
+	if err := sub.Err(); !errors.Is(err, ErrChangesNotRetained) {
+		t.Errorf("Err() = %v, want ErrChangesNotRetained", err)
+	}
+}
+
+func TestLSMTree_SubscribeNotRetained(t *testing.T) {
+	dir := tempDir(t)
+	opts := DefaultOptions(dir)
+	opts.MemTableSize = 256
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		tree.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("value"))
+	}
+	tree.Close()
+
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if _, err := tree.Subscribe(1, nil); !errors.Is(err, ErrChangesNotRetained) {
+		t.Errorf("Subscribe(1) error = %v, want ErrChangesNotRetained", err)
+	}
+	sub, err := tree.Subscribe(0, nil)
+	if err != nil {
+		t.Fatalf("Subscribe(0): %v", err)
+	}
+	tree.Put([]byte("live"), []byte("value"))
+	if e := receive(t, sub, 1)[0]; string(e.Key) != "live" {
+		t.Errorf("Subscribe(0) delivered %s, want the next write", e.Key)
+	}
+	tree.Close()
+	for range sub.Changes() {
+	}
+}
@@ -0,0 +1,34 @@
+package lmstree
+
+import (
+	"bytes"
+
+	"github.com/maksymus/lmstree/internal/sstable"
+)
+
+// ErrComparatorMismatch is returned by Open for an SSTable written with a
+// comparator other than its column family's.
+var ErrComparatorMismatch = sstable.ErrComparatorMismatch
+
+// Comparator defines the order of keys within a column family: the order of
+// scans and of keys in SSTables. Compare returns a negative number, zero or a
+// positive number as a sorts before, equal to or after b; it must return zero
+// only for identical keys. Name identifies the order. It is recorded in every
+// SSTable, and Open refuses tables recorded under another name, so a
+// comparator's name must change whenever its order does.
+type Comparator interface {
+	Compare(a, b []byte) int
+	Name() string
+}
+
+var (
+	// BytewiseComparator orders keys lexicographically by byte. It is the default.
+	BytewiseComparator Comparator = sstable.Bytewise
+	// ReverseBytewiseComparator orders keys lexicographically by byte, largest first.
+	ReverseBytewiseComparator Comparator = reverseBytewise{}
+)
+
+type reverseBytewise struct{}
+
+func (reverseBytewise) Compare(a, b []byte) int { return bytes.Compare(b, a) }
+func (reverseBytewise) Name() string            { return "lmstree.ReverseBytewise" }
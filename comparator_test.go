@@ -0,0 +1,86 @@
+package lmstree
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// numeric orders decimal keys by value; keys with the same value, such as "7"
+// and "07", by byte.
+type numeric struct{}
+
+func (numeric) Compare(a, b []byte) int {
+	x, _ := strconv.ParseUint(string(a), 10, 64)
+	y, _ := strconv.ParseUint(string(b), 10, 64)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return BytewiseComparator.Compare(a, b)
+}
+
+func (numeric) Name() string { return "test.Numeric" }
+
+func TestLSMTree_Comparator(t *testing.T) {
+	dir := tempDir(t)
+	opts := DefaultOptions(dir)
+	opts.MemTableSize = 256 // flushes and compactions go through the comparator too
+	opts.Comparator = numeric{}
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for i := 200; i > 0; i-- {
+		tree.Put([]byte(strconv.Itoa(i)), []byte(fmt.Sprintf("v%d", i)))
+	}
+	if err := tree.DeleteRange([]byte("20"), []byte("100")); err != nil {
+		t.Fatalf("DeleteRange: %v", err)
+	}
+	if err := tree.DeleteRange([]byte("100"), []byte("20")); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("DeleteRange(100, 20) error = %v, want ErrInvalidRange", err)
+	}
+	tree.Close()
+
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	keys, _ := collect(t, tree.Scan([]byte("9"), []byte("101")))
+	if fmt.Sprint(keys) != "[9 10 11 12 13 14 15 16 17 18 19 100]" {
+		t.Errorf("Scan(9, 101) = %v", keys)
+	}
+	it := tree.Scan(nil, nil)
+	it.SeekToLast()
+	if string(it.Key()) != "200" {
+		t.Errorf("last key = %s, want 200", it.Key())
+	}
+	it.Close()
+	values, found := tree.MultiGet([][]byte{[]byte("150"), []byte("50"), []byte("3")})
+	if fmt.Sprint(found) != "[true false true]" || string(values[0]) != "v150" || string(values[2]) != "v3" {
+		t.Errorf("MultiGet = %q %v", values, found)
+	}
+
+	// A family may order its keys differently from the tree.
+	reversed, err := tree.CreateColumnFamily("reversed", ColumnFamilyOptions{Comparator: ReverseBytewiseComparator})
+	if err != nil {
+		t.Fatalf("CreateColumnFamily: %v", err)
+	}
+	for _, key := range []string{"a", "c", "b"} {
+		reversed.Put([]byte(key), []byte(key))
+	}
+	keys, _ = collect(t, reversed.Scan(nil, nil))
+	if fmt.Sprint(keys) != "[c b a]" {
+		t.Errorf("reversed Scan = %v, want [c b a]", keys)
+	}
+	tree.Close()
+
+	opts.Comparator = nil
+	if _, err := Open(opts); !errors.Is(err, ErrComparatorMismatch) {
+		t.Errorf("reopen with another comparator error = %v, want ErrComparatorMismatch", err)
+	}
+}
@@ -1,10 +1,45 @@
 package entry
 
+import "bytes"
+
 // Entry represents a Key-Value pair in the LSM tree.
+// Seq is the sequence number of the write that produced it; several versions
+// of the same Key are ordered newest (highest Seq) first.
+// Operand marks Value as a merge operand, to be combined with the older
+// versions of Key by a merge operator rather than replacing them.
+// ExpiresAt, if non-zero, is the Unix time in nanoseconds from which the entry
+// reads as deleted. Family is the id of the column family the entry belongs to
+// (0 for the default one); it is recorded in the WAL but not in SSTables, which
+// hold a single family each.
+// RangeDelete marks a range tombstone: it deletes every older version of every
+// key in [Key, Value), Value holding the exclusive end of the range.
+// ValuePointer marks Value as a pointer into the value log, where the actual
+// value is stored.
 type Entry struct {
-	Key       []byte
-	Value     []byte
-	Tombstone bool
+	Key          []byte
+	Value        []byte
+	Tombstone    bool
+	Operand      bool
+	RangeDelete  bool
+	ValuePointer bool
+	Seq          uint64
+	ExpiresAt    int64
+	Family       uint32
+}
+
+// Expired reports whether the entry has a deadline at or before now (Unix nanoseconds).
+func (entry Entry) Expired(now int64) bool {
+	return entry.ExpiresAt != 0 && entry.ExpiresAt <= now
+}
+
+// Covers reports whether entry is a range tombstone whose range contains key.
+func (entry Entry) Covers(key []byte) bool {
+	return entry.CoversWith(key, bytes.Compare)
+}
+
+// CoversWith is Covers for keys ordered by cmp.
+func (entry Entry) CoversWith(key []byte, cmp func(a, b []byte) int) bool {
+	return entry.RangeDelete && cmp(entry.Key, key) <= 0 && cmp(key, entry.Value) < 0
 }
 
 func (entry Entry) Size() int {
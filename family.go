@@ -0,0 +1,317 @@
+package lmstree
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/maksymus/lmstree/entry"
+	"github.com/maksymus/lmstree/internal/memtable"
+	walPkg "github.com/maksymus/lmstree/internal/wal"
+)
+
+// DefaultColumnFamily is the name of the family that LSMTree's own Get, Put,
+// Delete and Scan operate on. It always exists and cannot be dropped.
+const DefaultColumnFamily = "default"
+
+// familiesFile lists the column families other than the default one.
+const familiesFile = "families"
+
+var (
+	// ErrColumnFamilyExists is returned by CreateColumnFamily for a name already in use.
+	ErrColumnFamilyExists = errors.New("lmstree: column family already exists")
+	// ErrColumnFamilyNotFound is returned for a column family that does not exist
+	// or has been dropped.
+	ErrColumnFamilyNotFound = errors.New("lmstree: column family not found")
+)
+
+// ColumnFamilyOptions configures one column family. Zero fields inherit the
+// tree's Options.
+type ColumnFamilyOptions struct {
+	MemTableSize    int64         // memtable size in bytes before a flush is triggered
+	BlockSize       int           // target SSTable data-block size in bytes
+	L0CompactThresh int           // number of L0 SSTables that triggers a compaction to L1
+	MaxLevels       int           // maximum number of levels
+	MergeOperator   MergeOperator // combines operands written with Merge
+	Comparator      Comparator    // orders keys; fixed for the life of the family
+}
+
+// ColumnFamily is a keyspace with its own MemTables, SSTable levels and options.
+// All families of a tree share its WAL and sequence numbers, so a WriteBatch
+// spanning several families commits atomically and snapshots cover them all.
+//
+// Non-default families keep their SSTables in a "cf-<id>" subdirectory; they
+// are listed in a "families" file so they are found again on Open.
+type ColumnFamily struct {
+	tree      *LSMTree
+	id        uint32
+	name      string
+	dir       string
+	opts      ColumnFamilyOptions
+	memTable  *memtable.MemTable
+	immutable *memtable.MemTable // frozen; being flushed by flushWorker
+	levels    [][]*sstableFile   // levels[i] = SSTables at level i, newest first
+	dropped   bool
+}
+
+// newColumnFamily returns a family with an empty MemTable and no SSTables loaded.
+func (t *LSMTree) newColumnFamily(id uint32, name, dir string, opts ColumnFamilyOptions) *ColumnFamily {
+	if opts.MemTableSize == 0 {
+		opts.MemTableSize = t.opts.MemTableSize
+	}
+	if opts.BlockSize == 0 {
+		opts.BlockSize = t.opts.BlockSize
+	}
+	if opts.L0CompactThresh == 0 {
+		opts.L0CompactThresh = t.opts.L0CompactThresh
+	}
+	if opts.MaxLevels == 0 {
+		opts.MaxLevels = t.opts.MaxLevels
+	}
+	if opts.MergeOperator == nil {
+		opts.MergeOperator = t.opts.MergeOperator
+	}
+	if opts.Comparator == nil {
+		opts.Comparator = t.opts.Comparator
+	}
+	if opts.Comparator == nil || strings.HasPrefix(name, indexFamilyPrefix) {
+		// Index lookups scan the range of keys that start with a prefix.
+		opts.Comparator = BytewiseComparator
+	}
+	return &ColumnFamily{
+		tree:     t,
+		id:       id,
+		name:     name,
+		dir:      dir,
+		opts:     opts,
+		memTable: newFamilyMemTable(opts.Comparator),
+		levels:   make([][]*sstableFile, opts.MaxLevels),
+	}
+}
+
+// newFamilyMemTable returns an empty MemTable. It logs nothing itself: the tree
+// writes every record to the shared WAL before applying it.
+func newFamilyMemTable(cmp Comparator) *memtable.MemTable {
+	return memtable.NewMemTableWith(defaultSkipListLevel, &walPkg.NoopWAL{}, cmp.Compare)
+}
+
+// CreateColumnFamily creates an empty column family. Names starting with
+// "index:" are reserved for secondary indexes.
+func (t *LSMTree) CreateColumnFamily(name string, opts ColumnFamilyOptions) (*ColumnFamily, error) {
+	if !validFamilyName(name) || strings.HasPrefix(name, indexFamilyPrefix) {
+		return nil, fmt.Errorf("lmstree: invalid column family name %q", name)
+	}
+	return t.createColumnFamily(name, opts)
+}
+
+// validFamilyName reports whether name can name a column family: non-empty,
+// not the default family's, and free of whitespace, which the families file
+// uses as separator.
+func validFamilyName(name string) bool {
+	return name != "" && name != DefaultColumnFamily && !strings.ContainsAny(name, " \t\r\n")
+}
+
+// createColumnFamily creates an empty column family with a name already
+// validated.
+func (t *LSMTree) createColumnFamily(name string, opts ColumnFamilyOptions) (*ColumnFamily, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.families[name]; ok {
+		return nil, ErrColumnFamilyExists
+	}
+	id := t.nextFamilyID
+	cf := t.newColumnFamily(id, name, filepath.Join(t.opts.Dir, fmt.Sprintf("cf-%d", id)), opts)
+	if err := os.MkdirAll(cf.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	t.nextFamilyID++
+	t.families[name] = cf
+	t.familyIDs[id] = cf
+	if err := t.saveFamilies(); err != nil {
+		delete(t.families, name)
+		delete(t.familyIDs, id)
+		return nil, err
+	}
+	return cf, nil
+}
+
+// DropColumnFamily deletes a column family and all of its data. Handles to it
+// stop working: writes fail with ErrColumnFamilyNotFound and reads find nothing.
+// The families of secondary indexes cannot be dropped.
+func (t *LSMTree) DropColumnFamily(name string) error {
+	if name == DefaultColumnFamily || strings.HasPrefix(name, indexFamilyPrefix) {
+		return fmt.Errorf("lmstree: cannot drop the %s column family", name)
+	}
+	return t.dropColumnFamily(name)
+}
+
+// dropColumnFamily implements DropColumnFamily for any family but the default one.
+func (t *LSMTree) dropColumnFamily(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cf, ok := t.families[name]
+	if !ok {
+		return ErrColumnFamilyNotFound
+	}
+	delete(t.families, name)
+	delete(t.familyIDs, cf.id)
+	if err := t.saveFamilies(); err != nil {
+		t.families[name] = cf
+		t.familyIDs[cf.id] = cf
+		return err
+	}
+
+	// Its records in the WAL are skipped on recovery from now on.
+	cf.dropped = true
+	for _, level := range cf.levels {
+		for _, sst := range level {
+			sst.obsolete.Store(true)
+			sst.unref()
+		}
+	}
+	cf.levels = make([][]*sstableFile, cf.opts.MaxLevels)
+	cf.memTable = newFamilyMemTable(cf.opts.Comparator)
+	return os.RemoveAll(cf.dir)
+}
+
+// ColumnFamily returns the handle of an existing column family.
+func (t *LSMTree) ColumnFamily(name string) (*ColumnFamily, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	cf, ok := t.families[name]
+	return cf, ok
+}
+
+// Name returns the family's name.
+func (cf *ColumnFamily) Name() string { return cf.name }
+
+// Put stores key → value in the family.
+func (cf *ColumnFamily) Put(key, value []byte) error {
+	return cf.tree.write(&entry.Entry{Key: key, Value: value, Family: cf.id})
+}
+
+// Delete marks key as deleted in the family.
+func (cf *ColumnFamily) Delete(key []byte) error {
+	return cf.tree.write(&entry.Entry{Key: key, Value: []byte{}, Tombstone: true, Family: cf.id})
+}
+
+// DeleteRange deletes every key in [start, end) of the family.
+func (cf *ColumnFamily) DeleteRange(start, end []byte) error {
+	if !cf.validRange(start, end) {
+		return ErrInvalidRange
+	}
+	return cf.tree.write(&entry.Entry{Key: start, Value: end, RangeDelete: true, Family: cf.id})
+}
+
+// Get returns the value for key in the family, or nil and false if the key does
+// not exist, has been deleted, or the family has been dropped.
+func (cf *ColumnFamily) Get(key []byte) ([]byte, bool) {
+	t := cf.tree
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if cf.dropped {
+		return nil, false
+	}
+	value, ok, _ := t.getAt(context.Background(), cf, key, t.seq)
+	return value, ok
+}
+
+// Scan returns an Iterator over the family's keys in [start, end).
+func (cf *ColumnFamily) Scan(start, end []byte) *Iterator {
+	return cf.tree.scan(context.Background(), cf, nil, start, end)
+}
+
+// loadFamilies reads the families file and registers every family it lists,
+// with its options taken from opts.ColumnFamilies. A missing file means only the
+// default family exists.
+func (t *LSMTree) loadFamilies() error {
+	f, err := os.Open(filepath.Join(t.opts.Dir, familiesFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[1], 10, 32)
+		if fields[0] == "next" {
+			if err != nil {
+				return fmt.Errorf("lmstree: corrupt %s file: %q", familiesFile, scanner.Text())
+			}
+			t.nextFamilyID = max(t.nextFamilyID, uint32(id))
+			continue
+		}
+		id, err = strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("lmstree: corrupt %s file: %q", familiesFile, scanner.Text())
+		}
+		name := fields[1]
+		cf := t.newColumnFamily(uint32(id), name, filepath.Join(t.opts.Dir, fmt.Sprintf("cf-%d", id)), t.opts.ColumnFamilies[name])
+		t.families[name] = cf
+		t.familyIDs[cf.id] = cf
+		t.nextFamilyID = max(t.nextFamilyID, cf.id+1)
+	}
+	return scanner.Err()
+}
+
+// saveFamilies atomically and durably rewrites the families file. It records
+// the next id to hand out so the id of a dropped family is never reused: its
+// records may still sit in a WAL. The file must be on disk before any WAL
+// record naming a new family, or recovery would drop that record. Must be called
+// with t.mu held.
+func (t *LSMTree) saveFamilies() error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "next %d\n", t.nextFamilyID)
+	for name, cf := range t.families {
+		if cf.id != 0 {
+			fmt.Fprintf(&b, "%d %s\n", cf.id, name)
+		}
+	}
+
+	path := filepath.Join(t.opts.Dir, familiesFile)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(t.opts.Dir)
+}
+
+// syncDir syncs the directory dir, making the creation, removal or renaming of
+// files in it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	return errors.Join(d.Sync(), d.Close())
+}
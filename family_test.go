@@ -0,0 +1,158 @@
+package lmstree
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestColumnFamily_Isolation(t *testing.T) {
+	tree, err := Open(DefaultOptions(tempDir(t)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	users, err := tree.CreateColumnFamily("users", ColumnFamilyOptions{})
+	if err != nil {
+		t.Fatalf("CreateColumnFamily: %v", err)
+	}
+	if _, err := tree.CreateColumnFamily("users", ColumnFamilyOptions{}); !errors.Is(err, ErrColumnFamilyExists) {
+		t.Fatalf("duplicate CreateColumnFamily = %v, want ErrColumnFamilyExists", err)
+	}
+	if _, err := tree.CreateColumnFamily(DefaultColumnFamily, ColumnFamilyOptions{}); err == nil {
+		t.Fatal("CreateColumnFamily(default): expected error")
+	}
+
+	tree.Put([]byte("k"), []byte("default"))
+	users.Put([]byte("k"), []byte("users"))
+	users.Put([]byte("only-users"), []byte("x"))
+
+	if got, _ := tree.Get([]byte("k")); string(got) != "default" {
+		t.Fatalf("default Get(k) = %q, want default", got)
+	}
+	if got, _ := users.Get([]byte("k")); string(got) != "users" {
+		t.Fatalf("users Get(k) = %q, want users", got)
+	}
+	if _, ok := tree.Get([]byte("only-users")); ok {
+		t.Fatal("default family sees a key written to users")
+	}
+
+	keys, values := collect(t, users.Scan(nil, nil))
+	if fmt.Sprint(keys, values) != "[k only-users] [users x]" {
+		t.Fatalf("users Scan = %v %v", keys, values)
+	}
+
+	users.Delete([]byte("k"))
+	if _, ok := users.Get([]byte("k")); ok {
+		t.Fatal("users Get(k) after Delete: still present")
+	}
+	if _, ok := tree.Get([]byte("k")); !ok {
+		t.Fatal("Delete in users removed the default family's key")
+	}
+}
+
+func TestColumnFamily_ReopenAndAtomicBatch(t *testing.T) {
+	dir := tempDir(t)
+	opts := DefaultOptions(dir)
+	opts.ColumnFamilies = map[string]ColumnFamilyOptions{"orders": {MemTableSize: 256}}
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	orders, err := tree.CreateColumnFamily("orders", ColumnFamilyOptions{MemTableSize: 256})
+	if err != nil {
+		t.Fatalf("CreateColumnFamily: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		orders.Put([]byte(fmt.Sprintf("order%03d", i)), []byte("pending"))
+	}
+	tree.Close()
+
+	// The second tree is never closed, simulating a crash: the cross-family batch
+	// only lives in the shared WAL.
+	crashed, err := Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	orders, ok := crashed.ColumnFamily("orders")
+	if !ok {
+		t.Fatal("ColumnFamily(orders) not found after reopen")
+	}
+	batch := NewWriteBatch()
+	batch.PutCF(orders, []byte("order042"), []byte("paid"))
+	batch.Put([]byte("balance"), []byte("90"))
+	if err := crashed.Write(batch); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	defer tree.Close()
+	orders, _ = tree.ColumnFamily("orders")
+	if orders.opts.MemTableSize != 256 {
+		t.Errorf("orders MemTableSize = %d, want 256 from Options.ColumnFamilies", orders.opts.MemTableSize)
+	}
+	if got, _ := orders.Get([]byte("order042")); string(got) != "paid" {
+		t.Errorf("orders Get(order042) = %q, want paid", got)
+	}
+	if got, _ := orders.Get([]byte("order099")); string(got) != "pending" {
+		t.Errorf("orders Get(order099) = %q, want pending", got)
+	}
+	if got, _ := tree.Get([]byte("balance")); string(got) != "90" {
+		t.Errorf("Get(balance) = %q, want 90", got)
+	}
+}
+
+func TestColumnFamily_Drop(t *testing.T) {
+	dir := tempDir(t)
+
+	// Never closed: the dropped family's records stay in the WAL.
+	crashed, err := Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	tmp, err := crashed.CreateColumnFamily("tmp", ColumnFamilyOptions{})
+	if err != nil {
+		t.Fatalf("CreateColumnFamily: %v", err)
+	}
+	tmp.Put([]byte("k"), []byte("old"))
+	if err := crashed.DropColumnFamily("tmp"); err != nil {
+		t.Fatalf("DropColumnFamily: %v", err)
+	}
+	if err := crashed.DropColumnFamily(DefaultColumnFamily); err == nil {
+		t.Fatal("DropColumnFamily(default): expected error")
+	}
+	if err := tmp.Put([]byte("k"), []byte("v")); !errors.Is(err, ErrColumnFamilyNotFound) {
+		t.Fatalf("Put on dropped family = %v, want ErrColumnFamilyNotFound", err)
+	}
+	if _, ok := tmp.Get([]byte("k")); ok {
+		t.Fatal("Get on dropped family: found a key")
+	}
+	if _, ok := crashed.ColumnFamily("tmp"); ok {
+		t.Fatal("ColumnFamily(tmp) still found after drop")
+	}
+
+	tree, err := Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer tree.Close()
+	if _, ok := tree.ColumnFamily("tmp"); ok {
+		t.Fatal("dropped family reappeared after reopen")
+	}
+	// A new family never inherits the dropped one's id, and so its WAL records.
+	again, err := tree.CreateColumnFamily("tmp", ColumnFamilyOptions{})
+	if err != nil {
+		t.Fatalf("CreateColumnFamily again: %v", err)
+	}
+	if again.id == tmp.id {
+		t.Fatalf("recreated family reused id %d", tmp.id)
+	}
+	if _, ok := again.Get([]byte("k")); ok {
+		t.Fatal("recreated family sees the dropped family's data")
+	}
+}
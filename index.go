@@ -0,0 +1,311 @@
+package lmstree
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/maksymus/lmstree/entry"
+)
+
+// ErrIndexNotFound is returned by LookupByIndex for a name not in Options.Indexes.
+var ErrIndexNotFound = errors.New("lmstree: index not found")
+
+// IndexFunc returns the secondary keys under which key → value is indexed; nil
+// means none. It must be deterministic: the tree calls it again with old values
+// to find the index entries a write replaces, and during lookups to confirm
+// that an entry is still current.
+type IndexFunc func(key, value []byte) [][]byte
+
+// indexFamilyPrefix starts the name of the column family holding an index.
+const indexFamilyPrefix = "index:"
+
+// indexBuiltKey marks an index whose initial build has completed. Index keys
+// start with a 4-byte secondary-key length, so no real entry is this key.
+var indexBuiltKey = []byte{0xff, 0xff, 0xff, 0xff}
+
+// indexBuildBatch is the number of index entries written per batch while an
+// index is first built.
+const indexBuildBatch = 1024
+
+// index is a secondary index over the default column family. Its entries live
+// in their own column family, keyed by
+//
+//	len(secondary)(4) | secondary | primary
+//
+// with the primary key as value, so the entries for one secondary key form a
+// contiguous range in primary-key order.
+type index struct {
+	name string
+	fn   IndexFunc
+	cf   *ColumnFamily
+}
+
+// indexKey returns the key of the index entry for secondary → primary.
+func indexKey(secondary, primary []byte) []byte {
+	key := make([]byte, 4, 4+len(secondary)+len(primary))
+	binary.BigEndian.PutUint32(key, uint32(len(secondary)))
+	key = append(key, secondary...)
+	return append(key, primary...)
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// secondaryKeys returns the distinct secondary keys fn gives key → value, or nil
+// if value is nil (the key does not exist).
+func secondaryKeys(fn IndexFunc, key, value []byte) [][]byte {
+	if value == nil {
+		return nil
+	}
+	var keys [][]byte
+	for _, s := range fn(key, value) {
+		if !slices.ContainsFunc(keys, func(k []byte) bool { return bytes.Equal(k, s) }) {
+			keys = append(keys, s)
+		}
+	}
+	return keys
+}
+
+// openIndexes sets up every index in Options.Indexes, creating its column
+// family and building it from the existing data the first time, and drops the
+// indexes no longer listed: they would miss later writes. Called by Open once
+// the flush worker runs.
+func (t *LSMTree) openIndexes() error {
+	var unlisted []string
+	t.mu.RLock()
+	for name := range t.families {
+		if indexName, ok := strings.CutPrefix(name, indexFamilyPrefix); ok && t.opts.Indexes[indexName] == nil {
+			unlisted = append(unlisted, name)
+		}
+	}
+	t.mu.RUnlock()
+	for _, name := range unlisted {
+		if err := t.dropColumnFamily(name); err != nil {
+			return err
+		}
+	}
+	for name, fn := range t.opts.Indexes {
+		if !validFamilyName(name) {
+			return fmt.Errorf("lmstree: invalid index name %q", name)
+		}
+		cf, ok := t.ColumnFamily(indexFamilyPrefix + name)
+		if !ok {
+			var err error
+			if cf, err = t.createColumnFamily(indexFamilyPrefix+name, ColumnFamilyOptions{}); err != nil {
+				return err
+			}
+		}
+		idx := &index{name: name, fn: fn, cf: cf}
+		t.mu.Lock()
+		t.indexes[name] = idx
+		t.mu.Unlock()
+
+		if _, built := cf.Get(indexBuiltKey); !built {
+			if err := t.buildIndex(idx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// buildIndex adds an entry to idx for every key of the default family, then
+// marks idx built. A build cut short by a crash is simply redone on the next
+// Open: its entries are rewritten as they are, and lookups skip stale ones.
+func (t *LSMTree) buildIndex(idx *index) error {
+	it := t.Scan(nil, nil)
+	defer it.Close()
+	batch := NewWriteBatch()
+	for ; it.Valid(); it.Next() {
+		for _, s := range secondaryKeys(idx.fn, it.Key(), it.Value()) {
+			batch.PutCF(idx.cf, indexKey(s, it.Key()), it.Key())
+		}
+		if batch.Count() >= indexBuildBatch {
+			if err := t.Write(batch); err != nil {
+				return err
+			}
+			batch.Clear()
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	batch.PutCF(idx.cf, indexBuiltKey, []byte{1})
+	return t.Write(batch)
+}
+
+// withIndexEntries returns entries followed by the index entries that keep every
+// index in step with their writes to the default family: a tombstone for each
+// secondary key the old value of a key had and its new value lacks, and an
+// entry for each secondary key of the new value. Keys deleted by a range
+// tombstone keep their index entries, which lookups then skip. Must be called
+// with t.mu held.
+func (t *LSMTree) withIndexEntries(entries []*entry.Entry) ([]*entry.Entry, error) {
+	ctx := context.Background()
+	pending := make(map[string][]byte) // values written earlier in entries; nil if deleted
+	var rangeDels []*entry.Entry       // range tombstones earlier in entries
+	var extra []*entry.Entry
+	for _, e := range entries {
+		if e.Family != t.defaultCF.id {
+			continue
+		}
+		if e.RangeDelete {
+			rangeDels = append(rangeDels, e)
+			for key := range pending {
+				if e.CoversWith([]byte(key), t.defaultCF.opts.Comparator.Compare) {
+					pending[key] = nil
+				}
+			}
+			continue
+		}
+
+		old, ok := pending[string(e.Key)]
+		if !ok && !slices.ContainsFunc(rangeDels, func(r *entry.Entry) bool { return r.CoversWith(e.Key, t.defaultCF.opts.Comparator.Compare) }) {
+			value, found, err := t.getAt(ctx, t.defaultCF, e.Key, t.seq)
+			if err != nil {
+				return nil, err
+			}
+			if found {
+				old = value
+			}
+		}
+		var value []byte
+		switch {
+		case e.Tombstone:
+		case e.Operand:
+			value = mergeValue(t.defaultCF.opts.MergeOperator, e.Key, old, [][]byte{e.Value})
+		default:
+			value = e.Value
+		}
+		pending[string(e.Key)] = value
+
+		for _, idx := range t.indexes {
+			current := secondaryKeys(idx.fn, e.Key, value)
+			for _, s := range secondaryKeys(idx.fn, e.Key, old) {
+				if !slices.ContainsFunc(current, func(c []byte) bool { return bytes.Equal(c, s) }) {
+					extra = append(extra, &entry.Entry{Key: indexKey(s, e.Key), Value: []byte{}, Tombstone: true, Family: idx.cf.id})
+				}
+			}
+			for _, s := range current {
+				extra = append(extra, &entry.Entry{Key: indexKey(s, e.Key), Value: bytes.Clone(e.Key), ExpiresAt: e.ExpiresAt, Family: idx.cf.id})
+			}
+		}
+	}
+	if len(extra) == 0 {
+		return entries, nil
+	}
+	return slices.Concat(entries, extra), nil
+}
+
+// IndexIterator yields the keys of the default family indexed under one
+// secondary key, in key order, with their values. It reads the tree as of its
+// creation, holding a snapshot until it is closed.
+type IndexIterator struct {
+	tree      *LSMTree
+	idx       *index
+	secondary []byte
+	snapshot  *Snapshot // keeps the versions both families are read at
+	it        *Iterator // over the index entries for secondary
+	key       []byte
+	value     []byte
+	valid     bool
+	err       error
+}
+
+// LookupByIndex returns an iterator over the keys whose values the index name
+// maps to the secondary key value. Index entries are written in the same WAL
+// record as the writes they follow, so they never drift from the data, even
+// across crashes.
+func (t *LSMTree) LookupByIndex(name string, value []byte) (*IndexIterator, error) {
+	t.mu.RLock()
+	idx, ok := t.indexes[name]
+	t.mu.RUnlock()
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+	prefix := indexKey(value, nil)
+	snapshot := t.NewSnapshot()
+	ii := &IndexIterator{
+		tree:      t,
+		idx:       idx,
+		secondary: bytes.Clone(value),
+		snapshot:  snapshot,
+		it:        t.scan(context.Background(), idx.cf, snapshot, prefix, prefixEnd(prefix)),
+	}
+	ii.findNext()
+	return ii, nil
+}
+
+// findNext moves to the first index entry, from the current one on, whose key
+// still exists and still maps to the secondary key. Entries of keys since
+// deleted by a range tombstone, expired, or changed by a merge are skipped.
+func (ii *IndexIterator) findNext() {
+	t := ii.tree
+	for ; ii.it.Valid(); ii.it.Next() {
+		key := ii.it.Value()
+		t.mu.RLock()
+		value, ok, err := t.getAt(context.Background(), t.defaultCF, key, ii.snapshot.seq)
+		t.mu.RUnlock()
+		if err != nil {
+			ii.err = err
+			break
+		}
+		current := secondaryKeys(ii.idx.fn, key, value)
+		if ok && slices.ContainsFunc(current, func(s []byte) bool { return bytes.Equal(s, ii.secondary) }) {
+			ii.key, ii.value, ii.valid = key, value, true
+			return
+		}
+	}
+	ii.valid = false
+}
+
+// Next advances to the next matching key.
+func (ii *IndexIterator) Next() {
+	if !ii.valid {
+		return
+	}
+	ii.it.Next()
+	ii.findNext()
+}
+
+// Valid reports whether the iterator is positioned at a matching key.
+func (ii *IndexIterator) Valid() bool { return ii.valid }
+
+// Key returns the current primary key. Only valid while Valid() is true.
+func (ii *IndexIterator) Key() []byte { return ii.key }
+
+// Value returns the current key's value. Only valid while Valid() is true.
+func (ii *IndexIterator) Value() []byte { return ii.value }
+
+// Err returns the first error encountered while iterating.
+func (ii *IndexIterator) Err() error {
+	if ii.err != nil {
+		return ii.err
+	}
+	return ii.it.Err()
+}
+
+// Close releases the iterator's resources and snapshot, and returns Err().
+func (ii *IndexIterator) Close() error {
+	ii.valid = false
+	ii.snapshot.Release()
+	if err := ii.it.Close(); err != nil {
+		return err
+	}
+	return ii.err
+}
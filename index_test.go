@@ -0,0 +1,168 @@
+package lmstree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// cityIndex indexes values of the form "city|name" by city.
+func cityIndex(key, value []byte) [][]byte {
+	city, _, ok := bytes.Cut(value, []byte("|"))
+	if !ok {
+		return nil
+	}
+	return [][]byte{city}
+}
+
+// lookup returns "key=value" for every key the index maps city to.
+func lookup(t *testing.T, tree *LSMTree, city string) []string {
+	t.Helper()
+	it, err := tree.LookupByIndex("city", []byte(city))
+	if err != nil {
+		t.Fatalf("LookupByIndex(%s): %v", city, err)
+	}
+	var got []string
+	for ; it.Valid(); it.Next() {
+		got = append(got, fmt.Sprintf("%s=%s", it.Key(), it.Value()))
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("LookupByIndex(%s): %v", city, err)
+	}
+	return got
+}
+
+func TestLSMTree_LookupByIndexIsPointInTime(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.Indexes = map[string]IndexFunc{"city": cityIndex}
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+	tree.Put([]byte("u1"), []byte("paris|ann"))
+	tree.Put([]byte("u2"), []byte("paris|bob"))
+
+	it, err := tree.LookupByIndex("city", []byte("paris"))
+	if err != nil {
+		t.Fatalf("LookupByIndex: %v", err)
+	}
+	// A flush keeps the version of u2 the iterator reads only while its
+	// snapshot is held.
+	tree.Put([]byte("u2"), []byte("oslo|bob"))
+	tree.mu.Lock()
+	err = tree.flush()
+	tree.mu.Unlock()
+	if err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	var got []string
+	for ; it.Valid(); it.Next() {
+		got = append(got, fmt.Sprintf("%s=%s", it.Key(), it.Value()))
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if fmt.Sprint(got) != "[u1=paris|ann u2=paris|bob]" {
+		t.Errorf("paris = %v, want u1 and u2 as of LookupByIndex", got)
+	}
+	if len(tree.snapshots) != 0 {
+		t.Errorf("Close left %d snapshots", len(tree.snapshots))
+	}
+}
+
+func TestLSMTree_LookupByIndex(t *testing.T) {
+	dir := tempDir(t)
+	opts := DefaultOptions(dir)
+
+	// Data written before the index exists is indexed when it is added.
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	tree.Put([]byte("u1"), []byte("paris|ann"))
+	tree.Put([]byte("u2"), []byte("oslo|bob"))
+	tree.Close()
+
+	opts.Indexes = map[string]IndexFunc{"city": cityIndex}
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if got := fmt.Sprint(lookup(t, tree, "paris")); got != "[u1=paris|ann]" {
+		t.Errorf("built index: paris = %v", got)
+	}
+
+	tree.Put([]byte("u3"), []byte("paris|cid"))
+	tree.Put([]byte("u2"), []byte("paris|bob")) // moves from oslo
+	tree.Delete([]byte("u1"))
+	batch := NewWriteBatch()
+	batch.Put([]byte("u4"), []byte("oslo|dan"))
+	batch.Put([]byte("u4"), []byte("rome|dan")) // the later write wins
+	tree.Write(batch)
+	tree.Put([]byte("u5"), []byte("rome|eve"))
+	tree.DeleteRange([]byte("u5"), []byte("u6"))
+
+	check := func(when string) {
+		t.Helper()
+		if got := fmt.Sprint(lookup(t, tree, "paris")); got != "[u2=paris|bob u3=paris|cid]" {
+			t.Errorf("%s: paris = %v", when, got)
+		}
+		if got := lookup(t, tree, "oslo"); len(got) != 0 {
+			t.Errorf("%s: oslo = %v, want none", when, got)
+		}
+		if got := fmt.Sprint(lookup(t, tree, "rome")); got != "[u4=rome|dan]" {
+			t.Errorf("%s: rome = %v", when, got)
+		}
+	}
+	check("after writes")
+
+	// The stale oslo entries of u2 and u4 are gone, not just skipped.
+	it := tree.scan(t.Context(), tree.indexes["city"].cf, nil, indexKey([]byte("oslo"), nil), prefixEnd(indexKey([]byte("oslo"), nil)))
+	if it.Valid() {
+		t.Errorf("index still holds %q", it.Key())
+	}
+	it.Close()
+
+	if _, err := tree.LookupByIndex("missing", nil); !errors.Is(err, ErrIndexNotFound) {
+		t.Errorf("LookupByIndex(missing) error = %v, want ErrIndexNotFound", err)
+	}
+	if _, err := tree.CreateColumnFamily("index:other", ColumnFamilyOptions{}); err == nil {
+		t.Errorf("CreateColumnFamily accepted a reserved name")
+	}
+	if err := tree.DropColumnFamily("index:city"); err == nil {
+		t.Errorf("DropColumnFamily dropped an index")
+	}
+	tree.Close()
+
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	check("after reopen")
+	tree.Close()
+
+	// Left out, the index is dropped; listed again, it is rebuilt.
+	opts.Indexes = nil
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen without index: %v", err)
+	}
+	if _, ok := tree.ColumnFamily("index:city"); ok {
+		t.Errorf("unlisted index was not dropped")
+	}
+	tree.Put([]byte("u6"), []byte("paris|fay"))
+	tree.Close()
+
+	opts.Indexes = map[string]IndexFunc{"city": cityIndex}
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen with index: %v", err)
+	}
+	defer tree.Close()
+	if got := fmt.Sprint(lookup(t, tree, "paris")); got != "[u2=paris|bob u3=paris|cid u6=paris|fay]" {
+		t.Errorf("rebuilt index: paris = %v", got)
+	}
+}
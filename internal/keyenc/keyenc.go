@@ -0,0 +1,43 @@
+// Package keyenc holds the order-preserving byte-string escaping shared by the
+// key encodings of the typed and tuple packages.
+package keyenc
+
+// AppendEscaped appends b with every 0x00 written as 0x00 0xff, then the
+// terminator 0x00 0x01. The result sorts like b, a string before every longer
+// one it is a prefix of, and ends unambiguously whatever bytes follow it.
+func AppendEscaped(dst, b []byte) []byte {
+	for _, c := range b {
+		if c == 0 {
+			dst = append(dst, 0, 0xff)
+		} else {
+			dst = append(dst, c)
+		}
+	}
+	return append(dst, 0, 1)
+}
+
+// DecodeEscaped reverses AppendEscaped for the string at the start of src and
+// returns the bytes after its terminator. It reports false if src does not
+// start with an escaped string.
+func DecodeEscaped(src []byte) ([]byte, []byte, bool) {
+	b := make([]byte, 0, len(src))
+	for i := 0; i < len(src); i++ {
+		if src[i] != 0 {
+			b = append(b, src[i])
+			continue
+		}
+		if i+1 == len(src) {
+			break
+		}
+		switch src[i+1] {
+		case 0xff:
+			b = append(b, 0)
+			i++
+		case 1:
+			return b, src[i+2:], true
+		default:
+			return nil, nil, false
+		}
+	}
+	return nil, nil, false
+}
@@ -0,0 +1,30 @@
+package keyenc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEscaped_Order(t *testing.T) {
+	strs := [][]byte{{}, {0}, {0, 0}, {0, 1}, {0, 0xff}, {1}, []byte("a"), []byte("a\x00"), []byte("ab")}
+	var prev []byte
+	for i, s := range strs {
+		enc := AppendEscaped(nil, s)
+		if i > 0 && bytes.Compare(prev, enc) >= 0 {
+			t.Errorf("encoding of %x does not sort after that of %x", s, strs[i-1])
+		}
+		got, rest, ok := DecodeEscaped(append(enc, 'x'))
+		if !ok || !bytes.Equal(got, s) || string(rest) != "x" {
+			t.Errorf("DecodeEscaped(%x) = %x, %q, %v", enc, got, rest, ok)
+		}
+		prev = enc
+	}
+}
+
+func TestDecodeEscaped_Invalid(t *testing.T) {
+	for _, src := range [][]byte{{}, {'a'}, {'a', 0}, {'a', 0, 2}} {
+		if _, _, ok := DecodeEscaped(src); ok {
+			t.Errorf("DecodeEscaped(%x) ok, want invalid", src)
+		}
+	}
+}
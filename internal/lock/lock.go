@@ -0,0 +1,165 @@
+package lock
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTimeout is returned when a lock is not granted within the requested timeout.
+	ErrTimeout = errors.New("lock wait timed out")
+	// ErrDeadlock is returned to the waiter whose request would close a cycle in the wait-for graph.
+	ErrDeadlock = errors.New("deadlock detected")
+)
+
+// Mode is the access mode a lock is held in.
+type Mode int
+
+const (
+	// Shared locks may be held by several owners at once.
+	Shared Mode = iota
+	// Exclusive locks exclude every other owner.
+	Exclusive
+)
+
+// keyLock is the state of one locked key.
+type keyLock struct {
+	holders map[uint64]Mode
+	changed chan struct{} // closed and replaced whenever holders shrink
+}
+
+// Manager is a table of key-level shared/exclusive locks. Owners are identified
+// by an opaque uint64 (a transaction id). Locks are re-entrant, a sole shared
+// holder can upgrade to exclusive, and every lock an owner holds is released at
+// once by ReleaseAll.
+//
+// Blocked requests are recorded in a wait-for graph; a request that would close a
+// cycle fails immediately with ErrDeadlock instead of waiting for its timeout.
+type Manager struct {
+	mu       sync.Mutex
+	locks    map[string]*keyLock
+	held     map[uint64]map[string]struct{} // keys held per owner
+	waitsFor map[uint64]map[uint64]struct{} // owner → owners it is blocked on
+}
+
+// NewManager returns an empty lock table.
+func NewManager() *Manager {
+	return &Manager{
+		locks:    make(map[string]*keyLock),
+		held:     make(map[uint64]map[string]struct{}),
+		waitsFor: make(map[uint64]map[uint64]struct{}),
+	}
+}
+
+// Acquire blocks until owner holds key in mode, the timeout expires (ErrTimeout)
+// or waiting would deadlock (ErrDeadlock). A timeout <= 0 waits indefinitely,
+// relying on deadlock detection alone.
+func (m *Manager) Acquire(owner uint64, key []byte, mode Mode, timeout time.Duration) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	k := string(key)
+	m.mu.Lock()
+	for {
+		kl := m.locks[k]
+		if kl == nil {
+			kl = &keyLock{holders: make(map[uint64]Mode), changed: make(chan struct{})}
+			m.locks[k] = kl
+		}
+
+		blockers := kl.blockers(owner, mode)
+		if len(blockers) == 0 {
+			delete(m.waitsFor, owner)
+			if held, ok := kl.holders[owner]; !ok || mode > held {
+				kl.holders[owner] = mode
+			}
+			if m.held[owner] == nil {
+				m.held[owner] = make(map[string]struct{})
+			}
+			m.held[owner][k] = struct{}{}
+			m.mu.Unlock()
+			return nil
+		}
+
+		m.waitsFor[owner] = blockers
+		if m.reaches(blockers, owner) {
+			delete(m.waitsFor, owner)
+			m.mu.Unlock()
+			return ErrDeadlock
+		}
+
+		changed := kl.changed
+		m.mu.Unlock()
+		select {
+		case <-changed:
+		case <-deadline:
+			m.mu.Lock()
+			delete(m.waitsFor, owner)
+			m.mu.Unlock()
+			return ErrTimeout
+		}
+		m.mu.Lock()
+	}
+}
+
+// ReleaseAll releases every lock held by owner and wakes the owners waiting on them.
+func (m *Manager) ReleaseAll(owner uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k := range m.held[owner] {
+		kl := m.locks[k]
+		delete(kl.holders, owner)
+		close(kl.changed)
+		kl.changed = make(chan struct{})
+		if len(kl.holders) == 0 {
+			delete(m.locks, k)
+		}
+	}
+	delete(m.held, owner)
+	delete(m.waitsFor, owner)
+}
+
+// blockers returns the holders that prevent owner from taking the lock in mode.
+func (kl *keyLock) blockers(owner uint64, mode Mode) map[uint64]struct{} {
+	var blockers map[uint64]struct{}
+	for holder, held := range kl.holders {
+		if holder == owner || (mode == Shared && held == Shared) {
+			continue
+		}
+		if blockers == nil {
+			blockers = make(map[uint64]struct{})
+		}
+		blockers[holder] = struct{}{}
+	}
+	return blockers
+}
+
+// reaches reports whether target is reachable from any of from in the wait-for graph.
+func (m *Manager) reaches(from map[uint64]struct{}, target uint64) bool {
+	visited := make(map[uint64]bool)
+	stack := make([]uint64, 0, len(from))
+	for o := range from {
+		stack = append(stack, o)
+	}
+	for len(stack) > 0 {
+		o := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if o == target {
+			return true
+		}
+		if visited[o] {
+			continue
+		}
+		visited[o] = true
+		for next := range m.waitsFor[o] {
+			stack = append(stack, next)
+		}
+	}
+	return false
+}
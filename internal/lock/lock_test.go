@@ -0,0 +1,108 @@
+package lock
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestManager_SharedAndExclusive(t *testing.T) {
+	m := NewManager()
+	key := []byte("k")
+
+	if err := m.Acquire(1, key, Shared, 0); err != nil {
+		t.Fatalf("Acquire shared 1: %v", err)
+	}
+	if err := m.Acquire(2, key, Shared, 0); err != nil {
+		t.Fatalf("Acquire shared 2: %v", err)
+	}
+	if err := m.Acquire(3, key, Exclusive, 20*time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Acquire exclusive 3 = %v, want ErrTimeout", err)
+	}
+
+	// Once the readers release, a blocked writer is woken and granted the lock.
+	granted := make(chan error, 1)
+	go func() { granted <- m.Acquire(3, key, Exclusive, time.Second) }()
+	m.ReleaseAll(1)
+	m.ReleaseAll(2)
+	if err := <-granted; err != nil {
+		t.Fatalf("Acquire exclusive 3 after release: %v", err)
+	}
+	if err := m.Acquire(3, key, Shared, 0); err != nil {
+		t.Fatalf("re-entrant Acquire: %v", err)
+	}
+	if err := m.Acquire(1, key, Shared, 20*time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Acquire shared 1 under exclusive = %v, want ErrTimeout", err)
+	}
+	m.ReleaseAll(3)
+	if len(m.locks) != 0 || len(m.held) != 0 || len(m.waitsFor) != 0 {
+		t.Fatalf("lock table not empty after release: %d locks, %d owners, %d waiters", len(m.locks), len(m.held), len(m.waitsFor))
+	}
+}
+
+func TestManager_Upgrade(t *testing.T) {
+	m := NewManager()
+	key := []byte("k")
+
+	m.Acquire(1, key, Shared, 0)
+	if err := m.Acquire(1, key, Exclusive, 0); err != nil {
+		t.Fatalf("sole holder upgrade: %v", err)
+	}
+	m.ReleaseAll(1)
+
+	// Two shared holders both upgrading is the classic upgrade deadlock.
+	m.Acquire(1, key, Shared, 0)
+	m.Acquire(2, key, Shared, 0)
+	first := make(chan error, 1)
+	go func() { first <- m.Acquire(1, key, Exclusive, time.Second) }()
+	waitForWaiter(t, m, 1)
+	if err := m.Acquire(2, key, Exclusive, time.Second); !errors.Is(err, ErrDeadlock) {
+		t.Fatalf("second upgrade = %v, want ErrDeadlock", err)
+	}
+	m.ReleaseAll(2)
+	if err := <-first; err != nil {
+		t.Fatalf("first upgrade after victim released: %v", err)
+	}
+}
+
+func TestManager_DeadlockCycle(t *testing.T) {
+	m := NewManager()
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+
+	m.Acquire(1, a, Exclusive, 0)
+	m.Acquire(2, b, Exclusive, 0)
+	m.Acquire(3, c, Exclusive, 0)
+
+	// 1 → 2 → 3 → 1: the request that closes the cycle is refused.
+	results := make(chan error, 2)
+	go func() { results <- m.Acquire(1, b, Exclusive, time.Second) }()
+	waitForWaiter(t, m, 1)
+	go func() { results <- m.Acquire(2, c, Exclusive, time.Second) }()
+	waitForWaiter(t, m, 2)
+	if err := m.Acquire(3, a, Exclusive, time.Second); !errors.Is(err, ErrDeadlock) {
+		t.Fatalf("Acquire closing the cycle = %v, want ErrDeadlock", err)
+	}
+
+	m.ReleaseAll(3)
+	if err := <-results; err != nil {
+		t.Fatalf("waiter after victim released: %v", err)
+	}
+	m.ReleaseAll(2)
+	if err := <-results; err != nil {
+		t.Fatalf("waiter after second release: %v", err)
+	}
+}
+
+// waitForWaiter blocks until owner is recorded as waiting in the wait-for graph.
+func waitForWaiter(t *testing.T, m *Manager, owner uint64) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		m.mu.Lock()
+		_, waiting := m.waitsFor[owner]
+		m.mu.Unlock()
+		if waiting {
+			return
+		}
+	}
+	t.Fatalf("owner %d never started waiting", owner)
+}
@@ -1,17 +1,15 @@
 package memtable
 
 import (
+	"bytes"
 	"errors"
 	"math/rand"
-	"os"
-	"path/filepath"
 	"slices"
 	"sync"
 	"time"
 
 	"github.com/maksymus/lmstree/entry"
 	"github.com/maksymus/lmstree/internal/skiplist"
-	walPkg "github.com/maksymus/lmstree/internal/wal"
 )
 
 // ErrReadonly is returned when a write is attempted on a readonly MemTable.
@@ -20,27 +18,39 @@ var ErrReadonly = errors.New("memtable is readonly")
 // WAL is the interface used by MemTable for write-ahead logging.
 type WAL interface {
 	Write(entries ...*entry.Entry) error
-	CompareVersion(version string) int
 }
 
 // MemTable represents an in-memory table with a skip list and a write-ahead log.
+// Range tombstones are kept apart from the skip list, in the order applied.
 type MemTable struct {
-	mutex    sync.Mutex
-	list     *skiplist.SkipList
-	wal      WAL
-	dir      string
-	size     int64
-	readonly bool
+	mutex     sync.Mutex
+	list      *skiplist.SkipList
+	rangeDels []*entry.Entry
+	wal       WAL
+	size      int64
+	maxSeq    uint64
+	readonly  bool
 }
 
-// NewMemTable creates a new MemTable with the specified directory, skip list level, and WAL.
-func NewMemTable(dir string, level int, wal WAL) *MemTable {
-	list := skiplist.NewSkipList(level, rand.New(rand.NewSource(time.Now().Unix())))
-	return &MemTable{list: list, wal: wal, dir: dir}
+// NewMemTable creates a new MemTable with the specified skip list level and WAL.
+func NewMemTable(level int, wal WAL) *MemTable {
+	return NewMemTableWith(level, wal, bytes.Compare)
+}
+
+// NewMemTableWith creates a new MemTable whose skip list orders keys by cmp.
+func NewMemTableWith(level int, wal WAL, cmp func(a, b []byte) int) *MemTable {
+	list := skiplist.NewSkipListWith(level, rand.New(rand.NewSource(time.Now().Unix())), cmp)
+	return &MemTable{list: list, wal: wal}
 }
 
 // Set adds a key-value pair to the MemTable.
 func (m *MemTable) Set(key, value []byte) error {
+	return m.Apply(&entry.Entry{Key: key, Value: value})
+}
+
+// Apply writes entries to the WAL as one record and inserts them into the skip
+// list under a single lock acquisition, so readers never observe a partial batch.
+func (m *MemTable) Apply(entries ...*entry.Entry) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
@@ -48,15 +58,31 @@ func (m *MemTable) Set(key, value []byte) error {
 		return ErrReadonly
 	}
 
-	e := &entry.Entry{Key: key, Value: value}
-	if err := m.wal.Write(e); err != nil {
+	if err := m.wal.Write(entries...); err != nil {
 		return err
 	}
-	m.list.InsertEntry(e)
-	m.size += int64(len(key) + len(value))
+	m.insert(entries)
 	return nil
 }
 
+// insert adds entries to the skip list and accounts for their size. Must be called with m.mutex held.
+func (m *MemTable) insert(entries []*entry.Entry) {
+	for _, e := range entries {
+		m.maxSeq = max(m.maxSeq, e.Seq)
+		if e.RangeDelete {
+			m.rangeDels = append(m.rangeDels, e)
+			m.size += int64(len(e.Key) + len(e.Value))
+			continue
+		}
+		m.list.InsertEntry(e)
+		if e.Tombstone {
+			m.size += int64(len(e.Key) + 1)
+		} else {
+			m.size += int64(len(e.Key) + len(e.Value))
+		}
+	}
+}
+
 // Get retrieves the value for the given key. Does not expose tombstone status.
 func (m *MemTable) Get(key []byte) ([]byte, bool) {
 	m.mutex.Lock()
@@ -69,29 +95,37 @@ func (m *MemTable) Get(key []byte) ([]byte, bool) {
 	return e.Value, true
 }
 
-// GetEntry retrieves the full Entry for the given key, including tombstone status.
+// GetEntry retrieves the newest Entry for the given key, including tombstone status.
 func (m *MemTable) GetEntry(key []byte) (*entry.Entry, bool) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	return m.list.GetEntry(key)
 }
 
-// Delete marks the given key as deleted by inserting a tombstone entry.
-func (m *MemTable) Delete(key []byte) error {
+// GetEntryAt retrieves the newest Entry for key with a sequence number <= seq.
+func (m *MemTable) GetEntryAt(key []byte, seq uint64) (*entry.Entry, bool) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
+	return m.list.GetEntryAt(key, seq)
+}
 
-	if m.readonly {
-		return ErrReadonly
+// GetEntriesAt looks up several keys under one lock acquisition. The result is
+// aligned with keys: the newest Entry with a sequence number <= seq, or nil.
+func (m *MemTable) GetEntriesAt(keys [][]byte, seq uint64) []*entry.Entry {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entries := make([]*entry.Entry, len(keys))
+	for i, key := range keys {
+		if e, ok := m.list.GetEntryAt(key, seq); ok {
+			entries[i] = e
+		}
 	}
+	return entries
+}
 
-	e := &entry.Entry{Key: key, Value: []byte{}, Tombstone: true}
-	if err := m.wal.Write(e); err != nil {
-		return err
-	}
-	m.list.InsertEntry(e)
-	m.size += int64(len(key) + 1)
-	return nil
+// Delete marks the given key as deleted by inserting a tombstone entry.
+func (m *MemTable) Delete(key []byte) error {
+	return m.Apply(&entry.Entry{Key: key, Value: []byte{}, Tombstone: true})
 }
 
 // Size returns the approximate byte size of entries written to the MemTable.
@@ -101,63 +135,119 @@ func (m *MemTable) Size() int64 {
 	return m.size
 }
 
-// Entries returns all entries in sorted key order, deduplicated.
+// ApproximateRange estimates the bytes and entries, counting every version, the
+// MemTable holds in [start, end) from a sample of its skip list. A nil start or
+// end leaves that side unbounded.
+func (m *MemTable) ApproximateRange(start, end []byte) (size, count int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	keys, stride := m.list.Sample(start, end)
+	count = min(int64(len(keys)*stride), int64(m.list.Len()))
+	if m.list.Len() > 0 {
+		size = m.size * count / int64(m.list.Len())
+	}
+	return size, count
+}
+
+// Sample returns the keys of a sample of the skip-list nodes in [start, end), in
+// key order, and the bytes each stands for.
+func (m *MemTable) Sample(start, end []byte) (keys [][]byte, size int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	keys, stride := m.list.Sample(start, end)
+	if m.list.Len() > 0 {
+		size = m.size * int64(stride) / int64(m.list.Len())
+	}
+	return keys, size
+}
+
+// Entries returns the newest version of every key in sorted key order.
 func (m *MemTable) Entries() []*entry.Entry {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	return m.list.Entries()
 }
 
-// Recover replays older WAL files to restore the MemTable state after a crash.
-func (m *MemTable) Recover() error {
+// AllEntries returns every version of every key, by key ascending then newest first.
+func (m *MemTable) AllEntries() []*entry.Entry {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
+	return m.list.AllEntries()
+}
 
-	dirs, err := os.ReadDir(m.dir)
-	if err != nil {
-		return err
-	}
+// RangeTombstones returns the range tombstones applied to the MemTable.
+func (m *MemTable) RangeTombstones() []*entry.Entry {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return slices.Clone(m.rangeDels)
+}
 
-	var files []string
-	for _, file := range dirs {
-		if version, err := walPkg.VersionFromFileName(file.Name()); err == nil {
-			if !file.IsDir() && m.wal.CompareVersion(version) < 0 {
-				files = append(files, file.Name())
-			}
-		}
-	}
+// MaxSeq returns the highest sequence number applied to the MemTable.
+func (m *MemTable) MaxSeq() uint64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.maxSeq
+}
 
-	if len(files) == 0 {
-		return nil
-	}
+// Iterator walks every version in a MemTable, by key ascending then newest
+// first. Every step takes the MemTable lock, so writers may keep inserting
+// while an iterator is open.
+type Iterator struct {
+	m    *MemTable
+	iter *skiplist.Iterator
+}
 
-	slices.Sort(files)
+// NewIterator returns an unpositioned iterator over the MemTable.
+func (m *MemTable) NewIterator() *Iterator {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return &Iterator{m: m, iter: m.list.NewIterator()}
+}
 
-	for _, file := range files {
-		walFile, err := walPkg.Open(filepath.Join(m.dir, file))
-		if err != nil {
-			return err
-		}
+// Valid reports whether the iterator is positioned at an entry.
+func (it *Iterator) Valid() bool { return it.iter.Valid() }
 
-		entries, err := walFile.Read()
-		if err != nil {
-			return err
-		}
+// Entry returns a copy of the entry at the current position, including tombstones.
+func (it *Iterator) Entry() *entry.Entry {
+	it.m.mutex.Lock()
+	defer it.m.mutex.Unlock()
+	return it.iter.Entry()
+}
 
-		for _, e := range entries {
-			if err := m.wal.Write(e); err != nil {
-				return err
-			}
-			m.list.InsertEntry(e)
-			if !e.Tombstone {
-				m.size += int64(len(e.Key) + len(e.Value))
-			}
-		}
+// SeekToFirst positions the iterator at the smallest key.
+func (it *Iterator) SeekToFirst() {
+	it.m.mutex.Lock()
+	defer it.m.mutex.Unlock()
+	it.iter.SeekToFirst()
+}
 
-		if err := walFile.Delete(); err != nil {
-			return err
-		}
-	}
+// Seek positions the iterator at the first key >= key.
+func (it *Iterator) Seek(key []byte) {
+	it.m.mutex.Lock()
+	defer it.m.mutex.Unlock()
+	it.iter.Seek(key)
+}
 
-	return nil
+// SeekToLast positions the iterator at the largest key.
+func (it *Iterator) SeekToLast() {
+	it.m.mutex.Lock()
+	defer it.m.mutex.Unlock()
+	it.iter.SeekToLast()
+}
+
+// Next advances to the following entry.
+func (it *Iterator) Next() {
+	it.m.mutex.Lock()
+	defer it.m.mutex.Unlock()
+	it.iter.Next()
 }
+
+// Prev moves back to the preceding entry.
+func (it *Iterator) Prev() {
+	it.m.mutex.Lock()
+	defer it.m.mutex.Unlock()
+	it.iter.Prev()
+}
+
+// Err always returns nil; MemTable iteration cannot fail.
+func (it *Iterator) Err() error { return nil }
@@ -9,7 +9,7 @@ import (
 )
 
 func TestNewMemTable(t *testing.T) {
-	mt := NewMemTable("/tmp", 5, &wal.NoopWAL{})
+	mt := NewMemTable(5, &wal.NoopWAL{})
 	if mt == nil {
 		t.Fatal("expected non-nil MemTable")
 	}
@@ -25,7 +25,7 @@ func TestNewMemTable(t *testing.T) {
 }
 
 func TestMemTable_Set(t *testing.T) {
-	mt := NewMemTable("/tmp", 5, &wal.NoopWAL{})
+	mt := NewMemTable(5, &wal.NoopWAL{})
 
 	if err := mt.Set([]byte("key1"), []byte("value1")); err != nil {
 		t.Fatalf("Set returned unexpected error: %v", err)
@@ -41,7 +41,7 @@ func TestMemTable_Set(t *testing.T) {
 }
 
 func TestMemTable_Set_Readonly(t *testing.T) {
-	mt := NewMemTable("/tmp", 5, &wal.NoopWAL{})
+	mt := NewMemTable(5, &wal.NoopWAL{})
 	mt.readonly = true
 
 	err := mt.Set([]byte("key1"), []byte("value1"))
@@ -51,7 +51,7 @@ func TestMemTable_Set_Readonly(t *testing.T) {
 }
 
 func TestMemTable_Get_Found(t *testing.T) {
-	mt := NewMemTable("/tmp", 5, &wal.NoopWAL{})
+	mt := NewMemTable(5, &wal.NoopWAL{})
 	mt.Set([]byte("hello"), []byte("world"))
 
 	val, ok := mt.Get([]byte("hello"))
@@ -64,7 +64,7 @@ func TestMemTable_Get_Found(t *testing.T) {
 }
 
 func TestMemTable_Get_NotFound(t *testing.T) {
-	mt := NewMemTable("/tmp", 5, &wal.NoopWAL{})
+	mt := NewMemTable(5, &wal.NoopWAL{})
 	_, ok := mt.Get([]byte("missing"))
 	if ok {
 		t.Fatal("expected key to not be found")
@@ -72,7 +72,7 @@ func TestMemTable_Get_NotFound(t *testing.T) {
 }
 
 func TestMemTable_ConcurrentSetGet(t *testing.T) {
-	mt := NewMemTable("/tmp", 5, &wal.NoopWAL{})
+	mt := NewMemTable(5, &wal.NoopWAL{})
 	const goroutines = 50
 	const iterations = 100
 
@@ -101,3 +101,31 @@ func TestMemTable_ConcurrentSetGet(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestMemTable_Iterator(t *testing.T) {
+	mt := NewMemTable(5, &wal.NoopWAL{})
+	mt.Set([]byte("b"), []byte("2"))
+	mt.Set([]byte("a"), []byte("1"))
+	mt.Delete([]byte("c"))
+
+	it := mt.NewIterator()
+	it.SeekToFirst()
+
+	want := []struct {
+		key       string
+		tombstone bool
+	}{{"a", false}, {"b", false}, {"c", true}}
+	for _, w := range want {
+		if !it.Valid() {
+			t.Fatalf("iterator exhausted before %s", w.key)
+		}
+		e := it.Entry()
+		if string(e.Key) != w.key || e.Tombstone != w.tombstone {
+			t.Fatalf("got (%s, %v), want (%s, %v)", e.Key, e.Tombstone, w.key, w.tombstone)
+		}
+		it.Next()
+	}
+	if it.Valid() {
+		t.Fatal("expected iterator to be exhausted")
+	}
+}
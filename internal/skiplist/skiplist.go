@@ -8,12 +8,16 @@ import (
 )
 
 // SkipList is a probabilistic data structure for sorted key-value storage.
+// Entries inserted with InsertEntry are versioned: they are ordered by key
+// ascending and then by sequence number descending, so the newest version of a
+// key is the first node carrying it.
 type SkipList struct {
 	head         *SkipListNode
 	maxLevel     int
 	currentLevel int
 	length       int
 	rand         *rand.Rand
+	cmp          func(a, b []byte) int
 }
 
 // SkipListNode is a node in the skip list.
@@ -23,14 +27,22 @@ type SkipListNode struct {
 	level   int
 }
 
-// NewSkipList creates a new skip list with the specified maximum level.
+// NewSkipList creates a new skip list with the specified maximum level, ordering
+// keys bytewise.
 func NewSkipList(maxLevel int, rand *rand.Rand) *SkipList {
+	return NewSkipListWith(maxLevel, rand, bytes.Compare)
+}
+
+// NewSkipListWith creates a new skip list that orders keys by cmp. cmp must
+// return 0 only for identical keys.
+func NewSkipListWith(maxLevel int, rand *rand.Rand, cmp func(a, b []byte) int) *SkipList {
 	return &SkipList{
 		head:         &SkipListNode{forward: make([]*SkipListNode, maxLevel)},
 		maxLevel:     maxLevel,
 		currentLevel: 0,
 		length:       0,
 		rand:         rand,
+		cmp:          cmp,
 	}
 }
 
@@ -56,7 +68,7 @@ func (sl *SkipList) Insert(key []byte, value []byte) {
 
 	current := sl.head
 	for i := sl.currentLevel; i >= 0; i-- {
-		for current.forward[i] != nil && bytes.Compare(current.forward[i].Key, key) < 0 {
+		for current.forward[i] != nil && sl.cmp(current.forward[i].Key, key) < 0 {
 			current = current.forward[i]
 		}
 		if i <= node.level {
@@ -72,7 +84,7 @@ func (sl *SkipList) Insert(key []byte, value []byte) {
 func (sl *SkipList) Get(key []byte) ([]byte, bool) {
 	current := sl.head
 	for i := sl.currentLevel; i >= 0; i-- {
-		for current.forward[i] != nil && bytes.Compare(current.forward[i].Key, key) < 0 {
+		for current.forward[i] != nil && sl.cmp(current.forward[i].Key, key) < 0 {
 			current = current.forward[i]
 		}
 		if current.forward[i] != nil && bytes.Equal(current.forward[i].Key, key) {
@@ -89,7 +101,7 @@ func (sl *SkipList) Delete(key []byte) bool {
 	found := false
 
 	for i := sl.currentLevel; i >= 0; i-- {
-		for current.forward[i] != nil && bytes.Compare(current.forward[i].Key, key) < 0 {
+		for current.forward[i] != nil && sl.cmp(current.forward[i].Key, key) < 0 {
 			current = current.forward[i]
 		}
 		if current.forward[i] != nil && bytes.Equal(current.forward[i].Key, key) {
@@ -111,7 +123,7 @@ func (sl *SkipList) Update(key []byte, value []byte) bool {
 	found := false
 
 	for i := sl.currentLevel; i >= 0; i-- {
-		for current.forward[i] != nil && bytes.Compare(current.forward[i].Key, key) < 0 {
+		for current.forward[i] != nil && sl.cmp(current.forward[i].Key, key) < 0 {
 			current = current.forward[i]
 		}
 		if current.forward[i] != nil && bytes.Equal(current.forward[i].Key, key) {
@@ -141,18 +153,33 @@ func (sl *SkipList) Reset() {
 	sl.length = 0
 }
 
-// InsertEntry upserts an Entry into the skip list.
+// compare orders (key, seq) pairs by key ascending, then seq descending.
+func (sl *SkipList) compare(aKey []byte, aSeq uint64, bKey []byte, bSeq uint64) int {
+	if c := sl.cmp(aKey, bKey); c != 0 {
+		return c
+	}
+	switch {
+	case aSeq > bSeq:
+		return -1
+	case aSeq < bSeq:
+		return 1
+	}
+	return 0
+}
+
+// InsertEntry adds an Entry as a new version of its key. An existing node with the
+// same key and sequence number is replaced in place.
 func (sl *SkipList) InsertEntry(e *entry.Entry) {
 	update := make([]*SkipListNode, sl.maxLevel)
 	current := sl.head
 	for i := sl.currentLevel; i >= 0; i-- {
-		for current.forward[i] != nil && bytes.Compare(current.forward[i].Key, e.Key) < 0 {
+		for current.forward[i] != nil && sl.compare(current.forward[i].Key, current.forward[i].Seq, e.Key, e.Seq) < 0 {
 			current = current.forward[i]
 		}
 		update[i] = current
 	}
 
-	if next := update[0].forward[0]; next != nil && bytes.Equal(next.Key, e.Key) {
+	if next := update[0].forward[0]; next != nil && bytes.Equal(next.Key, e.Key) && next.Seq == e.Seq {
 		next.Entry = *e
 		return
 	}
@@ -182,22 +209,33 @@ func (sl *SkipList) InsertEntry(e *entry.Entry) {
 	sl.length++
 }
 
-// GetEntry retrieves the Entry (including tombstone status) for the given key.
+// GetEntry retrieves the newest version (including tombstone status) of the given key.
 func (sl *SkipList) GetEntry(key []byte) (*entry.Entry, bool) {
+	node := sl.findGreaterOrEqual(key)
+	if node == nil || !bytes.Equal(node.Key, key) {
+		return nil, false
+	}
+	e := node.Entry
+	return &e, true
+}
+
+// GetEntryAt retrieves the newest version of key whose sequence number is <= seq.
+func (sl *SkipList) GetEntryAt(key []byte, seq uint64) (*entry.Entry, bool) {
 	current := sl.head
 	for i := sl.currentLevel; i >= 0; i-- {
-		for current.forward[i] != nil && bytes.Compare(current.forward[i].Key, key) < 0 {
+		for current.forward[i] != nil && sl.compare(current.forward[i].Key, current.forward[i].Seq, key, seq) < 0 {
 			current = current.forward[i]
 		}
-		if current.forward[i] != nil && bytes.Equal(current.forward[i].Key, key) {
-			e := current.forward[i].Entry
-			return &e, true
-		}
 	}
-	return nil, false
+	node := current.forward[0]
+	if node == nil || !bytes.Equal(node.Key, key) {
+		return nil, false
+	}
+	e := node.Entry
+	return &e, true
 }
 
-// Entries returns all entries in sorted key order, deduplicated.
+// Entries returns the newest version of every key in sorted key order.
 func (sl *SkipList) Entries() []*entry.Entry {
 	result := make([]*entry.Entry, 0, sl.length)
 	current := sl.head.forward[0]
@@ -213,11 +251,21 @@ func (sl *SkipList) Entries() []*entry.Entry {
 	return result
 }
 
+// AllEntries returns every version of every key, by key ascending then newest first.
+func (sl *SkipList) AllEntries() []*entry.Entry {
+	result := make([]*entry.Entry, 0, sl.length)
+	for current := sl.head.forward[0]; current != nil; current = current.forward[0] {
+		e := current.Entry
+		result = append(result, &e)
+	}
+	return result
+}
+
 // LowerBound finds the smallest key >= the given key.
 func (sl *SkipList) LowerBound(key []byte) ([]byte, bool) {
 	current := sl.head
 	for i := sl.currentLevel; i >= 0; i-- {
-		for current.forward[i] != nil && bytes.Compare(current.forward[i].Key, key) < 0 {
+		for current.forward[i] != nil && sl.cmp(current.forward[i].Key, key) < 0 {
 			current = current.forward[i]
 		}
 		if current.forward[i] != nil && bytes.Equal(current.forward[i].Key, key) {
@@ -230,3 +278,116 @@ func (sl *SkipList) LowerBound(key []byte) ([]byte, bool) {
 	var zeroValue []byte
 	return zeroValue, false
 }
+
+// Len returns the number of nodes, counting every version of a key.
+func (sl *SkipList) Len() int { return sl.length }
+
+// minSamples is the number of nodes Sample looks for at one level before
+// settling for it.
+const minSamples = 64
+
+// Sample returns the keys of a sample of the nodes with keys in [start, end), in
+// key order, each standing for about stride nodes. A nil start or end leaves
+// that side unbounded. It takes the nodes of the highest level that has at least
+// minSamples of them in range — a node reaches level l with probability 1/2^l —
+// so it visits O(minSamples + log n) nodes rather than all of them.
+func (sl *SkipList) Sample(start, end []byte) (keys [][]byte, stride int) {
+	for level := sl.currentLevel; level >= 0; level-- {
+		keys = keys[:0]
+		current := sl.head
+		if start != nil {
+			for i := sl.currentLevel; i >= level; i-- {
+				for current.forward[i] != nil && sl.cmp(current.forward[i].Key, start) < 0 {
+					current = current.forward[i]
+				}
+			}
+		}
+		for node := current.forward[level]; node != nil; node = node.forward[level] {
+			if end != nil && sl.cmp(node.Key, end) >= 0 {
+				break
+			}
+			keys = append(keys, node.Key)
+		}
+		if len(keys) >= minSamples || level == 0 {
+			return keys, 1 << level
+		}
+	}
+	return nil, 1
+}
+
+// findGreaterOrEqual returns the first node whose key is >= key, or nil. For a
+// versioned key this is its newest version.
+func (sl *SkipList) findGreaterOrEqual(key []byte) *SkipListNode {
+	current := sl.head
+	for i := sl.currentLevel; i >= 0; i-- {
+		for current.forward[i] != nil && sl.cmp(current.forward[i].Key, key) < 0 {
+			current = current.forward[i]
+		}
+	}
+	return current.forward[0]
+}
+
+// findLessThan returns the last node ordered before (key, seq), or nil.
+func (sl *SkipList) findLessThan(key []byte, seq uint64) *SkipListNode {
+	current := sl.head
+	for i := sl.currentLevel; i >= 0; i-- {
+		for current.forward[i] != nil && sl.compare(current.forward[i].Key, current.forward[i].Seq, key, seq) < 0 {
+			current = current.forward[i]
+		}
+	}
+	if current == sl.head {
+		return nil
+	}
+	return current
+}
+
+// findLast returns the node with the largest key, or nil if the list is empty.
+func (sl *SkipList) findLast() *SkipListNode {
+	current := sl.head
+	for i := sl.currentLevel; i >= 0; i-- {
+		for current.forward[i] != nil {
+			current = current.forward[i]
+		}
+	}
+	if current == sl.head {
+		return nil
+	}
+	return current
+}
+
+// Iterator is a cursor over the level-0 list of a SkipList.
+// Nodes have no backward links, so Prev is a predecessor search in O(log n).
+// It is not safe for concurrent use with writers; callers must synchronize.
+type Iterator struct {
+	list *SkipList
+	node *SkipListNode
+}
+
+// NewIterator returns an unpositioned iterator. Call SeekToFirst or Seek before use.
+func (sl *SkipList) NewIterator() *Iterator {
+	return &Iterator{list: sl}
+}
+
+// Valid reports whether the iterator is positioned at an entry.
+func (it *Iterator) Valid() bool { return it.node != nil }
+
+// Entry returns a copy of the entry at the current position.
+func (it *Iterator) Entry() *entry.Entry {
+	e := it.node.Entry
+	return &e
+}
+
+// SeekToFirst positions the iterator at the smallest key.
+func (it *Iterator) SeekToFirst() { it.node = it.list.head.forward[0] }
+
+// Seek positions the iterator at the newest version of the first key >= key.
+func (it *Iterator) Seek(key []byte) { it.node = it.list.findGreaterOrEqual(key) }
+
+// SeekToLast positions the iterator at the largest key.
+func (it *Iterator) SeekToLast() { it.node = it.list.findLast() }
+
+// Next advances to the following entry.
+func (it *Iterator) Next() { it.node = it.node.forward[0] }
+
+// Prev moves back to the preceding entry.
+func (it *Iterator) Prev() { it.node = it.list.findLessThan(it.node.Key, it.node.Seq) }
@@ -6,6 +6,8 @@ import (
 	"math/rand"
 	"testing"
 	"time"
+
+	"github.com/maksymus/lmstree/entry"
 )
 
 func TestSkipList_Insert(t *testing.T) {
@@ -177,3 +179,105 @@ func TestSkipList_LowerBound_Empty(t *testing.T) {
 		t.Error("expected LowerBound on empty list to return false")
 	}
 }
+
+func TestSkipList_Iterator(t *testing.T) {
+	list := NewSkipList(5, rand.New(rand.NewSource(0)))
+	for _, k := range []string{"c", "a", "e", "b"} {
+		list.InsertEntry(&entry.Entry{Key: []byte(k), Value: []byte(k)})
+	}
+
+	it := list.NewIterator()
+	var got []string
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		got = append(got, string(it.Entry().Key))
+	}
+	if fmt.Sprint(got) != "[a b c e]" {
+		t.Fatalf("iteration order = %v, want [a b c e]", got)
+	}
+
+	it.Seek([]byte("d"))
+	if !it.Valid() || string(it.Entry().Key) != "e" {
+		t.Fatalf("Seek(d) should land on e")
+	}
+	it.Seek([]byte("f"))
+	if it.Valid() {
+		t.Fatal("Seek past the last key should be invalid")
+	}
+}
+
+func TestSkipList_IteratorReverse(t *testing.T) {
+	list := NewSkipList(5, rand.New(rand.NewSource(0)))
+	for i := 0; i < 100; i++ {
+		list.InsertEntry(&entry.Entry{Key: []byte(fmt.Sprintf("key%03d", i))})
+	}
+
+	it := list.NewIterator()
+	i := 99
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		if want := fmt.Sprintf("key%03d", i); string(it.Entry().Key) != want {
+			t.Fatalf("got %s, want %s", it.Entry().Key, want)
+		}
+		i--
+	}
+	if i != -1 {
+		t.Fatalf("reverse iteration stopped at %d", i)
+	}
+
+	empty := NewSkipList(5, rand.New(rand.NewSource(0))).NewIterator()
+	if empty.SeekToLast(); empty.Valid() {
+		t.Fatal("SeekToLast on empty list should be invalid")
+	}
+}
+
+func TestSkipList_Versions(t *testing.T) {
+	list := NewSkipList(5, rand.New(rand.NewSource(0)))
+	for seq := uint64(1); seq <= 5; seq++ {
+		list.InsertEntry(&entry.Entry{Key: []byte("k"), Value: []byte(fmt.Sprintf("v%d", seq)), Seq: seq})
+	}
+	list.InsertEntry(&entry.Entry{Key: []byte("j"), Value: []byte("j"), Seq: 9})
+
+	if e, ok := list.GetEntry([]byte("k")); !ok || e.Seq != 5 {
+		t.Fatalf("GetEntry(k) = %+v, want seq 5", e)
+	}
+	if e, ok := list.GetEntryAt([]byte("k"), 3); !ok || string(e.Value) != "v3" {
+		t.Fatalf("GetEntryAt(k, 3) = %+v, want v3", e)
+	}
+	if _, ok := list.GetEntryAt([]byte("k"), 0); ok {
+		t.Fatal("GetEntryAt(k, 0) should find nothing")
+	}
+	if n := len(list.Entries()); n != 2 {
+		t.Fatalf("Entries() returned %d, want newest of 2 keys", n)
+	}
+	if n := len(list.AllEntries()); n != 6 {
+		t.Fatalf("AllEntries() returned %d, want 6 versions", n)
+	}
+}
+
+func TestSkipList_Sample(t *testing.T) {
+	list := NewSkipList(16, rand.New(rand.NewSource(0)))
+	for i := 0; i < 10000; i++ {
+		list.InsertEntry(&entry.Entry{Key: []byte(fmt.Sprintf("key%05d", i)), Seq: 1})
+	}
+
+	for _, r := range []struct {
+		start, end []byte
+		want       int
+	}{
+		{nil, nil, 10000},
+		{[]byte("key02000"), []byte("key04000"), 2000},
+		{[]byte("key09990"), nil, 10},
+	} {
+		keys, stride := list.Sample(r.start, r.end)
+		for i, key := range keys {
+			if r.start != nil && bytes.Compare(key, r.start) < 0 || r.end != nil && bytes.Compare(key, r.end) >= 0 {
+				t.Fatalf("Sample(%s, %s) key %s out of range", r.start, r.end, key)
+			}
+			if i > 0 && bytes.Compare(keys[i-1], key) >= 0 {
+				t.Fatalf("Sample(%s, %s) keys out of order", r.start, r.end)
+			}
+		}
+		if got := len(keys) * stride; got < r.want*2/3 || got > r.want*3/2 {
+			t.Errorf("Sample(%s, %s) estimates %d nodes, want about %d", r.start, r.end, got, r.want)
+		}
+	}
+}
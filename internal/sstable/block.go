@@ -5,6 +5,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"math"
+	"sort"
 
 	"github.com/maksymus/lmstree/entry"
 	"github.com/maksymus/lmstree/internal/pool"
@@ -20,9 +22,23 @@ type Block struct {
 
 // ---- DataBlock ----
 
-// DataBlock holds a sorted slice of entries encoded on disk.
+// Entry flag bits, stored in the byte after the value. Files written before
+// sequence numbers existed hold 0 or 1 here, which decode unchanged with Seq 0.
+const (
+	flagTombstone uint8 = 1 << 0
+	flagSeq       uint8 = 1 << 1 // a seq(8) field follows the flags byte
+	flagOperand   uint8 = 1 << 2 // the value is a merge operand
+	flagExpiry    uint8 = 1 << 3 // an expiresAt(8) field follows the seq
+	flagRangeDel  uint8 = 1 << 4 // a range tombstone; the value is the range end
+	flagPointer   uint8 = 1 << 5 // the value is a value-log pointer
+)
+
+// DataBlock holds entries sorted by key ascending, then sequence number descending.
+//
+// Encode format per entry: keyLen(4) | valLen(4) | key | value | flags(1) | [seq(8)] | [expiresAt(8)]
 type DataBlock struct {
 	entries []*entry.Entry
+	cmp     Comparator // key order; nil means Bytewise
 }
 
 func (db *DataBlock) Encode() ([]byte, error) {
@@ -33,15 +49,44 @@ func (db *DataBlock) Encode() ([]byte, error) {
 		if e == nil {
 			continue
 		}
+		var flags uint8
+		if e.Tombstone {
+			flags |= flagTombstone
+		}
+		if e.Operand {
+			flags |= flagOperand
+		}
+		if e.RangeDelete {
+			flags |= flagRangeDel
+		}
+		if e.ValuePointer {
+			flags |= flagPointer
+		}
+		if e.ExpiresAt != 0 {
+			flags |= flagExpiry
+		}
+		if e.Seq != 0 {
+			flags |= flagSeq
+		}
 		if err := errors.Join(
 			binary.Write(buffer, binary.BigEndian, uint32(len(e.Key))),
 			binary.Write(buffer, binary.BigEndian, uint32(len(e.Value))),
 			binary.Write(buffer, binary.BigEndian, e.Key),
 			binary.Write(buffer, binary.BigEndian, e.Value),
-			binary.Write(buffer, binary.BigEndian, e.Tombstone),
+			binary.Write(buffer, binary.BigEndian, flags),
 		); err != nil {
 			return nil, err
 		}
+		if flags&flagSeq != 0 {
+			if err := binary.Write(buffer, binary.BigEndian, e.Seq); err != nil {
+				return nil, err
+			}
+		}
+		if flags&flagExpiry != 0 {
+			if err := binary.Write(buffer, binary.BigEndian, e.ExpiresAt); err != nil {
+				return nil, err
+			}
+		}
 	}
 	return bytes.Clone(buffer.Bytes()), nil
 }
@@ -63,32 +108,55 @@ func (db *DataBlock) Decode(data []byte) error {
 
 		key := make([]byte, keyLen)
 		value := make([]byte, valueLen)
-		var tombstone bool
+		var flags uint8
 		if err := errors.Join(
 			binary.Read(reader, binary.BigEndian, &key),
 			binary.Read(reader, binary.BigEndian, &value),
-			binary.Read(reader, binary.BigEndian, &tombstone),
+			binary.Read(reader, binary.BigEndian, &flags),
 		); err != nil {
 			return err
 		}
 
-		db.entries = append(db.entries, &entry.Entry{Key: key, Value: value, Tombstone: tombstone})
+		e := &entry.Entry{
+			Key:          key,
+			Value:        value,
+			Tombstone:    flags&flagTombstone != 0,
+			Operand:      flags&flagOperand != 0,
+			RangeDelete:  flags&flagRangeDel != 0,
+			ValuePointer: flags&flagPointer != 0,
+		}
+		if flags&flagSeq != 0 {
+			if err := binary.Read(reader, binary.BigEndian, &e.Seq); err != nil {
+				return err
+			}
+		}
+		if flags&flagExpiry != 0 {
+			if err := binary.Read(reader, binary.BigEndian, &e.ExpiresAt); err != nil {
+				return err
+			}
+		}
+		db.entries = append(db.entries, e)
 	}
 	return nil
 }
 
+// Search returns the newest version of key in the block.
 func (db *DataBlock) Search(key []byte) (*entry.Entry, bool) {
-	start, end := 0, len(db.entries)-1
-	for start <= end {
-		mid := (start + end) / 2
-		comp := bytes.Compare(db.entries[mid].Key, key)
-		if comp == 0 {
-			return db.entries[mid], true
-		} else if comp < 0 {
-			start = mid + 1
-		} else {
-			end = mid - 1
+	return db.SearchAt(key, math.MaxUint64)
+}
+
+// SearchAt returns the newest version of key whose sequence number is <= seq.
+func (db *DataBlock) SearchAt(key []byte, seq uint64) (*entry.Entry, bool) {
+	cmp := orBytewise(db.cmp)
+	i := sort.Search(len(db.entries), func(i int) bool {
+		e := db.entries[i]
+		if c := cmp.Compare(e.Key, key); c != 0 {
+			return c > 0
 		}
+		return e.Seq <= seq
+	})
+	if i < len(db.entries) && bytes.Equal(db.entries[i].Key, key) {
+		return db.entries[i], true
 	}
 	return nil, false
 }
@@ -99,6 +167,7 @@ func (db *DataBlock) Search(key []byte) (*entry.Entry, bool) {
 type IndexBlock struct {
 	entries []*IndexEntry
 	block   Block
+	cmp     Comparator // key order; nil means Bytewise
 }
 
 // IndexEntry maps a key range [startKey, endKey] to a data block.
@@ -167,12 +236,13 @@ func (ib *IndexBlock) Decode(data []byte) error {
 }
 
 func (ib *IndexBlock) Search(key []byte) (Block, bool) {
+	cmp := orBytewise(ib.cmp)
 	start, end := 0, len(ib.entries)-1
 	for start <= end {
 		mid := (start + end) / 2
-		if bytes.Compare(ib.entries[mid].startKey, key) <= 0 && bytes.Compare(ib.entries[mid].endKey, key) >= 0 {
+		if cmp.Compare(ib.entries[mid].startKey, key) <= 0 && cmp.Compare(ib.entries[mid].endKey, key) >= 0 {
 			return ib.entries[mid].block, true
-		} else if bytes.Compare(ib.entries[mid].startKey, key) < 0 {
+		} else if cmp.Compare(ib.entries[mid].startKey, key) < 0 {
 			start = mid + 1
 		} else {
 			end = mid - 1
@@ -183,14 +253,26 @@ func (ib *IndexBlock) Search(key []byte) (Block, bool) {
 
 // ---- MetaBlock ----
 
-// MetaBlock contains SSTable metadata: creation time, level, and bloom filter bytes.
+// MetaBlock contains SSTable metadata: creation time, level, bloom filter bytes,
+// the highest sequence number stored in the table, the location of its range
+// tombstone block, the name of the comparator its keys are ordered by and the
+// number of point entries it holds.
 type MetaBlock struct {
-	createdAt int64
-	level     int
-	bloom     []byte
+	createdAt  int64
+	level      int
+	bloom      []byte
+	maxSeq     uint64
+	rangeDels  Block  // zero length if the table has no range tombstones
+	comparator string // empty for tables written before it was recorded
+	entries    uint64 // 0 for tables written before it was recorded
 }
 
-// Encode format: createdAt (8) | level (4) | bloomLen (4) | bloom (bloomLen bytes)
+// Encode format: createdAt (8) | level (4) | bloomLen (4) | bloom (bloomLen bytes) | maxSeq (8) |
+// rangeDelOffset (8) | rangeDelLength (8) | comparatorLen (4) | comparator (comparatorLen bytes) |
+// entries (8)
+//
+// Fields after the bloom filter are optional on decode, so tables written before
+// they existed still open.
 func (mb *MetaBlock) Encode() ([]byte, error) {
 	buffer := bytesBufPool.Get()
 	defer bytesBufPool.Put(buffer)
@@ -205,6 +287,20 @@ func (mb *MetaBlock) Encode() ([]byte, error) {
 	if _, err := buffer.Write(mb.bloom); err != nil {
 		return nil, err
 	}
+	if err := errors.Join(
+		binary.Write(buffer, binary.BigEndian, mb.maxSeq),
+		binary.Write(buffer, binary.BigEndian, mb.rangeDels.offset),
+		binary.Write(buffer, binary.BigEndian, mb.rangeDels.length),
+		binary.Write(buffer, binary.BigEndian, uint32(len(mb.comparator))),
+	); err != nil {
+		return nil, err
+	}
+	if _, err := buffer.WriteString(mb.comparator); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buffer, binary.BigEndian, mb.entries); err != nil {
+		return nil, err
+	}
 	return bytes.Clone(buffer.Bytes()), nil
 }
 
@@ -222,8 +318,43 @@ func (mb *MetaBlock) Decode(data []byte) error {
 	}
 	mb.level = int(level)
 	if bloomLen > 0 {
+		if int64(bloomLen) > int64(reader.Len()) {
+			return io.ErrUnexpectedEOF
+		}
 		mb.bloom = make([]byte, bloomLen)
-		if _, err := reader.Read(mb.bloom); err != nil {
+		if _, err := io.ReadFull(reader, mb.bloom); err != nil {
+			return err
+		}
+	}
+	if reader.Len() >= 8 {
+		if err := binary.Read(reader, binary.BigEndian, &mb.maxSeq); err != nil {
+			return err
+		}
+	}
+	if reader.Len() >= 16 {
+		if err := errors.Join(
+			binary.Read(reader, binary.BigEndian, &mb.rangeDels.offset),
+			binary.Read(reader, binary.BigEndian, &mb.rangeDels.length),
+		); err != nil {
+			return err
+		}
+	}
+	if reader.Len() >= 4 {
+		var nameLen uint32
+		if err := binary.Read(reader, binary.BigEndian, &nameLen); err != nil {
+			return err
+		}
+		if int64(nameLen) > int64(reader.Len()) {
+			return io.ErrUnexpectedEOF
+		}
+		name := make([]byte, nameLen)
+		if _, err := io.ReadFull(reader, name); err != nil {
+			return err
+		}
+		mb.comparator = string(name)
+	}
+	if reader.Len() >= 8 {
+		if err := binary.Read(reader, binary.BigEndian, &mb.entries); err != nil {
 			return err
 		}
 	}
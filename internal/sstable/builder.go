@@ -1,6 +1,7 @@
 package sstable
 
 import (
+	"bytes"
 	"errors"
 	"time"
 
@@ -18,6 +19,8 @@ SSTable on-disk format:
 	+-------------------+
 	| Data Block N      |
 	+-------------------+
+	| Range Del Block   |  (only if the table has range tombstones)
+	+-------------------+
 	| Meta Block        |
 	+-------------------+
 	| Index Block       |
@@ -27,7 +30,17 @@ SSTable on-disk format:
 */
 
 // Build constructs SSTable bytes from the given entries, block size, and level.
+// Entries must be sorted by key ascending in Bytewise order, then sequence
+// number descending. All versions of a key are kept in the same data block so
+// IndexBlock.Search finds them together. Range tombstones may appear anywhere
+// in entries; they are stored in a block of their own, in the order given.
 func Build(entries []*entry.Entry, blockSize int, level int) ([]byte, error) {
+	return BuildWith(entries, blockSize, level, Bytewise)
+}
+
+// BuildWith is Build for entries whose keys are ordered by cmp. The name of cmp
+// is recorded in the table.
+func BuildWith(entries []*entry.Entry, blockSize int, level int, cmp Comparator) ([]byte, error) {
 	sstableBuffer := bytesBufPool.Get()
 	defer bytesBufPool.Put(sstableBuffer)
 
@@ -35,10 +48,21 @@ func Build(entries []*entry.Entry, blockSize int, level int) ([]byte, error) {
 	var dataBlocks []*DataBlock
 	currentBlock := &DataBlock{}
 	currentSize := 0
+	rangeDelBlock := &DataBlock{}
 
+	var maxSeq uint64
+	var keys int
 	for _, e := range entries {
+		if e.RangeDelete {
+			maxSeq = max(maxSeq, e.Seq)
+			rangeDelBlock.entries = append(rangeDelBlock.entries, e)
+			continue
+		}
+		keys++
 		entrySize := e.Size()
-		if currentSize+entrySize > blockSize && currentSize > 0 {
+		maxSeq = max(maxSeq, e.Seq)
+		sameKey := currentSize > 0 && bytes.Equal(currentBlock.entries[len(currentBlock.entries)-1].Key, e.Key)
+		if currentSize+entrySize > blockSize && currentSize > 0 && !sameKey {
 			dataBlocks = append(dataBlocks, currentBlock)
 			currentBlock = &DataBlock{}
 			currentSize = 0
@@ -73,20 +97,39 @@ func Build(entries []*entry.Entry, blockSize int, level int) ([]byte, error) {
 		}
 	}
 
+	var rangeDels Block
+	if len(rangeDelBlock.entries) > 0 {
+		rangeDelBytes, err := rangeDelBlock.Encode()
+		if err != nil {
+			return nil, err
+		}
+		rangeDels = Block{offset: offset, length: uint64(len(rangeDelBytes))}
+		offset += uint64(len(rangeDelBytes))
+		if _, err := sstableBuffer.Write(rangeDelBytes); err != nil {
+			return nil, err
+		}
+	}
+
 	indexBlockBytes, err := indexBlock.Encode()
 	if err != nil {
 		return nil, err
 	}
 
-	// Build bloom filter over all keys.
-	bf := bloom.NewBloomFilter(len(entries), 0.01)
+	// Build bloom filter over all point keys.
+	bf := bloom.NewBloomFilter(max(keys, 1), 0.01)
 	for _, e := range entries {
-		bf.Add(e.Key)
+		if !e.RangeDelete {
+			bf.Add(e.Key)
+		}
 	}
 	metaBlock := &MetaBlock{
-		createdAt: time.Now().Unix(),
-		level:     level,
-		bloom:     bf.Encode(),
+		createdAt:  time.Now().Unix(),
+		level:      level,
+		bloom:      bf.Encode(),
+		maxSeq:     maxSeq,
+		rangeDels:  rangeDels,
+		comparator: cmp.Name(),
+		entries:    uint64(keys),
 	}
 
 	metaBlockBytes, err := metaBlock.Encode()
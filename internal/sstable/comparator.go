@@ -0,0 +1,34 @@
+package sstable
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrComparatorMismatch is returned by OpenReaderWith for a table written with a
+// comparator of another name.
+var ErrComparatorMismatch = errors.New("sstable: written with a different comparator")
+
+// Comparator orders the keys of a table. Name identifies the order; it is stored
+// in the meta block, so a table is never read in an order it was not written in.
+type Comparator interface {
+	Compare(a, b []byte) int
+	Name() string
+}
+
+// Bytewise orders keys lexicographically by byte. Tables written before
+// comparators were recorded are in this order.
+var Bytewise Comparator = bytewise{}
+
+type bytewise struct{}
+
+func (bytewise) Compare(a, b []byte) int { return bytes.Compare(a, b) }
+func (bytewise) Name() string            { return "lmstree.Bytewise" }
+
+// orBytewise returns cmp, or Bytewise if cmp is nil.
+func orBytewise(cmp Comparator) Comparator {
+	if cmp == nil {
+		return Bytewise
+	}
+	return cmp
+}
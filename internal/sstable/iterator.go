@@ -0,0 +1,110 @@
+package sstable
+
+import (
+	"sort"
+
+	"github.com/maksymus/lmstree/entry"
+)
+
+// Iterator is a bidirectional cursor over the entries of one SSTable, in key
+// order. Data blocks are read and decoded lazily as the cursor crosses into them.
+type Iterator struct {
+	r        *Reader
+	blockIdx int        // index into r.index.entries of the loaded block
+	block    *DataBlock // decoded block at blockIdx, nil when exhausted
+	pos      int        // position within block.entries
+	err      error
+}
+
+// NewIterator returns an unpositioned iterator. Call SeekToFirst or Seek before use.
+func (r *Reader) NewIterator() *Iterator {
+	return &Iterator{r: r}
+}
+
+// Valid reports whether the iterator is positioned at an entry.
+func (it *Iterator) Valid() bool {
+	return it.err == nil && it.block != nil && it.pos >= 0 && it.pos < len(it.block.entries)
+}
+
+// Entry returns the entry at the current position, including tombstones.
+func (it *Iterator) Entry() *entry.Entry { return it.block.entries[it.pos] }
+
+// Err returns the first I/O or decode error encountered, if any.
+func (it *Iterator) Err() error { return it.err }
+
+// SeekToFirst positions the iterator at the smallest key in the table.
+func (it *Iterator) SeekToFirst() {
+	it.loadBlock(0)
+	it.skipEmptyBlocks()
+}
+
+// Seek positions the iterator at the first key >= key.
+func (it *Iterator) Seek(key []byte) {
+	entries := it.r.index.entries
+	idx := sort.Search(len(entries), func(i int) bool {
+		return it.r.cmp.Compare(entries[i].endKey, key) >= 0
+	})
+	if !it.loadBlock(idx) {
+		return
+	}
+	it.pos = sort.Search(len(it.block.entries), func(i int) bool {
+		return it.r.cmp.Compare(it.block.entries[i].Key, key) >= 0
+	})
+	it.skipEmptyBlocks()
+}
+
+// SeekToLast positions the iterator at the largest key in the table.
+func (it *Iterator) SeekToLast() {
+	if it.loadBlock(len(it.r.index.entries) - 1) {
+		it.pos = len(it.block.entries) - 1
+	}
+	it.skipEmptyBlocksBackward()
+}
+
+// Next advances to the following entry.
+func (it *Iterator) Next() {
+	it.pos++
+	it.skipEmptyBlocks()
+}
+
+// Prev moves back to the preceding entry, crossing into the previous data block if needed.
+func (it *Iterator) Prev() {
+	it.pos--
+	it.skipEmptyBlocksBackward()
+}
+
+// loadBlock decodes the data block at idx and positions at its first entry.
+// Returns false if idx is out of range or the block cannot be read.
+func (it *Iterator) loadBlock(idx int) bool {
+	it.blockIdx, it.block, it.pos = idx, nil, 0
+	if it.err != nil || idx < 0 || idx >= len(it.r.index.entries) {
+		return false
+	}
+	block, err := it.r.readBlock(it.r.index.entries[idx].block)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.block = block
+	return true
+}
+
+// skipEmptyBlocks moves forward to the next block while the current one is exhausted.
+func (it *Iterator) skipEmptyBlocks() {
+	for it.block != nil && it.pos >= len(it.block.entries) {
+		if !it.loadBlock(it.blockIdx + 1) {
+			return
+		}
+	}
+}
+
+// skipEmptyBlocksBackward moves to the last entry of the previous block while
+// the position has run off the front of the current one.
+func (it *Iterator) skipEmptyBlocksBackward() {
+	for it.block != nil && it.pos < 0 {
+		if !it.loadBlock(it.blockIdx - 1) {
+			return
+		}
+		it.pos = len(it.block.entries) - 1
+	}
+}
@@ -2,27 +2,89 @@ package sstable
 
 import (
 	"bytes"
+	"sort"
 
 	"github.com/maksymus/lmstree/entry"
 	"github.com/maksymus/lmstree/internal/heap"
 )
 
+// MergeFunc combines merge operands, oldest first, with the value they apply to.
+// existing is nil when the key has no older value.
+type MergeFunc func(key, existing []byte, operands [][]byte) []byte
+
+// MergeOptions controls which versions MergeWith keeps.
+type MergeOptions struct {
+	// Snapshots holds the sequence numbers of live snapshots, ascending. Besides
+	// the newest version of each key, the newest version visible to every
+	// snapshot is kept.
+	Snapshots []uint64
+	// KeepTombstones retains deletion markers so they go on shadowing older data
+	// that is not part of this merge (e.g. deeper levels).
+	KeepTombstones bool
+	// MergeFunc, if set, folds runs of merge operands into a plain value once the
+	// value they apply to is known. Without it operands are kept as they are.
+	MergeFunc MergeFunc
+	// Now is the current Unix time in nanoseconds. Entries that expired by Now are
+	// treated as tombstones. Zero disables expiry.
+	Now int64
+	// RangeTombstones holds the range tombstones of the merged tables. Versions
+	// they delete are dropped, and the tombstones themselves are appended to the
+	// result unless they are dropped like point tombstones.
+	RangeTombstones []*entry.Entry
+	// Resolve returns the value of an entry whose Value is a value-log pointer,
+	// for MergeFunc to fold operands into. It is required if such entries may
+	// lie under operands. If it fails, the operands and the entry are kept
+	// unfolded.
+	Resolve func(e *entry.Entry) ([]byte, error)
+	// Comparator orders the keys of the merged entries. Nil means Bytewise.
+	Comparator Comparator
+}
+
 // Merge performs a k-way merge of sorted entry slices.
 // For duplicate keys, last-write-wins (highest listIndex wins).
 // Tombstones are dropped from the output.
 func Merge(entries ...[]*entry.Entry) ([]*entry.Entry, error) {
+	return MergeWith(MergeOptions{}, entries...)
+}
+
+// MergeWith performs a k-way merge of entry slices, each sorted by key ascending
+// in opts.Comparator order, then sequence number descending. Versions of a key are ordered newest first;
+// equal sequence numbers resolve to the highest listIndex.
+//
+// Snapshots split each key's history into stripes: a version is kept only if it is
+// the newest in its stripe. A tombstone with no snapshot below it hides every
+// older version from every reader, so unless opts.KeepTombstones is set it is
+// dropped together with everything it shadows.
+//
+// A merge operand needs the versions below it. A run of operands is folded, with
+// opts.MergeFunc, into the first non-operand version of the same stripe, or into
+// nothing if the key has no older versions and tombstones need not be kept (so
+// nothing deeper can hold one). A run that crosses into an older stripe is kept
+// as is, since older snapshots need the versions underneath.
+//
+// An expired entry is a tombstone to every reader, snapshot or not: it is dropped
+// like one, or rewritten as one where a tombstone must be kept.
+//
+// A version covered by a newer range tombstone of the same stripe is deleted for
+// every reader that can see it, and is dropped. Range tombstones that must be
+// kept follow the point entries in the result.
+func MergeWith(opts MergeOptions, entries ...[]*entry.Entry) ([]*entry.Entry, error) {
 	type heapItem struct {
 		entry      *entry.Entry
 		listIndex  int
 		entryIndex int
 	}
 
+	cmp := orBytewise(opts.Comparator)
 	h := heap.NewHeap[heapItem](func(a, b heapItem) bool {
-		compare := bytes.Compare(a.entry.Key, b.entry.Key)
-		if compare == 0 {
-			return a.listIndex > b.listIndex
+		compare := cmp.Compare(a.entry.Key, b.entry.Key)
+		if compare != 0 {
+			return compare < 0
+		}
+		if a.entry.Seq != b.entry.Seq {
+			return a.entry.Seq > b.entry.Seq
 		}
-		return compare < 0
+		return a.listIndex > b.listIndex
 	})
 
 	for i, list := range entries {
@@ -31,8 +93,68 @@ func Merge(entries ...[]*entry.Entry) ([]*entry.Entry, error) {
 		}
 	}
 
+	// stripe returns the index of the oldest snapshot that can see seq, or
+	// len(opts.Snapshots) if only the latest view can.
+	stripe := func(seq uint64) int {
+		return sort.Search(len(opts.Snapshots), func(i int) bool { return opts.Snapshots[i] >= seq })
+	}
+
 	result := make([]*entry.Entry, 0)
 	var lastKey []byte
+	lastStripe := -1
+	shadowed := false          // a dropped tombstone hides the rest of this key
+	var pending []*entry.Entry // unresolved operands of lastKey, newest first
+	pendingStripe := -1
+
+	// rangeDeleted reports whether a range tombstone of stripe s deletes e.
+	rangeDeleted := func(e *entry.Entry, s int) bool {
+		for _, t := range opts.RangeTombstones {
+			if t.Seq > e.Seq && t.CoversWith(e.Key, cmp.Compare) && stripe(t.Seq) == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	// fold replaces the pending operands with one plain value applied to base.
+	fold := func(base *entry.Entry) {
+		var existing []byte
+		if base != nil && !base.Tombstone && !base.Expired(opts.Now) {
+			existing = base.Value
+			if base.ValuePointer {
+				var err error
+				if existing, err = opts.Resolve(base); err != nil {
+					result = append(result, pending...)
+					result = append(result, base)
+					pending = nil
+					return
+				}
+			}
+		}
+		operands := make([][]byte, len(pending))
+		for i, op := range pending {
+			operands[len(pending)-1-i] = op.Value
+		}
+		newest := pending[0]
+		result = append(result, &entry.Entry{
+			Key:   newest.Key,
+			Value: opts.MergeFunc(newest.Key, existing, operands),
+			Seq:   newest.Seq,
+		})
+		pending = nil
+	}
+	// endKey settles operands left pending when a key's versions run out.
+	endKey := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if opts.MergeFunc != nil && !opts.KeepTombstones {
+			fold(nil)
+			return
+		}
+		result = append(result, pending...)
+		pending = nil
+	}
 
 	for h.Len() > 0 {
 		item, _ := h.Pop()
@@ -45,15 +167,71 @@ func Merge(entries ...[]*entry.Entry) ([]*entry.Entry, error) {
 			h.Push(heapItem{entry: list[entryIndex], listIndex: listIndex, entryIndex: entryIndex})
 		}
 
-		if bytes.Equal(lastKey, currentEntry.Key) {
+		if !bytes.Equal(lastKey, currentEntry.Key) {
+			endKey()
+			lastKey = currentEntry.Key
+			lastStripe = -1
+			shadowed = false
+		}
+		if shadowed {
+			continue
+		}
+
+		s := stripe(currentEntry.Seq)
+		if rangeDeleted(currentEntry, s) {
+			// Everything older in this stripe is deleted too; pending operands
+			// apply to nothing.
+			if len(pending) > 0 && s == pendingStripe && opts.MergeFunc != nil {
+				fold(nil)
+			}
+			result = append(result, pending...)
+			pending = nil
+			lastStripe = s
 			continue
 		}
-		lastKey = currentEntry.Key
+		if len(pending) > 0 {
+			if s == pendingStripe {
+				if currentEntry.Operand {
+					pending = append(pending, currentEntry)
+					continue
+				}
+				if opts.MergeFunc != nil {
+					fold(currentEntry)
+					lastStripe = s
+					continue
+				}
+			}
+			// Unresolved operands are kept along with whatever lies under them.
+			result = append(result, pending...)
+			pending = nil
+		}
+		if s == lastStripe {
+			continue
+		}
+		if currentEntry.Operand {
+			pending = append(pending, currentEntry)
+			pendingStripe = s
+			continue
+		}
+		lastStripe = s
 
-		if !currentEntry.Tombstone {
-			result = append(result, currentEntry)
+		if currentEntry.Tombstone || currentEntry.Expired(opts.Now) {
+			if !opts.KeepTombstones && s == 0 {
+				shadowed = true
+				continue
+			}
+			if !currentEntry.Tombstone {
+				currentEntry = &entry.Entry{Key: currentEntry.Key, Value: []byte{}, Tombstone: true, Seq: currentEntry.Seq}
+			}
 		}
+		result = append(result, currentEntry)
 	}
+	endKey()
 
+	for _, t := range opts.RangeTombstones {
+		if opts.KeepTombstones || stripe(t.Seq) != 0 {
+			result = append(result, t)
+		}
+	}
 	return result, nil
 }
@@ -2,6 +2,7 @@ package sstable
 
 import (
 	"fmt"
+	"math"
 	"os"
 
 	"github.com/maksymus/lmstree/entry"
@@ -12,17 +13,28 @@ import (
 const footerSize = 32
 
 // Reader provides read-only access to a single on-disk SSTable.
-// Only the footer, index block, and bloom filter are loaded at open time.
-// Data blocks are fetched on demand via ReadAt.
+// Only the footer, index block, bloom filter and range tombstones are loaded at
+// open time. Data blocks are fetched on demand via ReadAt.
 type Reader struct {
-	f     *os.File
-	size  int64
-	index *IndexBlock
-	bloom *bloom.BloomFilter
+	f         *os.File
+	size      int64
+	index     *IndexBlock
+	bloom     *bloom.BloomFilter
+	maxSeq    uint64
+	rangeDels []*entry.Entry
+	cmp       Comparator
+	entries   uint64 // point entries; 0 if not recorded
 }
 
 // OpenReader opens the SSTable at path and loads the footer, index, and bloom filter.
+// The table must be in Bytewise order.
 func OpenReader(path string) (*Reader, error) {
+	return OpenReaderWith(path, Bytewise)
+}
+
+// OpenReaderWith opens the SSTable at path, whose keys must be ordered by cmp. It
+// fails with ErrComparatorMismatch if the table records another comparator.
+func OpenReaderWith(path string, cmp Comparator) (*Reader, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -36,7 +48,7 @@ func OpenReader(path string) (*Reader, error) {
 		f.Close()
 		return nil, fmt.Errorf("sstable %s too small (%d bytes)", path, info.Size())
 	}
-	r := &Reader{f: f, size: info.Size()}
+	r := &Reader{f: f, size: info.Size(), cmp: cmp}
 
 	footerBuf := make([]byte, footerSize)
 	if _, err := f.ReadAt(footerBuf, info.Size()-int64(footerSize)); err != nil {
@@ -54,25 +66,54 @@ func OpenReader(path string) (*Reader, error) {
 		f.Close()
 		return nil, err
 	}
-	r.index = &IndexBlock{}
+	r.index = &IndexBlock{cmp: cmp}
 	if err := r.index.Decode(indexBuf); err != nil {
 		f.Close()
 		return nil, err
 	}
 
 	metaBuf := make([]byte, footer.meta.length)
-	if _, err := f.ReadAt(metaBuf, int64(footer.meta.offset)); err == nil {
-		meta := &MetaBlock{}
-		if err := meta.Decode(metaBuf); err == nil && len(meta.bloom) > 0 {
-			r.bloom, _ = bloom.Decode(meta.bloom)
+	if _, err := f.ReadAt(metaBuf, int64(footer.meta.offset)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// The comparator check depends on the meta block, so a table whose meta
+	// block does not decode is not opened.
+	meta := &MetaBlock{}
+	if err := meta.Decode(metaBuf); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("sstable %s: meta block: %w", path, err)
+	}
+	if name := cmp.Name(); meta.comparator != name && (meta.comparator != "" || name != Bytewise.Name()) {
+		f.Close()
+		return nil, fmt.Errorf("%w: sstable %s is ordered by %q, not %q", ErrComparatorMismatch, path, meta.comparator, name)
+	}
+	if len(meta.bloom) > 0 {
+		r.bloom, _ = bloom.Decode(meta.bloom)
+	}
+	r.maxSeq = meta.maxSeq
+	r.entries = meta.entries
+	if meta.rangeDels.length > 0 {
+		// Unlike the bloom filter, range tombstones are needed for correct reads.
+		block, err := r.readBlock(meta.rangeDels)
+		if err != nil {
+			f.Close()
+			return nil, err
 		}
+		r.rangeDels = block.entries
 	}
 
 	return r, nil
 }
 
-// Search looks up key in the SSTable. Returns the Entry (may be tombstone) and true if found.
+// Search looks up the newest version of key in the SSTable. Returns the Entry
+// (may be tombstone) and true if found.
 func (r *Reader) Search(key []byte) (*entry.Entry, bool) {
+	return r.SearchAt(key, math.MaxUint64)
+}
+
+// SearchAt looks up the newest version of key whose sequence number is <= seq.
+func (r *Reader) SearchAt(key []byte, seq uint64) (*entry.Entry, bool) {
 	if r.bloom != nil && !r.bloom.Contains(key) {
 		return nil, false
 	}
@@ -82,27 +123,136 @@ func (r *Reader) Search(key []byte) (*entry.Entry, bool) {
 		return nil, false
 	}
 
+	dataBlock, err := r.readBlock(block)
+	if err != nil {
+		return nil, false
+	}
+	return dataBlock.SearchAt(key, seq)
+}
+
+// MultiSearchAt looks up several keys, sorted ascending, like SearchAt. Keys that
+// fall in the same data block share one read and decode of it. The result is
+// aligned with keys; keys not in the table, or whose block cannot be read, map to nil.
+func (r *Reader) MultiSearchAt(keys [][]byte, seq uint64) []*entry.Entry {
+	entries := make([]*entry.Entry, len(keys))
+	var dataBlock *DataBlock
+	var loaded Block
+	for i, key := range keys {
+		if r.bloom != nil && !r.bloom.Contains(key) {
+			continue
+		}
+		block, found := r.index.Search(key)
+		if !found {
+			continue
+		}
+		if dataBlock == nil || block != loaded {
+			var err error
+			if dataBlock, err = r.readBlock(block); err != nil {
+				dataBlock = nil
+				continue
+			}
+			loaded = block
+		}
+		if e, ok := dataBlock.SearchAt(key, seq); ok {
+			entries[i] = e
+		}
+	}
+	return entries
+}
+
+// MaxSeq returns the highest sequence number stored in the table (0 for tables
+// written before sequence numbers existed).
+func (r *Reader) MaxSeq() uint64 { return r.maxSeq }
+
+// RangeTombstones returns the range tombstones stored in the table.
+func (r *Reader) RangeTombstones() []*entry.Entry { return r.rangeDels }
+
+// KeyRange returns the smallest and largest point keys in the table. ok is false
+// if the table holds no point entries.
+func (r *Reader) KeyRange() (smallest, largest []byte, ok bool) {
+	if len(r.index.entries) == 0 {
+		return nil, nil, false
+	}
+	return r.index.entries[0].startKey, r.index.entries[len(r.index.entries)-1].endKey, true
+}
+
+// ApproximateRange estimates the bytes and point entries, counting every
+// version, the table holds in [start, end) from its index alone: each data block
+// the range covers counts in full, and each it cuts across counts half. Entries
+// are the table's count spread over its data bytes; tables written before the
+// count was recorded count one per data block. A nil start or end leaves that
+// side unbounded.
+func (r *Reader) ApproximateRange(start, end []byte) (size, count int64) {
+	var total, blocks int64
+	for _, ie := range r.index.entries {
+		total += int64(ie.block.length)
+		if end != nil && r.cmp.Compare(ie.startKey, end) >= 0 || start != nil && r.cmp.Compare(ie.endKey, start) < 0 {
+			continue
+		}
+		length := int64(ie.block.length)
+		if start != nil && r.cmp.Compare(ie.startKey, start) < 0 || end != nil && r.cmp.Compare(ie.endKey, end) >= 0 {
+			length /= 2
+		}
+		size += length
+		blocks++
+	}
+	if r.entries == 0 {
+		return size, blocks
+	}
+	if total > 0 {
+		count = int64(r.entries) * size / total
+	}
+	return size, count
+}
+
+// Boundary is a point of a table's key space with the bytes of data from it up
+// to the next Boundary.
+type Boundary struct {
+	Key  []byte
+	Size int64
+}
+
+// Boundaries returns, in key order, the first key and length of every data block
+// holding keys in [start, end), from the index alone. A block that starts before
+// start is reported at start, with half its length, like ApproximateRange; so is
+// one that runs past end. A nil start or end leaves that side unbounded.
+func (r *Reader) Boundaries(start, end []byte) []Boundary {
+	var boundaries []Boundary
+	for _, ie := range r.index.entries {
+		if end != nil && r.cmp.Compare(ie.startKey, end) >= 0 || start != nil && r.cmp.Compare(ie.endKey, start) < 0 {
+			continue
+		}
+		b := Boundary{Key: ie.startKey, Size: int64(ie.block.length)}
+		if start != nil && r.cmp.Compare(ie.startKey, start) < 0 {
+			b.Key = start
+			b.Size /= 2
+		} else if end != nil && r.cmp.Compare(ie.endKey, end) >= 0 {
+			b.Size /= 2
+		}
+		boundaries = append(boundaries, b)
+	}
+	return boundaries
+}
+
+// readBlock fetches and decodes the data block at the given handle.
+func (r *Reader) readBlock(block Block) (*DataBlock, error) {
 	buf := make([]byte, block.length)
 	if _, err := r.f.ReadAt(buf, int64(block.offset)); err != nil {
-		return nil, false
+		return nil, err
 	}
-	dataBlock := &DataBlock{}
+	dataBlock := &DataBlock{cmp: r.cmp}
 	if err := dataBlock.Decode(buf); err != nil {
-		return nil, false
+		return nil, err
 	}
-	return dataBlock.Search(key)
+	return dataBlock, nil
 }
 
-// Entries returns all entries in sorted key order, including tombstones.
+// Entries returns every entry (all versions, including tombstones) in table order.
 func (r *Reader) Entries() ([]*entry.Entry, error) {
 	var entries []*entry.Entry
 	for _, ie := range r.index.entries {
-		buf := make([]byte, ie.block.length)
-		if _, err := r.f.ReadAt(buf, int64(ie.block.offset)); err != nil {
-			return nil, err
-		}
-		dataBlock := &DataBlock{}
-		if err := dataBlock.Decode(buf); err != nil {
+		dataBlock, err := r.readBlock(ie.block)
+		if err != nil {
 			return nil, err
 		}
 		entries = append(entries, dataBlock.entries...)
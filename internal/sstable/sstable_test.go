@@ -2,6 +2,13 @@ package sstable
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/maksymus/lmstree/entry"
@@ -92,7 +99,7 @@ func TestDataBlock_EncodeDecode(t *testing.T) {
 }
 
 func TestMetaBlock_EncodeDecode(t *testing.T) {
-	meta := &MetaBlock{createdAt: 1625077800, level: 1}
+	meta := &MetaBlock{createdAt: 1625077800, level: 1, comparator: "test.Reverse"}
 
 	data, err := meta.Encode()
 	if err != nil {
@@ -110,6 +117,23 @@ func TestMetaBlock_EncodeDecode(t *testing.T) {
 	if decoded.level != meta.level {
 		t.Errorf("level mismatch: expected %d, got %d", meta.level, decoded.level)
 	}
+	if decoded.comparator != meta.comparator {
+		t.Errorf("comparator mismatch: expected %q, got %q", meta.comparator, decoded.comparator)
+	}
+
+	// A comparator name length past the end of the block; the name and the
+	// entry count (8) follow it.
+	binary.BigEndian.PutUint32(data[len(data)-8-len(meta.comparator)-4:], math.MaxUint32)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err = (&MetaBlock{}).Decode(data)
+	runtime.ReadMemStats(&after)
+	if err == nil {
+		t.Error("Decode of an overlong comparator name succeeded, want an error")
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("Decode of an overlong comparator name allocated %d bytes", allocated)
+	}
 }
 
 func TestDataBlock_Search(t *testing.T) {
@@ -266,3 +290,485 @@ func Test_MergeDuplicateKeys(t *testing.T) {
 		}
 	}
 }
+
+// openTestReader builds an SSTable from entries, writes it to a temp file and opens it.
+func openTestReader(t *testing.T, entries []*entry.Entry, blockSize int) *Reader {
+	t.Helper()
+	data, err := Build(entries, blockSize, 0)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "test.sst")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatalf("OpenReader failed: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestIterator_ScanAndSeek(t *testing.T) {
+	var entries []*entry.Entry
+	for i := 0; i < 50; i++ {
+		entries = append(entries, &entry.Entry{Key: []byte(fmt.Sprintf("key%02d", i*2)), Value: []byte("v")})
+	}
+	r := openTestReader(t, entries, 64)
+
+	it := r.NewIterator()
+	count := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if !bytes.Equal(it.Entry().Key, entries[count].Key) {
+			t.Fatalf("entry %d: got %s, want %s", count, it.Entry().Key, entries[count].Key)
+		}
+		count++
+	}
+	if count != len(entries) || it.Err() != nil {
+		t.Fatalf("iterated %d entries (err %v), want %d", count, it.Err(), len(entries))
+	}
+
+	tests := []struct {
+		seek  string
+		want  string
+		valid bool
+	}{
+		{"key00", "key00", true},
+		{"key01", "key02", true},
+		{"key45", "key46", true},
+		{"a", "key00", true},
+		{"key98", "key98", true},
+		{"key99", "", false},
+	}
+	for _, tt := range tests {
+		it.Seek([]byte(tt.seek))
+		if it.Valid() != tt.valid {
+			t.Fatalf("Seek(%s) valid = %v, want %v", tt.seek, it.Valid(), tt.valid)
+		}
+		if tt.valid && string(it.Entry().Key) != tt.want {
+			t.Errorf("Seek(%s) = %s, want %s", tt.seek, it.Entry().Key, tt.want)
+		}
+	}
+}
+
+func TestIterator_Reverse(t *testing.T) {
+	var entries []*entry.Entry
+	for i := 0; i < 50; i++ {
+		entries = append(entries, &entry.Entry{Key: []byte(fmt.Sprintf("key%02d", i)), Value: []byte("v")})
+	}
+	r := openTestReader(t, entries, 64)
+
+	it := r.NewIterator()
+	i := len(entries) - 1
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		if !bytes.Equal(it.Entry().Key, entries[i].Key) {
+			t.Fatalf("got %s, want %s", it.Entry().Key, entries[i].Key)
+		}
+		i--
+	}
+	if i != -1 || it.Err() != nil {
+		t.Fatalf("reverse iteration stopped at %d (err %v)", i, it.Err())
+	}
+
+	// Crossing a block boundary in both directions.
+	it.Seek([]byte("key20"))
+	it.Prev()
+	it.Next()
+	it.Next()
+	if !it.Valid() || string(it.Entry().Key) != "key21" {
+		t.Fatalf("Prev/Next round trip landed on %s", it.Entry().Key)
+	}
+}
+
+func Test_MergeWithSnapshots(t *testing.T) {
+	older := []*entry.Entry{
+		{Key: []byte("a"), Value: []byte("a1"), Seq: 1},
+		{Key: []byte("b"), Value: []byte("b2"), Seq: 2},
+		{Key: []byte("c"), Value: []byte("c3"), Seq: 3},
+	}
+	newer := []*entry.Entry{
+		{Key: []byte("a"), Value: []byte("a6"), Seq: 6},
+		{Key: []byte("a"), Value: []byte("a5"), Seq: 5},
+		{Key: []byte("b"), Tombstone: true, Seq: 7},
+		{Key: []byte("c"), Tombstone: true, Seq: 8},
+	}
+
+	tests := []struct {
+		name string
+		opts MergeOptions
+		want []string
+	}{
+		{"no snapshots", MergeOptions{}, []string{"a@6"}},
+		{"keep tombstones", MergeOptions{KeepTombstones: true}, []string{"a@6", "b@7", "c@8"}},
+		// Snapshot 2 sees a1 and b2; snapshot 5 sees a5, b2 and c3.
+		{"snapshots", MergeOptions{Snapshots: []uint64{2, 5}}, []string{"a@6", "a@5", "a@1", "b@7", "b@2", "c@8", "c@3"}},
+		// Snapshot 2 predates c entirely; c's tombstone is kept because a snapshot
+		// older than it exists, but c3 is shadowed for every reader.
+		{"snapshot below tombstone only", MergeOptions{Snapshots: []uint64{2}}, []string{"a@6", "a@1", "b@7", "b@2", "c@8"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := MergeWith(tt.opts, older, newer)
+			if err != nil {
+				t.Fatalf("MergeWith failed: %v", err)
+			}
+			var got []string
+			for _, e := range merged {
+				got = append(got, fmt.Sprintf("%s@%d", e.Key, e.Seq))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("MergeWith = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_MergeWithOperands(t *testing.T) {
+	concat := func(key, existing []byte, operands [][]byte) []byte {
+		out := bytes.Clone(existing)
+		for _, op := range operands {
+			out = append(out, op...)
+		}
+		return out
+	}
+	older := []*entry.Entry{
+		{Key: []byte("a"), Value: []byte("x"), Seq: 1},
+		{Key: []byte("b"), Value: []byte("b2"), Seq: 2},
+		{Key: []byte("c"), Tombstone: true, Seq: 3},
+	}
+	newer := []*entry.Entry{
+		{Key: []byte("a"), Value: []byte("z"), Operand: true, Seq: 6},
+		{Key: []byte("a"), Value: []byte("y"), Operand: true, Seq: 4},
+		{Key: []byte("c"), Value: []byte("c"), Operand: true, Seq: 7},
+		{Key: []byte("d"), Value: []byte("d"), Operand: true, Seq: 8},
+	}
+
+	tests := []struct {
+		name string
+		opts MergeOptions
+		want []string
+	}{
+		// Operands fold into the value below them, or into nothing once no deeper data can exist.
+		{"fold", MergeOptions{MergeFunc: concat}, []string{"a@6=xyz", "b@2=b2", "c@7=c", "d@8=d"}},
+		// d might have a value in a deeper level, so its operand stays unresolved.
+		{"keep tombstones", MergeOptions{MergeFunc: concat, KeepTombstones: true}, []string{"a@6=xyz", "b@2=b2", "c@7=c", "d@8=d+"}},
+		// Every version of a sits in its own stripe, so none can be folded: snapshot 1
+		// must still see x alone.
+		{"snapshot", MergeOptions{MergeFunc: concat, Snapshots: []uint64{1, 4}}, []string{"a@6=z+", "a@4=y+", "a@1=x", "b@2=b2", "c@7=c+", "c@3=", "d@8=d"}},
+		{"no merge func", MergeOptions{}, []string{"a@6=z+", "a@4=y+", "a@1=x", "b@2=b2", "c@7=c+", "d@8=d+"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := MergeWith(tt.opts, older, newer)
+			if err != nil {
+				t.Fatalf("MergeWith failed: %v", err)
+			}
+			var got []string
+			for _, e := range merged {
+				s := fmt.Sprintf("%s@%d=%s", e.Key, e.Seq, e.Value)
+				if e.Operand {
+					s += "+"
+				}
+				got = append(got, s)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("MergeWith = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_MergeWithExpiry(t *testing.T) {
+	older := []*entry.Entry{
+		{Key: []byte("a"), Value: []byte("a1"), Seq: 1},
+		{Key: []byte("b"), Value: []byte("b2"), Seq: 2},
+	}
+	newer := []*entry.Entry{
+		{Key: []byte("a"), Value: []byte("a3"), Seq: 3, ExpiresAt: 100},
+		{Key: []byte("b"), Value: []byte("b4"), Seq: 4, ExpiresAt: 200},
+	}
+
+	tests := []struct {
+		name string
+		opts MergeOptions
+		want []string
+	}{
+		{"expiry disabled", MergeOptions{}, []string{"a@3=a3", "b@4=b4"}},
+		// At 150 a3 has expired and takes a1 with it; b4 is still live.
+		{"expired", MergeOptions{Now: 150}, []string{"b@4=b4"}},
+		{"expired, keep tombstones", MergeOptions{Now: 150, KeepTombstones: true}, []string{"a@3=-", "b@4=b4"}},
+		// Snapshot 2 still reads a1 underneath the expired a3.
+		{"expired above snapshot", MergeOptions{Now: 150, Snapshots: []uint64{2}}, []string{"a@3=-", "a@1=a1", "b@4=b4", "b@2=b2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := MergeWith(tt.opts, older, newer)
+			if err != nil {
+				t.Fatalf("MergeWith failed: %v", err)
+			}
+			var got []string
+			for _, e := range merged {
+				value := string(e.Value)
+				if e.Tombstone {
+					value = "-"
+				}
+				got = append(got, fmt.Sprintf("%s@%d=%s", e.Key, e.Seq, value))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("MergeWith = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReader_SearchAt(t *testing.T) {
+	var entries []*entry.Entry
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%02d", i))
+		for seq := uint64(30); seq >= 10; seq -= 10 {
+			entries = append(entries, &entry.Entry{Key: key, Value: []byte(fmt.Sprintf("v%d", seq)), Seq: seq})
+		}
+	}
+	r := openTestReader(t, entries, 64)
+
+	if r.MaxSeq() != 30 {
+		t.Errorf("MaxSeq = %d, want 30", r.MaxSeq())
+	}
+	tests := []struct {
+		seq   uint64
+		want  string
+		found bool
+	}{
+		{35, "v30", true},
+		{30, "v30", true},
+		{29, "v20", true},
+		{10, "v10", true},
+		{9, "", false},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			key := []byte(fmt.Sprintf("key%02d", i))
+			e, found := r.SearchAt(key, tt.seq)
+			if found != tt.found || found && string(e.Value) != tt.want {
+				t.Fatalf("SearchAt(%s, %d) = (%v, %v), want (%s, %v)", key, tt.seq, e, found, tt.want, tt.found)
+			}
+		}
+	}
+}
+
+func Test_MergeWithRangeTombstones(t *testing.T) {
+	entries := []*entry.Entry{
+		{Key: []byte("a"), Value: []byte("a1"), Seq: 1},
+		{Key: []byte("b"), Value: []byte("b5"), Seq: 5},
+		{Key: []byte("b"), Value: []byte("b2"), Seq: 2},
+		{Key: []byte("c"), Value: []byte("c3"), Seq: 3},
+		{Key: []byte("d"), Value: []byte("d1"), Seq: 1},
+	}
+	// Deletes b2 and c3 but not b5, written after it, nor d, outside the range.
+	rangeDels := []*entry.Entry{{Key: []byte("a1"), Value: []byte("d"), RangeDelete: true, Seq: 4}}
+
+	tests := []struct {
+		name string
+		opts MergeOptions
+		want []string
+	}{
+		{"dropped", MergeOptions{}, []string{"a@1=a1", "b@5=b5", "d@1=d1"}},
+		{"kept", MergeOptions{KeepTombstones: true}, []string{"a@1=a1", "b@5=b5", "d@1=d1", "a1@4=[d)"}},
+		// Snapshot 3 still reads b2 and c3 underneath the tombstone.
+		{"above snapshot", MergeOptions{Snapshots: []uint64{3}}, []string{"a@1=a1", "b@5=b5", "b@2=b2", "c@3=c3", "d@1=d1", "a1@4=[d)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.RangeTombstones = rangeDels
+			merged, err := MergeWith(tt.opts, entries)
+			if err != nil {
+				t.Fatalf("MergeWith failed: %v", err)
+			}
+			var got []string
+			for _, e := range merged {
+				value := string(e.Value)
+				if e.RangeDelete {
+					value = "[" + value + ")"
+				}
+				got = append(got, fmt.Sprintf("%s@%d=%s", e.Key, e.Seq, value))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("MergeWith = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReader_RangeTombstones(t *testing.T) {
+	entries := []*entry.Entry{
+		{Key: []byte("b"), Value: []byte("b1"), Seq: 1},
+		{Key: []byte("k"), Value: []byte("z"), RangeDelete: true, Seq: 7},
+		{Key: []byte("m"), Value: []byte("m2"), Seq: 2},
+	}
+	r := openTestReader(t, entries, 64)
+
+	if r.MaxSeq() != 7 {
+		t.Errorf("MaxSeq = %d, want 7", r.MaxSeq())
+	}
+	rangeDels := r.RangeTombstones()
+	if len(rangeDels) != 1 || string(rangeDels[0].Key) != "k" || string(rangeDels[0].Value) != "z" || rangeDels[0].Seq != 7 {
+		t.Fatalf("RangeTombstones = %v, want [k, z) @7", rangeDels)
+	}
+	points, err := r.Entries()
+	if err != nil {
+		t.Fatalf("Entries failed: %v", err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("Entries returned %d entries, want the 2 point entries", len(points))
+	}
+	if smallest, largest, ok := r.KeyRange(); !ok || string(smallest) != "b" || string(largest) != "m" {
+		t.Errorf("KeyRange = (%s, %s, %v), want (b, m, true)", smallest, largest, ok)
+	}
+}
+
+func TestReader_MultiSearchAt(t *testing.T) {
+	var entries []*entry.Entry
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("key%02d", i))
+		entries = append(entries,
+			&entry.Entry{Key: key, Value: []byte("v20"), Seq: 20},
+			&entry.Entry{Key: key, Value: []byte("v10"), Seq: 10})
+	}
+	r := openTestReader(t, entries, 64)
+
+	keys := [][]byte{[]byte("a"), []byte("key00"), []byte("key01"), []byte("key25"), []byte("key25x"), []byte("key49"), []byte("zzz")}
+	for _, seq := range []uint64{5, 15, 25} {
+		got := r.MultiSearchAt(keys, seq)
+		for i, key := range keys {
+			want, ok := r.SearchAt(key, seq)
+			if (got[i] != nil) != ok || ok && string(got[i].Value) != string(want.Value) {
+				t.Errorf("MultiSearchAt(%s, %d) = %v, SearchAt = (%v, %v)", key, seq, got[i], want, ok)
+			}
+		}
+	}
+}
+
+// reverse orders keys by byte, largest first.
+type reverse struct{}
+
+func (reverse) Compare(a, b []byte) int { return bytes.Compare(b, a) }
+func (reverse) Name() string            { return "test.Reverse" }
+
+func TestReader_Comparator(t *testing.T) {
+	var entries []*entry.Entry
+	for i := 49; i >= 0; i-- {
+		entries = append(entries, &entry.Entry{Key: []byte(fmt.Sprintf("key%02d", i)), Value: []byte("v")})
+	}
+	data, err := BuildWith(entries, 64, 0, reverse{})
+	if err != nil {
+		t.Fatalf("BuildWith failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "test.sst")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	if _, err := OpenReader(path); !errors.Is(err, ErrComparatorMismatch) {
+		t.Fatalf("OpenReader error = %v, want ErrComparatorMismatch", err)
+	}
+	r, err := OpenReaderWith(path, reverse{})
+	if err != nil {
+		t.Fatalf("OpenReaderWith failed: %v", err)
+	}
+	defer r.Close()
+
+	for _, key := range []string{"key49", "key25", "key00"} {
+		if _, ok := r.Search([]byte(key)); !ok {
+			t.Errorf("Search(%s) not found", key)
+		}
+	}
+	it := r.NewIterator()
+	it.Seek([]byte("key10"))
+	var got []string
+	for ; it.Valid() && len(got) < 3; it.Next() {
+		got = append(got, string(it.Entry().Key))
+	}
+	if fmt.Sprint(got) != "[key10 key09 key08]" {
+		t.Errorf("Seek(key10) then Next = %v, want [key10 key09 key08]", got)
+	}
+}
+
+func TestReader_CorruptMeta(t *testing.T) {
+	var entries []*entry.Entry
+	for i := 0; i < 20; i++ {
+		entries = append(entries, &entry.Entry{Key: []byte(fmt.Sprintf("key%02d", i)), Value: []byte("v")})
+	}
+	data, err := Build(entries, 64, 0)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	footer := &Footer{}
+	if err := footer.Decode(data[len(data)-footerSize:]); err != nil {
+		t.Fatalf("Footer.Decode failed: %v", err)
+	}
+	// A bloom filter length past the end of the block; createdAt(8) and
+	// level(4) come first.
+	binary.BigEndian.PutUint32(data[footer.meta.offset+12:], math.MaxUint32)
+	path := filepath.Join(t.TempDir(), "test.sst")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	if r, err := OpenReaderWith(path, reverse{}); err == nil || errors.Is(err, ErrComparatorMismatch) {
+		if r != nil {
+			r.Close()
+		}
+		t.Fatalf("OpenReaderWith on a corrupt meta block error = %v, want a decode error", err)
+	}
+}
+
+func TestReader_ApproximateRange(t *testing.T) {
+	var entries []*entry.Entry
+	for i := 0; i < 1000; i++ {
+		entries = append(entries, &entry.Entry{Key: []byte(fmt.Sprintf("key%03d", i)), Value: []byte("value")})
+	}
+	r := openTestReader(t, entries, 256)
+
+	totalSize, totalCount := r.ApproximateRange(nil, nil)
+	if totalCount != 1000 || totalSize <= 0 {
+		t.Fatalf("ApproximateRange(nil, nil) = %d bytes, %d entries; want all 1000", totalSize, totalCount)
+	}
+	size, count := r.ApproximateRange([]byte("key250"), []byte("key750"))
+	if count < 450 || count > 550 || size < totalSize*9/20 || size > totalSize*11/20 {
+		t.Errorf("ApproximateRange(key250, key750) = %d bytes, %d entries; want about half", size, count)
+	}
+	if size, count := r.ApproximateRange([]byte("x"), nil); size != 0 || count != 0 {
+		t.Errorf("ApproximateRange past the last key = %d, %d", size, count)
+	}
+}
+
+func TestReader_Boundaries(t *testing.T) {
+	var entries []*entry.Entry
+	for i := 0; i < 100; i++ {
+		entries = append(entries, &entry.Entry{Key: []byte(fmt.Sprintf("key%02d", i)), Value: []byte("value")})
+	}
+	r := openTestReader(t, entries, 128)
+
+	all := r.Boundaries(nil, nil)
+	var total int64
+	for i, b := range all {
+		if string(b.Key) != string(r.index.entries[i].startKey) || b.Size != int64(r.index.entries[i].block.length) {
+			t.Errorf("boundary %d = %s/%d, want block %d", i, b.Key, b.Size, i)
+		}
+		total += b.Size
+	}
+	if size, _ := r.ApproximateRange(nil, nil); total != size {
+		t.Errorf("boundaries total %d bytes, ApproximateRange %d", total, size)
+	}
+
+	some := r.Boundaries([]byte("key41"), []byte("key60"))
+	if len(some) == 0 || string(some[0].Key) != "key41" {
+		t.Fatalf("Boundaries(key41, key60) = %v, want the first at key41", some)
+	}
+	for _, b := range some[1:] {
+		if string(b.Key) <= "key41" || string(b.Key) >= "key60" {
+			t.Errorf("boundary %s outside [key41, key60)", b.Key)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package vlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// ErrCorruptPointer is returned for a pointer that does not decode or points
+// outside the value log.
+var ErrCorruptPointer = errors.New("vlog: corrupt value pointer")
+
+// ErrRemoved is returned by Read for a pointer into a file already removed.
+var ErrRemoved = errors.New("vlog: value file removed")
+
+// PointerSize is the encoded size of a Pointer.
+const PointerSize = 16
+
+// recordHeaderSize is the fixed part of a record: family(4) | keyLen(4) | valLen(4).
+const recordHeaderSize = 12
+
+// Pointer locates a value in the value log.
+type Pointer struct {
+	File   uint32 // id of the value-log file
+	Offset uint64 // offset of the value within the file
+	Length uint32 // length of the value in bytes
+}
+
+// Encode returns the pointer as file(4) | offset(8) | length(4).
+func (p Pointer) Encode() []byte {
+	buf := make([]byte, PointerSize)
+	binary.BigEndian.PutUint32(buf, p.File)
+	binary.BigEndian.PutUint64(buf[4:], p.Offset)
+	binary.BigEndian.PutUint32(buf[12:], p.Length)
+	return buf
+}
+
+// DecodePointer parses a pointer produced by Encode.
+func DecodePointer(data []byte) (Pointer, error) {
+	if len(data) != PointerSize {
+		return Pointer{}, ErrCorruptPointer
+	}
+	return Pointer{
+		File:   binary.BigEndian.Uint32(data),
+		Offset: binary.BigEndian.Uint64(data[4:]),
+		Length: binary.BigEndian.Uint32(data[12:]),
+	}, nil
+}
+
+// Record is one value found by Scan.
+type Record struct {
+	Family uint32
+	Key    []byte
+	Ptr    Pointer
+}
+
+// file is one value-log file. It is reference counted like an SSTable: Remove
+// only marks it obsolete while readers hold it, and the last Unpin deletes it.
+type file struct {
+	path     string
+	f        *os.File
+	size     int64
+	synced   int64 // bytes known to be on stable storage
+	pins     int
+	obsolete bool
+}
+
+// Log is an append-only value log split into numbered files
+// "vlog-{id}.log" of about maxFileSize bytes each. Every record is
+//
+//	family(4) | keyLen(4) | valLen(4) | key | value
+//
+// so a garbage collector can tell which key a value belongs to. Records are only
+// appended to the newest (active) file; older files are sealed.
+type Log struct {
+	mu          sync.Mutex
+	dir         string
+	maxFileSize int64
+	files       map[uint32]*file
+	active      uint32 // id of the file appended to; 0 until the first Append
+	nextID      uint32
+}
+
+var filePattern = regexp.MustCompile(`^vlog-(\d+)\.log$`)
+
+// Open opens the value-log files in dir. Appends go to a new file, so a record
+// torn by a crash is never followed by new ones.
+func Open(dir string, maxFileSize int64) (*Log, error) {
+	l := &Log{dir: dir, maxFileSize: maxFileSize, files: make(map[uint32]*file), nextID: 1}
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, de := range dirEntries {
+		m := filePattern.FindStringSubmatch(de.Name())
+		if m == nil || de.IsDir() {
+			continue
+		}
+		id, err := strconv.ParseUint(m[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dir, de.Name())
+		f, err := os.Open(path)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			l.Close()
+			return nil, err
+		}
+		l.files[uint32(id)] = &file{path: path, f: f, size: info.Size(), synced: info.Size()}
+		l.nextID = max(l.nextID, uint32(id)+1)
+	}
+	return l, nil
+}
+
+// Append writes key's value to the active file, starting a new one once the
+// active file has reached maxFileSize, and returns where the value landed.
+func (l *Log) Append(family uint32, key, value []byte) (Pointer, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	active := l.files[l.active]
+	if active == nil || active.size >= l.maxFileSize {
+		var err error
+		if active, err = l.rotate(); err != nil {
+			return Pointer{}, err
+		}
+	}
+
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(key)+len(value))
+	binary.BigEndian.PutUint32(record, family)
+	binary.BigEndian.PutUint32(record[4:], uint32(len(key)))
+	binary.BigEndian.PutUint32(record[8:], uint32(len(value)))
+	record = append(record, key...)
+	record = append(record, value...)
+	if _, err := active.f.WriteAt(record, active.size); err != nil {
+		return Pointer{}, err
+	}
+	p := Pointer{
+		File:   l.active,
+		Offset: uint64(active.size) + recordHeaderSize + uint64(len(key)),
+		Length: uint32(len(value)),
+	}
+	active.size += int64(len(record))
+	return p, nil
+}
+
+// Sync forces every value appended so far to stable storage. Files are synced
+// without holding the lock, so appends go on meanwhile.
+func (l *Log) Sync() error {
+	type pending struct {
+		fl   *file
+		size int64
+	}
+	l.mu.Lock()
+	var files []pending
+	for _, fl := range l.files {
+		if fl.synced < fl.size {
+			files = append(files, pending{fl, fl.size})
+		}
+	}
+	l.mu.Unlock()
+
+	for _, p := range files {
+		// A file removed meanwhile holds only garbage.
+		if err := p.fl.f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+			return err
+		}
+		l.mu.Lock()
+		p.fl.synced = max(p.fl.synced, p.size)
+		l.mu.Unlock()
+	}
+	return nil
+}
+
+// Synced reports whether every value appended so far is on stable storage.
+func (l *Log) Synced() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, fl := range l.files {
+		if fl.synced < fl.size {
+			return false
+		}
+	}
+	return true
+}
+
+// rotate creates a new active file. Must be called with l.mu held.
+func (l *Log) rotate() (*file, error) {
+	id := l.nextID
+	path := filepath.Join(l.dir, fmt.Sprintf("vlog-%06d.log", id))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	active := &file{path: path, f: f}
+	l.files[id] = active
+	l.active = id
+	l.nextID++
+	return active, nil
+}
+
+// Read returns the value p points to.
+func (l *Log) Read(p Pointer) ([]byte, error) {
+	l.mu.Lock()
+	fl, created := l.files[p.File], p.File < l.nextID
+	l.mu.Unlock()
+	if fl == nil && created {
+		return nil, fmt.Errorf("%w: file %d", ErrRemoved, p.File)
+	}
+	if fl == nil {
+		return nil, fmt.Errorf("%w: file %d does not exist", ErrCorruptPointer, p.File)
+	}
+	value := make([]byte, p.Length)
+	if _, err := fl.f.ReadAt(value, int64(p.Offset)); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Sealed returns the ids of the files no longer appended to, oldest first.
+func (l *Log) Sealed() []uint32 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var ids []uint32
+	for id, fl := range l.files {
+		if id != l.active && !fl.obsolete {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Size returns the size in bytes of file id.
+func (l *Log) Size(id uint32) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if fl := l.files[id]; fl != nil {
+		return fl.size
+	}
+	return 0
+}
+
+// Scan calls fn for every complete record of file id, in file order. A record
+// cut short at the tail of the file ends the scan.
+func (l *Log) Scan(id uint32, fn func(Record) error) error {
+	l.mu.Lock()
+	fl := l.files[id]
+	l.mu.Unlock()
+	if fl == nil {
+		return fmt.Errorf("vlog: file %d does not exist", id)
+	}
+
+	r := io.NewSectionReader(fl.f, 0, fl.size)
+	var offset int64
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return err
+		}
+		family := binary.BigEndian.Uint32(header)
+		keyLen := binary.BigEndian.Uint32(header[4:])
+		valLen := binary.BigEndian.Uint32(header[8:])
+		key := make([]byte, keyLen)
+		if _, err := io.ReadFull(r, key); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return err
+		}
+		valueOffset := offset + recordHeaderSize + int64(keyLen)
+		if valueOffset+int64(valLen) > fl.size {
+			return nil // torn tail record
+		}
+		if _, err := r.Seek(int64(valLen), io.SeekCurrent); err != nil {
+			return err
+		}
+		if err := fn(Record{Family: family, Key: key, Ptr: Pointer{File: id, Offset: uint64(valueOffset), Length: valLen}}); err != nil {
+			return err
+		}
+		offset = valueOffset + int64(valLen)
+	}
+}
+
+// Pin keeps every current file readable, even if removed, until the returned
+// function is called.
+func (l *Log) Pin() (unpin func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pinned := make([]*file, 0, len(l.files))
+	for _, fl := range l.files {
+		fl.pins++
+		pinned = append(pinned, fl)
+	}
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for _, fl := range pinned {
+			fl.pins--
+			if fl.pins == 0 && fl.obsolete {
+				l.delete(fl)
+			}
+		}
+	}
+}
+
+// Remove deletes sealed file id, or, while it is pinned, marks it for deletion
+// by the last unpin.
+func (l *Log) Remove(id uint32) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fl := l.files[id]
+	if fl == nil || fl.obsolete || id == l.active {
+		return fmt.Errorf("vlog: cannot remove file %d", id)
+	}
+	fl.obsolete = true
+	if fl.pins > 0 {
+		return nil
+	}
+	return l.delete(fl)
+}
+
+// delete closes and removes an obsolete file. Must be called with l.mu held.
+func (l *Log) delete(fl *file) error {
+	for id, other := range l.files {
+		if other == fl {
+			delete(l.files, id)
+		}
+	}
+	fl.f.Close()
+	return os.Remove(fl.path)
+}
+
+// Close closes every file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var errs []error
+	for _, fl := range l.files {
+		errs = append(errs, fl.f.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,149 @@
+package vlog
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestPointer_Encode(t *testing.T) {
+	p := Pointer{File: 7, Offset: 1 << 40, Length: 12345}
+	got, err := DecodePointer(p.Encode())
+	if err != nil || got != p {
+		t.Errorf("DecodePointer(Encode()) = %+v, %v; want %+v", got, err, p)
+	}
+	if _, err := DecodePointer([]byte("short")); err != ErrCorruptPointer {
+		t.Errorf("DecodePointer(short) error = %v, want ErrCorruptPointer", err)
+	}
+}
+
+func TestLog_AppendReadScan(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, 100)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	ptrs := make(map[string]Pointer)
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		p, err := l.Append(uint32(i%2), []byte(key), bytes.Repeat([]byte{byte(i)}, 40))
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		ptrs[key] = p
+	}
+	for key, p := range ptrs {
+		value, err := l.Read(p)
+		if err != nil || len(value) != 40 || value[0] != key[3]-'0' {
+			t.Errorf("Read(%s) = %v, %v", key, value, err)
+		}
+	}
+
+	sealed := l.Sealed()
+	if len(sealed) == 0 {
+		t.Fatalf("Sealed() is empty, want files rotated at 100 bytes")
+	}
+	scanned := 0
+	for _, id := range sealed {
+		err := l.Scan(id, func(rec Record) error {
+			if ptrs[string(rec.Key)] != rec.Ptr {
+				t.Errorf("Scan record %s at %+v, appended at %+v", rec.Key, rec.Ptr, ptrs[string(rec.Key)])
+			}
+			scanned++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+	}
+	if scanned == 0 || scanned >= 10 {
+		t.Errorf("scanned %d records in sealed files, want some but not the active file's", scanned)
+	}
+	l.Close()
+
+	// Values stay readable after reopening; appends go to a new file.
+	l, err = Open(dir, 100)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer l.Close()
+	if value, err := l.Read(ptrs["key9"]); err != nil || value[0] != 9 {
+		t.Errorf("Read after reopen = %v, %v", value, err)
+	}
+	p, err := l.Append(0, []byte("new"), []byte("value"))
+	if err != nil {
+		t.Fatalf("Append after reopen: %v", err)
+	}
+	if p.File <= ptrs["key9"].File {
+		t.Errorf("Append after reopen went to file %d, want a new file", p.File)
+	}
+}
+
+func TestLog_RemovePinned(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, 10)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer l.Close()
+
+	old, _ := l.Append(0, []byte("a"), []byte("old value"))
+	l.Append(0, []byte("b"), []byte("newer value"))
+	path := l.files[old.File].path
+
+	unpin := l.Pin()
+	if err := l.Remove(old.File); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if value, err := l.Read(old); err != nil || string(value) != "old value" {
+		t.Errorf("Read of pinned removed file = %q, %v", value, err)
+	}
+	if len(l.Sealed()) != 0 {
+		t.Errorf("Sealed() = %v, want the removed file excluded", l.Sealed())
+	}
+
+	unpin()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file still exists after the last unpin: %v", err)
+	}
+	if _, err := l.Read(old); err == nil {
+		t.Errorf("Read of deleted file succeeded")
+	}
+	if err := l.Remove(l.active); err == nil {
+		t.Errorf("Remove of the active file succeeded")
+	}
+}
+
+func TestLog_Sync(t *testing.T) {
+	l, err := Open(t.TempDir(), 10)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer l.Close()
+
+	// Each value fills a file, so the appends leave unsynced data in sealed files too.
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := l.Append(0, []byte(key), []byte("some value")); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if err := l.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	for id, fl := range l.files {
+		if fl.synced != fl.size {
+			t.Errorf("file %d: synced %d of %d bytes", id, fl.synced, fl.size)
+		}
+	}
+
+	// A file removed before the next Sync is skipped.
+	l.Append(0, []byte("d"), []byte("some value"))
+	if err := l.Remove(l.Sealed()[0]); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := l.Sync(); err != nil {
+		t.Fatalf("Sync after Remove: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// In the block format, records are cut into fragments laid out in blocks of
+// blockSize bytes, which follow the file header:
+//
+//	fragment: crc(4) | length(2) | type(1) | payload
+//
+// crc is the CRC32C of the type and payload. A record that fits in the rest of
+// its block is one FULL fragment; otherwise it is a FIRST fragment, MIDDLE ones
+// filling whole blocks and a LAST one. A fragment never crosses a block
+// boundary, and the few bytes left at the end of a block when no fragment
+// header fits are zero. A corrupt fragment therefore costs at most the rest of
+// its block: the reader resumes at the next block boundary.
+const (
+	blockSize          = 32 * 1024
+	fragmentHeaderSize = 7
+)
+
+// Fragment types.
+const (
+	fragmentFull   byte = 1
+	fragmentFirst  byte = 2
+	fragmentMiddle byte = 3
+	fragmentLast   byte = 4
+)
+
+// appendFragments appends payload to dst as the fragments of one record,
+// starting blockOffset bytes into a block, and returns the offset into the
+// block after them.
+func appendFragments(dst, payload []byte, blockOffset int) ([]byte, int) {
+	for first := true; ; first = false {
+		left := blockSize - blockOffset
+		if left < fragmentHeaderSize {
+			dst = append(dst, make([]byte, left)...)
+			blockOffset, left = 0, blockSize
+		}
+
+		n := min(len(payload), left-fragmentHeaderSize)
+		last := n == len(payload)
+		typ := fragmentMiddle
+		switch {
+		case first && last:
+			typ = fragmentFull
+		case first:
+			typ = fragmentFirst
+		case last:
+			typ = fragmentLast
+		}
+		dst = appendFragment(dst, typ, payload[:n])
+		blockOffset += fragmentHeaderSize + n
+		payload = payload[n:]
+		if last {
+			return dst, blockOffset
+		}
+	}
+}
+
+func appendFragment(dst []byte, typ byte, payload []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, fragmentChecksum(typ, payload))
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(payload)))
+	dst = append(dst, typ)
+	return append(dst, payload...)
+}
+
+func fragmentChecksum(typ byte, payload []byte) uint32 {
+	return crc32.Update(crc32.Checksum([]byte{typ}, castagnoli), castagnoli, payload)
+}
+
+// parseFragment returns the type and payload of the fragment at the start of
+// data, the rest of its block, and false if it is cut short, fails its
+// checksum or has an unknown type.
+func parseFragment(data []byte) (byte, []byte, bool) {
+	if len(data) < fragmentHeaderSize {
+		return 0, nil, false
+	}
+	length := int(binary.BigEndian.Uint16(data[4:]))
+	typ := data[6]
+	if len(data)-fragmentHeaderSize < length || typ < fragmentFull || typ > fragmentLast {
+		return 0, nil, false
+	}
+	payload := data[fragmentHeaderSize : fragmentHeaderSize+length]
+	if fragmentChecksum(typ, payload) != binary.BigEndian.Uint32(data) {
+		return 0, nil, false
+	}
+	return typ, payload, true
+}
+
+// readBlocks returns the payloads of the complete records in data, the blocks
+// after the file header, and the offset in data just past the last of them. A
+// bad fragment makes the reader drop the record it belongs to and skip to the
+// next block; fragments there that continue a dropped record are skipped too.
+func readBlocks(data []byte) ([][]byte, int) {
+	var records [][]byte
+	var record []byte // fragments of the record being assembled
+	assembling := false
+	end := 0
+	for offset := 0; offset < len(data); {
+		left := blockSize - offset%blockSize
+		if left < fragmentHeaderSize {
+			offset += left
+			continue
+		}
+		typ, payload, ok := parseFragment(data[offset:min(len(data), offset+left)])
+		if !ok {
+			record, assembling = nil, false
+			offset += left
+			continue
+		}
+		offset += fragmentHeaderSize + len(payload)
+
+		switch typ {
+		case fragmentFull:
+			records = append(records, payload)
+			assembling, end = false, offset
+		case fragmentFirst:
+			record, assembling = bytes.Clone(payload), true
+		case fragmentMiddle:
+			if assembling {
+				record = append(record, payload...)
+			}
+		case fragmentLast:
+			if assembling {
+				records = append(records, append(record, payload...))
+				assembling, end = false, offset
+			}
+		}
+	}
+	return records, end
+}
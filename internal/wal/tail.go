@@ -0,0 +1,201 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/maksymus/lmstree/entry"
+)
+
+// Segments returns the paths of the WAL files in dirs, oldest first. Files are
+// ordered by name, which embeds their creation time, so a segment keeps its
+// place when it is archived to another directory.
+func Segments(dirs ...string) ([]string, error) {
+	var paths []string
+	for _, dir := range dirs {
+		files, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if _, err := VersionFromFileName(file.Name()); err == nil && !file.IsDir() {
+				paths = append(paths, filepath.Join(dir, file.Name()))
+			}
+		}
+	}
+	slices.SortFunc(paths, func(a, b string) int {
+		return strings.Compare(filepath.Base(a), filepath.Base(b))
+	})
+	return paths, nil
+}
+
+// PruneArchive deletes the oldest WAL files in dir until the rest take at most
+// maxBytes.
+func PruneArchive(dir string, maxBytes int64) error {
+	paths, err := Segments(dir)
+	if err != nil {
+		return err
+	}
+	sizes := make([]int64, len(paths))
+	var total int64
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		sizes[i] = info.Size()
+		total += sizes[i]
+	}
+	for i := 0; total > maxBytes && i < len(paths); i++ {
+		if err := os.Remove(paths[i]); err != nil {
+			return err
+		}
+		total -= sizes[i]
+	}
+	return nil
+}
+
+// Tail reads the records of a WAL file, including those appended after it was
+// opened. It keeps reading the same file if it is archived or deleted meanwhile.
+type Tail struct {
+	file   *os.File
+	offset int64
+	format uint16
+	opened bool // the file header has been read
+}
+
+// OpenTail opens the WAL file at path for reading from its first record.
+func OpenTail(path string) (*Tail, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Tail{file: file}, nil
+}
+
+// Next returns the entries of the next record. It returns io.EOF if no complete
+// record follows yet; a later call may find one once the writer appends it. A
+// record that fails its checksum may still be being written, so it is reported
+// as io.EOF too.
+func (t *Tail) Next() ([]*entry.Entry, error) {
+	if !t.opened {
+		header := make([]byte, headerSize)
+		n, err := t.file.ReadAt(header, 0)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		format, ok := parseHeader(header[:n])
+		if !ok && n < headerSize {
+			return nil, io.EOF // the header is not written yet
+		}
+		if ok && format > formatCurrent {
+			return nil, fmt.Errorf("WAL file %s has unknown format %d", t.file.Name(), format)
+		}
+		if ok {
+			t.offset = int64(headerSize)
+		}
+		t.format, t.opened = format, true
+	}
+
+	var payload []byte
+	var next int64
+	var err error
+	if t.format == formatBlock {
+		payload, next, err = t.nextBlockRecord()
+	} else {
+		payload, next, err = t.nextFlatRecord()
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries, err := decodeRecord(payload)
+	if err != nil {
+		return nil, err
+	}
+	t.offset = next
+	return entries, nil
+}
+
+// nextFlatRecord reads the record at t.offset in a file of an older format and
+// returns its payload and the offset after it.
+func (t *Tail) nextFlatRecord() ([]byte, int64, error) {
+	header := make([]byte, flatHeaderSize)
+	if _, err := t.file.ReadAt(header, t.offset); err != nil {
+		return nil, 0, eof(err)
+	}
+	record := make([]byte, recordSize(header, t.format))
+	if _, err := t.file.ReadAt(record, t.offset); err != nil {
+		return nil, 0, eof(err)
+	}
+	payload, n := nextRecord(record, t.format)
+	if n == 0 {
+		return nil, 0, io.EOF
+	}
+	return payload, t.offset + int64(n), nil
+}
+
+// nextBlockRecord assembles the fragments of the record at t.offset in a file
+// of the block format and returns its payload and the offset after it.
+func (t *Tail) nextBlockRecord() ([]byte, int64, error) {
+	var record []byte
+	started := false // a FIRST fragment has been read
+	offset := t.offset
+	for {
+		left := blockSize - int((offset-int64(headerSize))%blockSize)
+		if left < fragmentHeaderSize {
+			offset += int64(left)
+			continue
+		}
+		header := make([]byte, fragmentHeaderSize)
+		if _, err := t.file.ReadAt(header, offset); err != nil {
+			return nil, 0, eof(err)
+		}
+		fragment := make([]byte, fragmentHeaderSize+int(binary.BigEndian.Uint16(header[4:])))
+		if len(fragment) > left {
+			return nil, 0, io.EOF
+		}
+		if _, err := t.file.ReadAt(fragment, offset); err != nil {
+			return nil, 0, eof(err)
+		}
+		typ, payload, ok := parseFragment(fragment)
+		if !ok {
+			return nil, 0, io.EOF
+		}
+
+		switch {
+		case typ == fragmentFull && !started:
+			return payload, offset + int64(len(fragment)), nil
+		case typ == fragmentFirst && !started:
+			record, started = append(record, payload...), true
+		case typ == fragmentMiddle && started:
+			record = append(record, payload...)
+		case typ == fragmentLast && started:
+			return append(record, payload...), offset + int64(len(fragment)), nil
+		default:
+			return nil, 0, fmt.Errorf("WAL file %s has a misplaced fragment at offset %d", t.file.Name(), offset)
+		}
+		offset += int64(len(fragment))
+	}
+}
+
+// eof maps a short read to io.EOF.
+func eof(err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return io.EOF
+	}
+	return err
+}
+
+// Close closes the file.
+func (t *Tail) Close() error {
+	return t.file.Close()
+}
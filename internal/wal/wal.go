@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
@@ -22,6 +23,7 @@ type WalFile interface {
 	io.Writer
 	io.Seeker
 	io.Closer
+	Sync() error
 }
 
 // WAL represents a Write-Ahead Log for the LSM tree.
@@ -32,6 +34,36 @@ type WAL struct {
 	path    string
 	version string
 	pool    *pool.BytesBufferPool
+	header  bool  // the file starts with a file header
+	legacy  bool  // the file holds records of an older format and is read-only
+	written int64 // bytes written to the file, guarded by mutex
+	offset  int   // offset into the current block of the end of the file
+
+	syncMu  sync.Mutex
+	synced  int64         // bytes known to be on stable storage
+	syncing chan struct{} // closed when the fsync in progress ends; nil if none
+}
+
+// Every WAL file starts with a header of magic | format(2). Files written before
+// the header existed have none and hold bare entries, each written on its own
+// with no framing or checksum. Files of every format are read; only the current
+// one is written.
+const (
+	magic      = "LSMWAL"
+	headerSize = len(magic) + 2
+
+	formatLegacy  uint16 = 0 // keyLen(4) | valLen(4) | key | value | tombstone(1), no file header
+	formatCRC     uint16 = 1 // recordLen(4) | crc(4) | payload
+	formatBlock   uint16 = 2 // fragments in 32 KB blocks, see block.go
+	formatCurrent        = formatBlock
+)
+
+// castagnoli is the CRC32C table records are checksummed with.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// truncater is implemented by WAL files that can drop a corrupt tail.
+type truncater interface {
+	Truncate(size int64) error
 }
 
 func Create(dir string) (*WAL, error) {
@@ -40,7 +72,7 @@ func Create(dir string) (*WAL, error) {
 	}
 
 	createdAt := time.Now()
-	version := fmt.Sprintf("%s-%d", createdAt.Format("20060102150405"), createdAt.Nanosecond())
+	version := fmt.Sprintf("%s-%09d", createdAt.Format("20060102150405"), createdAt.Nanosecond())
 	path := fmt.Sprintf("%s/wal-%s.log", dir, version)
 
 	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
@@ -48,13 +80,17 @@ func Create(dir string) (*WAL, error) {
 		return nil, err
 	}
 
-	return &WAL{
+	w := &WAL{
 		file:    file,
 		dir:     dir,
 		path:    path,
 		version: version,
 		pool:    pool.NewBytesBufferPool(),
-	}, nil
+	}
+	if err := w.writeHeader(); err != nil {
+		return nil, errors.Join(err, file.Close())
+	}
+	return w, nil
 }
 
 func Open(path string) (*WAL, error) {
@@ -63,14 +99,74 @@ func Open(path string) (*WAL, error) {
 		return nil, err
 	}
 
-	return &WAL{
-		file: walFile,
-		dir:  filepath.Dir(path),
-		path: path,
-		pool: pool.NewBytesBufferPool(),
-	}, nil
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(walFile, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, errors.Join(err, walFile.Close())
+	}
+	format, ok := parseHeader(header[:n])
+	if ok && format > formatCurrent {
+		return nil, errors.Join(fmt.Errorf("WAL file %s has unknown format %d", path, format), walFile.Close())
+	}
+	size, err := walFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, errors.Join(err, walFile.Close())
+	}
+
+	w := &WAL{
+		file:    walFile,
+		dir:     filepath.Dir(path),
+		path:    path,
+		pool:    pool.NewBytesBufferPool(),
+		header:  ok,
+		legacy:  n > 0 && (!ok || format != formatCurrent),
+		written: size,
+	}
+	if ok {
+		w.offset = int((size - int64(headerSize)) % blockSize)
+	}
+	return w, nil
+}
+
+// writeHeader writes the file header for the current format.
+func (w *WAL) writeHeader() error {
+	header := binary.BigEndian.AppendUint16([]byte(magic), formatCurrent)
+	if _, err := w.file.Write(header); err != nil {
+		return err
+	}
+	w.header = true
+	w.written += int64(len(header))
+	return nil
 }
 
+// parseHeader returns the format recorded in a file header, and false if data
+// does not start with one.
+func parseHeader(data []byte) (uint16, bool) {
+	if len(data) < headerSize || string(data[:len(magic)]) != magic {
+		return formatLegacy, false
+	}
+	return binary.BigEndian.Uint16(data[len(magic):]), true
+}
+
+// Entry flag bits, stored in the byte after the value.
+const (
+	flagTombstone uint8 = 1 << 0
+	flagSeq       uint8 = 1 << 1 // a seq(8) field follows the flags byte
+	flagOperand   uint8 = 1 << 2 // the value is a merge operand
+	flagExpiry    uint8 = 1 << 3 // an expiresAt(8) field follows the seq
+	flagFamily    uint8 = 1 << 4 // a family(4) field follows the expiresAt
+	flagRangeDel  uint8 = 1 << 5 // a range tombstone; the value is the range end
+	flagPointer   uint8 = 1 << 6 // the value is a value-log pointer
+)
+
+// Write appends entries to the log as a single record, written as fragments
+// (see block.go) of the payload:
+//
+//	entry 1 | entry 2 | ...
+//	entry: keyLen(4) | valLen(4) | key | value | flags(1) | [seq(8)] | [expiresAt(8)] | [family(4)]
+//
+// A record is the unit of recovery: Read returns either all of its entries or,
+// if the record was torn by a crash or corrupted, none of them.
 func (w *WAL) Write(entries ...*entry.Entry) error {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
@@ -78,6 +174,9 @@ func (w *WAL) Write(entries ...*entry.Entry) error {
 	if w.file == nil {
 		return fmt.Errorf("WAL file is not open")
 	}
+	if w.legacy {
+		return fmt.Errorf("WAL file %s has an older format and is read-only", w.path)
+	}
 
 	for i, e := range entries {
 		if e == nil {
@@ -91,39 +190,144 @@ func (w *WAL) Write(entries ...*entry.Entry) error {
 		}
 	}
 
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if !w.header {
+		if err := w.writeHeader(); err != nil {
+			return err
+		}
+	}
+
 	buffer := w.pool.Get()
 	defer w.pool.Put(buffer)
 
 	for _, e := range entries {
 		keyLen, dataLen := len(e.Key), len(e.Value)
+		var flags uint8
+		if e.Tombstone {
+			flags |= flagTombstone
+		}
+		if e.Operand {
+			flags |= flagOperand
+		}
+		if e.RangeDelete {
+			flags |= flagRangeDel
+		}
+		if e.ValuePointer {
+			flags |= flagPointer
+		}
+		if e.ExpiresAt != 0 {
+			flags |= flagExpiry
+		}
+		if e.Family != 0 {
+			flags |= flagFamily
+		}
+		if e.Seq != 0 {
+			flags |= flagSeq
+		}
 		if err := errors.Join(
 			binary.Write(buffer, binary.BigEndian, uint32(keyLen)),
 			binary.Write(buffer, binary.BigEndian, uint32(dataLen)),
 			binary.Write(buffer, binary.BigEndian, e.Key),
 			binary.Write(buffer, binary.BigEndian, e.Value),
-			binary.Write(buffer, binary.BigEndian, e.Tombstone),
+			binary.Write(buffer, binary.BigEndian, flags),
 		); err != nil {
 			return err
 		}
-
-		if buffer.Len() > 5*1024*1024 {
-			if _, err := w.file.Write(buffer.Bytes()); err != nil {
+		if flags&flagSeq != 0 {
+			if err := binary.Write(buffer, binary.BigEndian, e.Seq); err != nil {
 				return err
 			}
-			buffer.Reset()
 		}
-	}
-
-	if buffer.Len() > 0 {
-		if _, err := w.file.Write(buffer.Bytes()); err != nil {
-			return err
+		if flags&flagExpiry != 0 {
+			if err := binary.Write(buffer, binary.BigEndian, e.ExpiresAt); err != nil {
+				return err
+			}
+		}
+		if flags&flagFamily != 0 {
+			if err := binary.Write(buffer, binary.BigEndian, e.Family); err != nil {
+				return err
+			}
 		}
 	}
 
+	record, offset := appendFragments(nil, buffer.Bytes(), w.offset)
+	if _, err := w.file.Write(record); err != nil {
+		return err
+	}
+	w.written += int64(len(record))
+	w.offset = offset
 	return nil
 }
 
+// Sync forces the records written so far to stable storage. Concurrent calls
+// share fsyncs: a call that finds one in progress waits for it, and starts
+// another only if that one did not cover its records. Sync on a closed WAL
+// returns nil, since Close syncs the file.
+func (w *WAL) Sync() error {
+	w.mutex.Lock()
+	file, target := w.file, w.written
+	w.mutex.Unlock()
+	if file == nil {
+		return nil
+	}
+
+	w.syncMu.Lock()
+	for w.synced < target && w.syncing != nil {
+		done := w.syncing
+		w.syncMu.Unlock()
+		<-done
+		w.syncMu.Lock()
+	}
+	if w.synced >= target {
+		w.syncMu.Unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	w.syncing = done
+	w.syncMu.Unlock()
+
+	// Cover every record written by now, not only the caller's.
+	w.mutex.Lock()
+	end := w.written
+	w.mutex.Unlock()
+	err := file.Sync()
+	if errors.Is(err, os.ErrClosed) {
+		err = nil
+	}
+
+	w.syncMu.Lock()
+	if err == nil {
+		w.synced = max(w.synced, end)
+	}
+	w.syncing = nil
+	close(done)
+	w.syncMu.Unlock()
+	return err
+}
+
+// Read returns every entry of every complete record, in log order.
 func (w *WAL) Read() ([]*entry.Entry, error) {
+	batches, err := w.ReadBatches()
+	if err != nil {
+		return nil, err
+	}
+	var entries []*entry.Entry
+	for _, batch := range batches {
+		entries = append(entries, batch...)
+	}
+	return entries, nil
+}
+
+// ReadBatches returns the entries of each complete record, one slice per Write
+// call, or per entry in a file without a file header. A record cut short at the tail of the file (a torn write) is dropped,
+// and so are corrupt ones: in a file of the block format, a corrupt fragment
+// loses the rest of its block and reading resumes at the next one, while in
+// older formats reading stops at the first record that fails its checksum. The
+// file is truncated after the last complete record, so later writes follow it.
+func (w *WAL) ReadBatches() ([][]*entry.Entry, error) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
@@ -142,38 +346,158 @@ func (w *WAL) Read() ([]*entry.Entry, error) {
 		return nil, err
 	}
 
-	var entries []*entry.Entry
-	reader := bytes.NewReader(buffer.Bytes())
+	data := buffer.Bytes()
+	format, ok := parseHeader(data)
+	if ok && format > formatCurrent {
+		return nil, fmt.Errorf("WAL file %s has unknown format %d", w.path, format)
+	}
+	start := 0
+	if ok {
+		start = headerSize
+	}
+
+	var records [][]byte
+	var end int
+	if format == formatBlock {
+		records, end = readBlocks(data[start:])
+	} else {
+		records, end = readFlat(data[start:], format)
+	}
+
+	var batches [][]*entry.Entry
+	for _, record := range records {
+		batch, err := decodeRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+
+	valid := start + end
+
+	if valid < len(data) {
+		if file, ok := w.file.(truncater); ok {
+			if err := file.Truncate(int64(valid)); err != nil {
+				return nil, err
+			}
+			if _, err := w.file.Seek(int64(valid), io.SeekStart); err != nil {
+				return nil, err
+			}
+			w.written = int64(valid)
+			w.offset = end % blockSize
+		}
+	}
+
+	return batches, nil
+}
+
+// readFlat returns the payloads of the records in data, the records of a file
+// of an older format after its file header if any, up to the first one that is
+// cut short or fails its checksum, and the offset in data just past the last.
+func readFlat(data []byte, format uint16) ([][]byte, int) {
+	var records [][]byte
+	end := 0
 	for {
+		payload, n := nextRecord(data[end:], format)
+		if n == 0 {
+			return records, end
+		}
+		records = append(records, payload)
+		end += n
+	}
+}
+
+// nextRecord returns the payload of the record at the start of data and the
+// number of bytes it takes, or 0 if data does not start with a complete record
+// that passes its checksum.
+func nextRecord(data []byte, format uint16) ([]byte, int) {
+	if len(data) < flatHeaderSize {
+		return nil, 0
+	}
+	size := recordSize(data, format)
+	if uint64(len(data)) < size {
+		return nil, 0
+	}
+	if format == formatLegacy {
+		// A bare entry is a payload of its own; its tombstone byte is a bool.
+		if data[size-1] > flagTombstone {
+			return nil, 0
+		}
+		return data[:size], int(size)
+	}
+	payload := data[flatHeaderSize:size]
+	if crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(data[4:]) {
+		return nil, 0
+	}
+	return payload, int(size)
+}
+
+// flatHeaderSize is the size of the header a record's size is read from in the
+// older formats: keyLen(4) | valLen(4) of a bare entry, or recordLen(4) | crc(4).
+const flatHeaderSize = 8
+
+// recordSize returns the size of the record whose header starts data.
+func recordSize(header []byte, format uint16) uint64 {
+	if format == formatLegacy {
+		return flatHeaderSize + uint64(binary.BigEndian.Uint32(header)) + uint64(binary.BigEndian.Uint32(header[4:])) + 1
+	}
+	return flatHeaderSize + uint64(binary.BigEndian.Uint32(header))
+}
+
+// decodeRecord decodes the entries of one record payload.
+func decodeRecord(payload []byte) ([]*entry.Entry, error) {
+	var entries []*entry.Entry
+	reader := bytes.NewReader(payload)
+	for reader.Len() > 0 {
 		var keyLen uint32
 		var dataLen uint32
 		if err := errors.Join(
 			binary.Read(reader, binary.BigEndian, &keyLen),
 			binary.Read(reader, binary.BigEndian, &dataLen),
 		); err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
 			return nil, err
 		}
 
 		key := make([]byte, keyLen)
 		value := make([]byte, dataLen)
-		var tombstone uint8
+		var flags uint8
 		if err := errors.Join(
 			binary.Read(reader, binary.BigEndian, &key),
 			binary.Read(reader, binary.BigEndian, &value),
-			binary.Read(reader, binary.BigEndian, &tombstone),
+			binary.Read(reader, binary.BigEndian, &flags),
 		); err != nil {
 			return nil, err
 		}
 
-		entries = append(entries, &entry.Entry{Key: key, Value: value, Tombstone: tombstone != 0})
+		e := &entry.Entry{
+			Key:          key,
+			Value:        value,
+			Tombstone:    flags&flagTombstone != 0,
+			Operand:      flags&flagOperand != 0,
+			RangeDelete:  flags&flagRangeDel != 0,
+			ValuePointer: flags&flagPointer != 0,
+		}
+		if flags&flagSeq != 0 {
+			if err := binary.Read(reader, binary.BigEndian, &e.Seq); err != nil {
+				return nil, err
+			}
+		}
+		if flags&flagExpiry != 0 {
+			if err := binary.Read(reader, binary.BigEndian, &e.ExpiresAt); err != nil {
+				return nil, err
+			}
+		}
+		if flags&flagFamily != 0 {
+			if err := binary.Read(reader, binary.BigEndian, &e.Family); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
 	}
-
 	return entries, nil
 }
 
+// Close syncs and closes the file.
 func (w *WAL) Close() error {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
@@ -182,7 +506,7 @@ func (w *WAL) Close() error {
 		return fmt.Errorf("WAL file is not open")
 	}
 
-	if err := w.file.Close(); err != nil {
+	if err := errors.Join(w.file.Sync(), w.file.Close()); err != nil {
 		return err
 	}
 	w.file = nil
@@ -203,6 +527,33 @@ func (w *WAL) Delete() error {
 	return os.Remove(w.path)
 }
 
+// Archive closes the WAL and moves its file into dir, where Segments still finds
+// it but recovery no longer replays it.
+func (w *WAL) Archive(dir string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, filepath.Base(w.path))
+	if err := os.Rename(w.path, path); err != nil {
+		return err
+	}
+	w.path = path
+	return nil
+}
+
+// CompareVersion orders this WAL against another version string: positive if
+// this WAL is newer, negative if older. Files written before nanoseconds were
+// zero-padded compare numerically, so a shorter nanosecond part sorts first.
 func (w *WAL) CompareVersion(version string) int {
 	walParts := strings.Split(w.version, "-")
 	parts := strings.Split(version, "-")
@@ -215,6 +566,12 @@ func (w *WAL) CompareVersion(version string) int {
 		return strings.Compare(walParts[0], parts[0])
 	}
 
+	if len(walParts[1]) < len(parts[1]) {
+		return -1
+	}
+	if len(walParts[1]) > len(parts[1]) {
+		return 1
+	}
 	return strings.Compare(walParts[1], parts[1])
 }
 
@@ -2,8 +2,16 @@ package wal
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/maksymus/lmstree/entry"
 	"github.com/maksymus/lmstree/internal/pool"
@@ -17,6 +25,7 @@ func (i *InMemoryWalFile) Read(p []byte) (n int, err error)        { return i.bu
 func (i *InMemoryWalFile) Write(p []byte) (n int, err error)       { return i.buffer.Write(p) }
 func (i *InMemoryWalFile) Seek(offset int64, whence int) (int64, error) { return 0, nil }
 func (i *InMemoryWalFile) Close() error                             { return nil }
+func (i *InMemoryWalFile) Sync() error                              { return nil }
 
 func NewInMemoryWalFile() WalFile {
 	return &InMemoryWalFile{buffer: bytes.Buffer{}}
@@ -68,8 +77,13 @@ func TestWAL_Read(t *testing.T) {
 
 	entries := []*entry.Entry{
 		{Key: []byte("key1"), Value: []byte("value1")},
-		{Key: []byte("key2"), Value: []byte("value2"), Tombstone: true},
-		{Key: []byte("key3"), Value: []byte("value3")},
+		{Key: []byte("key2"), Value: []byte("value2"), Tombstone: true, Seq: 7},
+		{Key: []byte("key3"), Value: []byte("value3"), Seq: 1 << 40},
+		{Key: []byte("key4"), Value: []byte("+1"), Operand: true, Seq: 9},
+		{Key: []byte("key5"), Value: []byte("value5"), Seq: 10, ExpiresAt: 1700000000000000000},
+		{Key: []byte("key6"), Value: []byte("value6"), Seq: 11, Family: 3},
+		{Key: []byte("key7"), Value: []byte("key9"), RangeDelete: true, Seq: 12},
+		{Key: []byte("key8"), Value: []byte("0123456789abcdef"), ValuePointer: true, Seq: 13},
 	}
 
 	if err := w.Write(entries...); err != nil {
@@ -94,6 +108,314 @@ func TestWAL_Read(t *testing.T) {
 		if got[i].Tombstone != e.Tombstone {
 			t.Errorf("entry[%d].Tombstone = %v, want %v", i, got[i].Tombstone, e.Tombstone)
 		}
+		if got[i].Seq != e.Seq {
+			t.Errorf("entry[%d].Seq = %d, want %d", i, got[i].Seq, e.Seq)
+		}
+		if got[i].Operand != e.Operand {
+			t.Errorf("entry[%d].Operand = %v, want %v", i, got[i].Operand, e.Operand)
+		}
+		if got[i].ExpiresAt != e.ExpiresAt {
+			t.Errorf("entry[%d].ExpiresAt = %d, want %d", i, got[i].ExpiresAt, e.ExpiresAt)
+		}
+		if got[i].Family != e.Family {
+			t.Errorf("entry[%d].Family = %d, want %d", i, got[i].Family, e.Family)
+		}
+		if got[i].RangeDelete != e.RangeDelete {
+			t.Errorf("entry[%d].RangeDelete = %v, want %v", i, got[i].RangeDelete, e.RangeDelete)
+		}
+		if got[i].ValuePointer != e.ValuePointer {
+			t.Errorf("entry[%d].ValuePointer = %v, want %v", i, got[i].ValuePointer, e.ValuePointer)
+		}
+	}
+}
+
+func TestWAL_ReadBatches_TornTail(t *testing.T) {
+	file := &InMemoryWalFile{}
+	w := &WAL{file: file, pool: pool.NewBytesBufferPool()}
+
+	if err := w.Write(
+		&entry.Entry{Key: []byte("a"), Value: []byte("1")},
+		&entry.Entry{Key: []byte("b"), Value: []byte("2")},
+	); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if err := w.Write(&entry.Entry{Key: []byte("c"), Value: []byte("3")}); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	file.buffer.Truncate(file.buffer.Len() - 2)
+
+	batches, err := w.ReadBatches()
+	if err != nil {
+		t.Fatalf("ReadBatches() error: %v", err)
+	}
+	if len(batches) != 1 || len(batches[0]) != 2 {
+		t.Fatalf("ReadBatches() = %d batches, want the single complete batch of 2 entries", len(batches))
+	}
+	if !bytes.Equal(batches[0][1].Key, []byte("b")) {
+		t.Errorf("batch[0][1].Key = %s, want b", batches[0][1].Key)
+	}
+}
+
+func TestWAL_ReadBatches_Corrupt(t *testing.T) {
+	w, err := Create(t.TempDir())
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := w.Write(&entry.Entry{Key: []byte(key), Value: []byte("value")}); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+	path := w.path
+	w.Close()
+
+	// Flip a byte in the value of the second record.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	recordSize := (len(data) - headerSize) / 3
+	data[headerSize+2*recordSize-3] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	w, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer w.Close()
+	batches, err := w.ReadBatches()
+	if err != nil {
+		t.Fatalf("ReadBatches() error: %v", err)
+	}
+	if len(batches) != 1 || !bytes.Equal(batches[0][0].Key, []byte("a")) {
+		t.Fatalf("ReadBatches() = %d batches, want only the record before the corrupt one", len(batches))
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(headerSize+recordSize) {
+		t.Errorf("file size after ReadBatches = %d, want %d", info.Size(), headerSize+recordSize)
+	}
+}
+
+func TestWAL_ReadBatches_Legacy(t *testing.T) {
+	// testdata holds a file written by WAL.Write before records were framed:
+	// a=1, then b=2 and c=3 in one call, a deleted, b=22.
+	data, err := os.ReadFile(filepath.Join("testdata", "wal-20240101000000-5000000.log"))
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	// A torn entry at the tail is dropped.
+	data = append(data, 0, 0, 0, 1, 0, 0, 0, 5, 'x')
+	path := filepath.Join(t.TempDir(), "wal-20240101000000-5000000.log")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	w, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer w.Close()
+	batches, err := w.ReadBatches()
+	if err != nil {
+		t.Fatalf("ReadBatches() error: %v", err)
+	}
+	var got []string
+	for _, batch := range batches {
+		if len(batch) != 1 {
+			t.Errorf("batch of %d entries, want one per entry", len(batch))
+		}
+		for _, e := range batch {
+			got = append(got, fmt.Sprintf("%s=%s/%v", e.Key, e.Value, e.Tombstone))
+		}
+	}
+	if want := "[a=1/false b=2/false c=3/false a=/true b=22/false]"; fmt.Sprint(got) != want {
+		t.Fatalf("ReadBatches() = %v, want %s", got, want)
+	}
+	if err := w.Write(&entry.Entry{Key: []byte("x"), Value: []byte("y")}); err == nil {
+		t.Error("Write() to a legacy WAL succeeded, want an error")
+	}
+}
+
+func TestWAL_LargeRecordSpansBlocks(t *testing.T) {
+	w, err := Create(t.TempDir())
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	defer w.Close()
+	large := bytes.Repeat([]byte("v"), 3*blockSize)
+	values := [][]byte{[]byte("small"), large, []byte("after")}
+	for i, value := range values {
+		if err := w.Write(&entry.Entry{Key: []byte{'a' + byte(i)}, Value: value}); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+
+	batches, err := w.ReadBatches()
+	if err != nil {
+		t.Fatalf("ReadBatches() error: %v", err)
+	}
+	if len(batches) != len(values) {
+		t.Fatalf("ReadBatches() = %d batches, want %d", len(batches), len(values))
+	}
+	for i, value := range values {
+		if !bytes.Equal(batches[i][0].Value, value) {
+			t.Errorf("batch %d: value of %d bytes, want %d", i, len(batches[i][0].Value), len(value))
+		}
+	}
+
+	tail, err := OpenTail(w.path)
+	if err != nil {
+		t.Fatalf("OpenTail() error: %v", err)
+	}
+	defer tail.Close()
+	for i, value := range values {
+		if batch, err := tail.Next(); err != nil || !bytes.Equal(batch[0].Value, value) {
+			t.Fatalf("Next() %d = %v; want a value of %d bytes", i, err, len(value))
+		}
+	}
+}
+
+func TestWAL_ReadBatches_CorruptBlock(t *testing.T) {
+	w, err := Create(t.TempDir())
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	// Records of 10 KB, about three per block, their [start, end) in the file.
+	type span struct{ start, end int64 }
+	var spans []span
+	for i := 0; i < 12; i++ {
+		start := w.written
+		if err := w.Write(&entry.Entry{Key: []byte{byte(i)}, Value: bytes.Repeat([]byte("v"), 10*1024)}); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+		spans = append(spans, span{start, w.written})
+	}
+	path := w.path
+	w.Close()
+
+	// Corrupt the first fragment of the second block: the records with a byte
+	// in that block are lost, the others survive.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	block1, block2 := int64(headerSize+blockSize), int64(headerSize+2*blockSize)
+	data[block1] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+	var want []byte
+	for i, s := range spans {
+		if s.end <= block1 || s.start >= block2 {
+			want = append(want, byte(i))
+		}
+	}
+
+	w, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer w.Close()
+	batches, err := w.ReadBatches()
+	if err != nil {
+		t.Fatalf("ReadBatches() error: %v", err)
+	}
+	var got []byte
+	for _, batch := range batches {
+		got = append(got, batch[0].Key...)
+	}
+	if !bytes.Equal(got, want) || len(want) == len(spans) {
+		t.Errorf("ReadBatches() keys = %v, want %v", got, want)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(data)) {
+		t.Errorf("file size = %d, want %d: the last record is intact", info.Size(), len(data))
+	}
+}
+
+func TestWAL_ReadBatches_FormatCRC(t *testing.T) {
+	// A file of the format before blocks: a header for format 1 and a record
+	// framed as recordLen(4) | crc(4) | payload.
+	path := filepath.Join(t.TempDir(), "wal-20250101000000-000000000.log")
+	payload := []byte{0, 0, 0, 1, 0, 0, 0, 1, 'k', 'v', 0}
+	data := binary.BigEndian.AppendUint16([]byte(magic), formatCRC)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(payload)))
+	data = binary.BigEndian.AppendUint32(data, crc32.Checksum(payload, castagnoli))
+	data = append(data, payload...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	w, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer w.Close()
+	got, err := w.Read()
+	if err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if len(got) != 1 || string(got[0].Key) != "k" || string(got[0].Value) != "v" {
+		t.Fatalf("Read() = %v, want k=v", got)
+	}
+	if err := w.Write(&entry.Entry{Key: []byte("x"), Value: []byte("y")}); err == nil {
+		t.Error("Write() to a WAL of an older format succeeded, want an error")
+	}
+
+	tail, err := OpenTail(path)
+	if err != nil {
+		t.Fatalf("OpenTail() error: %v", err)
+	}
+	defer tail.Close()
+	if batch, err := tail.Next(); err != nil || string(batch[0].Key) != "k" {
+		t.Fatalf("Next() = %v, %v; want k=v", batch, err)
+	}
+}
+
+// syncCountingFile is an in-memory WAL file whose Sync is slow and counted.
+type syncCountingFile struct {
+	InMemoryWalFile
+	mu    sync.Mutex
+	syncs int
+}
+
+func (f *syncCountingFile) Sync() error {
+	time.Sleep(time.Millisecond)
+	f.mu.Lock()
+	f.syncs++
+	f.mu.Unlock()
+	return nil
+}
+
+func TestWAL_Sync_Group(t *testing.T) {
+	file := &syncCountingFile{}
+	w := &WAL{file: file, pool: pool.NewBytesBufferPool()}
+
+	const writers = 16
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.Write(&entry.Entry{Key: []byte("k"), Value: []byte("v")}); err != nil {
+				t.Errorf("Write() error: %v", err)
+			}
+			if err := w.Sync(); err != nil {
+				t.Errorf("Sync() error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if w.synced != w.written {
+		t.Errorf("synced = %d bytes, want all %d written", w.synced, w.written)
+	}
+	if file.syncs >= writers {
+		t.Errorf("%d writers made %d fsyncs, want them shared", writers, file.syncs)
+	}
+	before := file.syncs
+	if err := w.Sync(); err != nil || file.syncs != before {
+		t.Errorf("Sync() with nothing new written = %v, made %d fsyncs", err, file.syncs-before)
 	}
 }
 
@@ -128,6 +450,7 @@ func TestWAL_CompareVersion(t *testing.T) {
 		{"this version is greater (timestamp)", "20250101130000-123456", "20250101120000-123456", 1},
 		{"this version is less (nanoseconds)", "20250101120000-100000", "20250101120000-200000", -1},
 		{"this version is greater (nanoseconds)", "20250101120000-300000", "20250101120000-200000", 1},
+		{"unpadded nanoseconds compare numerically", "20250101120000-5000000", "20250101120000-40000000", -1},
 		{"malformed version returns 0", "bad", "20250101120000-123456", 0},
 		{"malformed other returns 0", "20250101120000-123456", "bad", 0},
 	}
@@ -179,13 +502,6 @@ func TestNoopWAL_Write(t *testing.T) {
 	}
 }
 
-func TestNoopWAL_CompareVersion(t *testing.T) {
-	n := &NoopWAL{}
-	if got := n.CompareVersion("20250101120000-123456"); got != 1 {
-		t.Fatalf("NoopWAL.CompareVersion() = %d, want 1", got)
-	}
-}
-
 func TestWAL_Delete_InMemory(t *testing.T) {
 	w := &WAL{
 		file: NewInMemoryWalFile(),
@@ -204,3 +520,78 @@ func TestWAL_Delete_InMemory(t *testing.T) {
 		t.Errorf("expected error to mention file path, got: %v", err)
 	}
 }
+
+func TestTail_Next(t *testing.T) {
+	w, err := Create(t.TempDir())
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	defer w.Close()
+	w.Write(&entry.Entry{Key: []byte("a"), Value: []byte("1"), Seq: 1}, &entry.Entry{Key: []byte("b"), Value: []byte("2"), Seq: 2})
+
+	tail, err := OpenTail(w.path)
+	if err != nil {
+		t.Fatalf("OpenTail() error: %v", err)
+	}
+	defer tail.Close()
+	if batch, err := tail.Next(); err != nil || len(batch) != 2 || batch[1].Seq != 2 {
+		t.Fatalf("Next() = %v, %v; want the first record", batch, err)
+	}
+	if _, err := tail.Next(); err != io.EOF {
+		t.Fatalf("Next() at the end error = %v, want io.EOF", err)
+	}
+
+	// A record being appended is not returned until it is complete.
+	w.file.Write([]byte{0, 0, 0, 100, 1, 2})
+	if _, err := tail.Next(); err != io.EOF {
+		t.Fatalf("Next() on a partial record error = %v, want io.EOF", err)
+	}
+	if err := w.Archive(t.TempDir()); err != nil {
+		t.Fatalf("Archive() error: %v", err)
+	}
+	if _, err := tail.Next(); err != io.EOF {
+		t.Fatalf("Next() after Archive error = %v, want io.EOF", err)
+	}
+}
+
+func TestWAL_ArchiveAndPrune(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive")
+	var names []string
+	for i := 0; i < 3; i++ {
+		w, err := Create(dir)
+		if err != nil {
+			t.Fatalf("Create() error: %v", err)
+		}
+		w.Write(&entry.Entry{Key: []byte("k"), Value: bytes.Repeat([]byte("v"), 100)})
+		names = append(names, filepath.Base(w.path))
+		if i < 2 {
+			if err := w.Archive(archive); err != nil {
+				t.Fatalf("Archive() error: %v", err)
+			}
+		} else {
+			w.Close()
+		}
+	}
+
+	segments, err := Segments(archive, dir, filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatalf("Segments() error: %v", err)
+	}
+	if len(segments) != 3 {
+		t.Fatalf("Segments() = %v, want 3 segments", segments)
+	}
+	for i, path := range segments {
+		if filepath.Base(path) != names[i] {
+			t.Errorf("Segments()[%d] = %s, want %s", i, path, names[i])
+		}
+	}
+
+	if err := PruneArchive(archive, 150); err != nil {
+		t.Fatalf("PruneArchive() error: %v", err)
+	}
+	segments, _ = Segments(archive)
+	if len(segments) != 1 || filepath.Base(segments[0]) != names[1] {
+		t.Errorf("after PruneArchive, Segments() = %v, want only %s", segments, names[1])
+	}
+}
@@ -0,0 +1,527 @@
+package lmstree
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/maksymus/lmstree/entry"
+	"github.com/maksymus/lmstree/internal/heap"
+)
+
+// internalIterator is the cursor contract shared by MemTable and SSTable
+// iterators. Entries include tombstones; the public Iterator hides them.
+type internalIterator interface {
+	Valid() bool
+	Entry() *entry.Entry
+	SeekToFirst()
+	SeekToLast()
+	Seek(key []byte)
+	Next()
+	Prev()
+	Err() error
+}
+
+// direction is the way a merging or public iterator is currently moving.
+type direction int
+
+const (
+	forward direction = iota
+	reverse
+)
+
+// mergeItem is the heap element of a mergingIterator: the current entry of one child.
+type mergeItem struct {
+	entry *entry.Entry
+	child int
+}
+
+// compareItems orders merge items by key, then newest version first (higher
+// sequence number, then newer child). This is the total order the
+// mergingIterator walks forward, and walks backward in reverse.
+func (m *mergingIterator) compareItems(a, b mergeItem) int {
+	if c := m.cmp.Compare(a.entry.Key, b.entry.Key); c != 0 {
+		return c
+	}
+	if a.entry.Seq != b.entry.Seq {
+		if a.entry.Seq > b.entry.Seq {
+			return -1
+		}
+		return 1
+	}
+	return a.child - b.child
+}
+
+// mergingIterator performs a k-way merge over child iterators ordered newest
+// first. Versions of a key are yielded newest first, so the first entry seen
+// for a key is its live version. Moving forward it keeps a min-heap of the
+// children's entries; moving backward, a max-heap.
+type mergingIterator struct {
+	children []internalIterator
+	cmp      Comparator
+	heap     *heap.Heap[mergeItem]
+	dir      direction
+}
+
+func newMergingIterator(children []internalIterator, cmp Comparator) *mergingIterator {
+	return &mergingIterator{children: children, cmp: cmp}
+}
+
+// rebuild refills the heap for the current direction from every valid child.
+func (m *mergingIterator) rebuild() {
+	less := func(a, b mergeItem) bool { return m.compareItems(a, b) < 0 }
+	if m.dir == reverse {
+		less = func(a, b mergeItem) bool { return m.compareItems(a, b) > 0 }
+	}
+	m.heap = heap.NewHeapWithCapacity[mergeItem](len(m.children), less)
+	for i, child := range m.children {
+		if child.Valid() {
+			m.heap.Push(mergeItem{entry: child.Entry(), child: i})
+		}
+	}
+}
+
+func (m *mergingIterator) Valid() bool { return m.heap != nil && m.heap.Len() > 0 }
+
+func (m *mergingIterator) Entry() *entry.Entry {
+	item, _ := m.heap.Peek()
+	return item.entry
+}
+
+func (m *mergingIterator) SeekToFirst() {
+	for _, child := range m.children {
+		child.SeekToFirst()
+	}
+	m.dir = forward
+	m.rebuild()
+}
+
+func (m *mergingIterator) SeekToLast() {
+	for _, child := range m.children {
+		child.SeekToLast()
+	}
+	m.dir = reverse
+	m.rebuild()
+}
+
+func (m *mergingIterator) Seek(key []byte) {
+	for _, child := range m.children {
+		child.Seek(key)
+	}
+	m.dir = forward
+	m.rebuild()
+}
+
+func (m *mergingIterator) Next() {
+	if !m.Valid() {
+		return
+	}
+	if m.dir == reverse {
+		m.switchDirection(forward)
+	}
+	item, _ := m.heap.Pop()
+	child := m.children[item.child]
+	child.Next()
+	if child.Valid() {
+		m.heap.Push(mergeItem{entry: child.Entry(), child: item.child})
+	}
+}
+
+func (m *mergingIterator) Prev() {
+	if !m.Valid() {
+		return
+	}
+	if m.dir == forward {
+		m.switchDirection(reverse)
+	}
+	item, _ := m.heap.Pop()
+	child := m.children[item.child]
+	child.Prev()
+	if child.Valid() {
+		m.heap.Push(mergeItem{entry: child.Entry(), child: item.child})
+	}
+}
+
+// switchDirection repositions every child other than the current one so that it
+// sits on the nearest entry past the current item in the new direction. Only the
+// current child's position is meaningful across a direction change; the others
+// were parked on the far side of it.
+func (m *mergingIterator) switchDirection(dir direction) {
+	current, _ := m.heap.Peek()
+	for i, child := range m.children {
+		if i == current.child {
+			continue
+		}
+		// Land on the first entry strictly after the current item...
+		child.Seek(current.entry.Key)
+		for child.Valid() && m.compareItems(mergeItem{entry: child.Entry(), child: i}, current) <= 0 {
+			child.Next()
+		}
+		if dir == forward {
+			continue
+		}
+		// ...and for reverse, step back to the last entry strictly before it.
+		if child.Valid() {
+			child.Prev()
+		} else {
+			child.SeekToLast()
+		}
+	}
+	m.dir = dir
+	m.rebuild()
+}
+
+func (m *mergingIterator) Err() error {
+	for _, child := range m.children {
+		if err := child.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Iterator is an ordered, bidirectional cursor over a key range of the tree. It
+// merges the active MemTable, the immutable MemTable and every SSTable level,
+// yielding only the newest version of each key and skipping deleted keys. Keys
+// whose TTL had run out when the iterator was created, and versions under a
+// range tombstone, count as deleted.
+// Versions written after the iterator was created (or after its snapshot) are
+// invisible to it.
+//
+// Moving forward, the merged iterator sits on the entry that produced Key(), or
+// past the merge operands and base value folded into Value(). Moving backward,
+// it sits just before every entry for Key(), and Key()/Value() hold the value
+// built while walking back over them.
+//
+// An Iterator pins the SSTables and value-log files it reads; it must be closed
+// to release them.
+type Iterator struct {
+	iter      *mergingIterator
+	cmp       Comparator
+	ctx       context.Context // checked at every step; once done, Err returns its error
+	start     []byte          // inclusive lower bound; nil means unbounded
+	end       []byte          // exclusive upper bound; nil means unbounded
+	seq       uint64          // only versions with Seq <= seq are visible
+	now       int64           // entries expired by now (Unix nanoseconds) read as deleted
+	rangeDels []*entry.Entry  // range tombstones visible at seq
+	merge     MergeOperator
+	resolve   func(*entry.Entry) ([]byte, error) // reads values kept in the value log
+	dir       direction
+	key       []byte
+	value     []byte
+	valid     bool
+	err       error // ctx's error or a value-log read error, once iteration stopped because of it
+	release   func()
+}
+
+// Scan returns an Iterator over the keys in [start, end), positioned at the first
+// live key >= start. A nil start or end leaves that side of the range unbounded.
+func (t *LSMTree) Scan(start, end []byte) *Iterator {
+	return t.scan(context.Background(), t.defaultCF, nil, start, end)
+}
+
+// ScanContext is like Scan, but the iterator stops once ctx is done: it becomes
+// invalid and Err returns ctx.Err().
+func (t *LSMTree) ScanContext(ctx context.Context, start, end []byte) *Iterator {
+	return t.scan(ctx, t.defaultCF, nil, start, end)
+}
+
+// scan builds an Iterator over [start, end) of cf that sees the versions visible
+// to snapshot, or the current state of the tree if snapshot is nil.
+func (t *LSMTree) scan(ctx context.Context, cf *ColumnFamily, snapshot *Snapshot, start, end []byte) *Iterator {
+	t.mu.RLock()
+	seq := t.seq
+	if snapshot != nil {
+		seq = snapshot.seq
+	}
+	var children []internalIterator
+	if !cf.dropped {
+		children = append(children, cf.memTable.NewIterator())
+	}
+	if cf.immutable != nil {
+		children = append(children, cf.immutable.NewIterator())
+	}
+	var pinned []*sstableFile
+	for _, level := range cf.levels {
+		for _, sst := range level {
+			sst.ref()
+			pinned = append(pinned, sst)
+			children = append(children, sst.reader.NewIterator())
+		}
+	}
+	rangeDels := cf.rangeTombstones(seq)
+	unpin := t.vlog.Pin()
+	t.mu.RUnlock()
+
+	it := &Iterator{
+		iter:      newMergingIterator(children, cf.opts.Comparator),
+		cmp:       cf.opts.Comparator,
+		ctx:       ctx,
+		start:     start,
+		end:       end,
+		seq:       seq,
+		now:       time.Now().UnixNano(),
+		rangeDels: rangeDels,
+		merge:     cf.opts.MergeOperator,
+		resolve:   t.value,
+		release: func() {
+			for _, sst := range pinned {
+				sst.unref()
+			}
+			unpin()
+		},
+	}
+	it.Seek(start)
+	return it
+}
+
+// Seek positions the iterator at the first live key >= key, clamped to the scan range.
+func (it *Iterator) Seek(key []byte) {
+	if it.start != nil && it.cmp.Compare(key, it.start) < 0 {
+		key = it.start
+	}
+	if key == nil {
+		it.iter.SeekToFirst()
+	} else {
+		it.iter.Seek(key)
+	}
+	it.dir = forward
+	it.findNextUserEntry(nil)
+}
+
+// SeekToLast positions the iterator at the last live key in the scan range.
+func (it *Iterator) SeekToLast() {
+	if it.end != nil {
+		it.iter.Seek(it.end)
+		it.stepBack()
+	} else {
+		it.iter.SeekToLast()
+	}
+	it.dir = reverse
+	it.findPrevUserEntry()
+}
+
+// SeekForPrev positions the iterator at the last live key <= key, clamped to the scan range.
+func (it *Iterator) SeekForPrev(key []byte) {
+	if it.end != nil && it.cmp.Compare(key, it.end) >= 0 {
+		it.SeekToLast()
+		return
+	}
+	it.iter.Seek(key)
+	for it.iter.Valid() && bytes.Equal(it.iter.Entry().Key, key) {
+		it.iter.Next()
+	}
+	it.stepBack()
+	it.dir = reverse
+	it.findPrevUserEntry()
+}
+
+// Next advances to the next live key.
+func (it *Iterator) Next() {
+	if !it.valid {
+		return
+	}
+	if it.dir == reverse {
+		// The merged iterator sits just before the entries for it.key; step
+		// into them so the forward skip below passes over all of them.
+		it.dir = forward
+		if it.iter.Valid() {
+			it.iter.Next()
+		} else if it.start != nil {
+			it.iter.Seek(it.start)
+		} else {
+			it.iter.SeekToFirst()
+		}
+	}
+	it.findNextUserEntry(it.key)
+}
+
+// Prev moves back to the previous live key.
+func (it *Iterator) Prev() {
+	if !it.valid {
+		return
+	}
+	if it.dir == forward {
+		// Walk back until the merged iterator is before every entry for it.key.
+		// It may have run off the end while folding merge operands.
+		it.stepBack()
+		for it.iter.Valid() && it.cmp.Compare(it.iter.Entry().Key, it.key) >= 0 {
+			it.iter.Prev()
+		}
+		if !it.iter.Valid() {
+			it.valid = false
+			return
+		}
+		it.dir = reverse
+	}
+	it.findPrevUserEntry()
+}
+
+// Valid reports whether the iterator is positioned at a live key within range.
+func (it *Iterator) Valid() bool { return it.valid }
+
+// Key returns the current key. Only valid while Valid() is true.
+func (it *Iterator) Key() []byte { return it.key }
+
+// Value returns the current value. Only valid while Valid() is true.
+func (it *Iterator) Value() []byte { return it.value }
+
+// Err returns the first error encountered by any underlying source, or the
+// context's error if the iterator was stopped by it.
+func (it *Iterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.iter.Err()
+}
+
+// Close releases the SSTables and value-log files pinned by the iterator and
+// returns Err().
+func (it *Iterator) Close() error {
+	it.valid = false
+	if it.release != nil {
+		it.release()
+		it.release = nil
+	}
+	return it.Err()
+}
+
+// findNextUserEntry moves the merged iterator to the newest live version of the
+// next key, skipping entries for skip, shadowed versions and tombstones. If that
+// version is a merge operand, the versions below it are folded into the value.
+func (it *Iterator) findNextUserEntry(skip []byte) {
+	for ; it.iter.Valid() && !it.cancelled(); it.iter.Next() {
+		e := it.iter.Entry()
+		if it.end != nil && it.cmp.Compare(e.Key, it.end) >= 0 {
+			break
+		}
+		if e.Seq > it.seq {
+			continue
+		}
+		if skip != nil && bytes.Equal(e.Key, skip) {
+			continue
+		}
+		if it.deleted(e) {
+			skip = e.Key
+			continue
+		}
+		it.key, it.value = e.Key, it.valueOf(e)
+		if e.Operand {
+			it.mergeForward()
+		}
+		it.valid = it.err == nil
+		return
+	}
+	it.valid = false
+}
+
+// mergeForward folds the operand at the merged iterator's position, and every
+// older version of the same key down to a plain value or tombstone, into
+// it.value. The merged iterator is left on the last entry consumed.
+func (it *Iterator) mergeForward() {
+	operands := [][]byte{it.value}
+	var existing []byte
+	for it.iter.Next(); it.iter.Valid() && !it.cancelled(); it.iter.Next() {
+		e := it.iter.Entry()
+		if !bytes.Equal(e.Key, it.key) {
+			break
+		}
+		if e.Seq > it.seq {
+			continue
+		}
+		if it.deleted(e) {
+			break
+		}
+		if !e.Operand {
+			existing = it.valueOf(e)
+			break
+		}
+		operands = append(operands, e.Value)
+	}
+	it.value = mergeValue(it.merge, it.key, existing, operands)
+}
+
+// findPrevUserEntry walks the merged iterator backward to the previous live key.
+// Versions of a key arrive oldest first in reverse, so the state after the last
+// one seen before the key changes is the key's value: a plain value or
+// tombstone resets it, and merge operands pile on top. A key that ends up
+// deleted is skipped.
+func (it *Iterator) findPrevUserEntry() {
+	var key []byte
+	var base *entry.Entry // plain value the operands apply to
+	var operands [][]byte // oldest first
+	found := false
+	for ; it.iter.Valid(); it.iter.Prev() {
+		if it.cancelled() {
+			found = false
+			break
+		}
+		e := it.iter.Entry()
+		if it.start != nil && it.cmp.Compare(e.Key, it.start) < 0 {
+			break
+		}
+		if e.Seq > it.seq {
+			continue
+		}
+		if found && it.cmp.Compare(e.Key, key) < 0 {
+			break
+		}
+		if !bytes.Equal(e.Key, key) {
+			key, base, operands = e.Key, nil, nil
+		}
+		switch {
+		case it.deleted(e):
+			base, operands, found = nil, nil, false
+		case e.Operand:
+			operands = append(operands, e.Value)
+			found = true
+		default:
+			base, operands, found = e, nil, true
+		}
+	}
+	var value []byte
+	if found && base != nil {
+		value = it.valueOf(base)
+		found = it.err == nil
+	}
+	if found && len(operands) > 0 {
+		slices.Reverse(operands)
+		value = mergeValue(it.merge, key, value, operands)
+	}
+	it.key, it.value, it.valid = key, value, found
+}
+
+// valueOf returns the value of e, recording the error if it cannot be read from
+// the value log.
+func (it *Iterator) valueOf(e *entry.Entry) []byte {
+	value, err := it.resolve(e)
+	if err != nil && it.err == nil {
+		it.err = err
+	}
+	return value
+}
+
+// cancelled reports whether the iterator's context is done, recording its error.
+func (it *Iterator) cancelled() bool {
+	if it.err == nil {
+		it.err = it.ctx.Err()
+	}
+	return it.err != nil
+}
+
+// deleted reports whether e reads as deleted: a tombstone, expired, or under a
+// newer range tombstone.
+func (it *Iterator) deleted(e *entry.Entry) bool {
+	return e.Tombstone || e.Expired(it.now) || coveringSeq(it.cmp, it.rangeDels, e.Key) > e.Seq
+}
+
+// stepBack moves the merged iterator from the first entry >= some key to the last
+// entry before it, wrapping to the very last entry when it is exhausted.
+func (it *Iterator) stepBack() {
+	if it.iter.Valid() {
+		it.iter.Prev()
+	} else {
+		it.iter.SeekToLast()
+	}
+}
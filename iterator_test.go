@@ -0,0 +1,262 @@
+package lmstree
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+)
+
+// collect drains it and returns the visited keys and values.
+func collect(t *testing.T, it *Iterator) ([]string, []string) {
+	t.Helper()
+	var keys, values []string
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+		values = append(values, string(it.Value()))
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return keys, values
+}
+
+func TestLSMTree_ScanMergesAllSources(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.MemTableSize = 1 // every write rotates, so data spreads across SSTables
+	opts.L0CompactThresh = 3
+	opts.BlockSize = 64
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	for i := 0; i < 20; i++ {
+		tree.Put([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("v1-%02d", i)))
+	}
+	for i := 0; i < 20; i += 2 {
+		tree.Put([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("v2-%02d", i)))
+	}
+	for i := 0; i < 20; i += 5 {
+		tree.Delete([]byte(fmt.Sprintf("key%02d", i)))
+	}
+
+	keys, values := collect(t, tree.Scan([]byte("key03"), []byte("key12")))
+
+	var wantKeys, wantValues []string
+	for i := 3; i < 12; i++ {
+		if i%5 == 0 {
+			continue
+		}
+		wantKeys = append(wantKeys, fmt.Sprintf("key%02d", i))
+		if i%2 == 0 {
+			wantValues = append(wantValues, fmt.Sprintf("v2-%02d", i))
+		} else {
+			wantValues = append(wantValues, fmt.Sprintf("v1-%02d", i))
+		}
+	}
+	if fmt.Sprint(keys) != fmt.Sprint(wantKeys) {
+		t.Fatalf("keys = %v, want %v", keys, wantKeys)
+	}
+	if fmt.Sprint(values) != fmt.Sprint(wantValues) {
+		t.Fatalf("values = %v, want %v", values, wantValues)
+	}
+}
+
+func TestLSMTree_ScanUnboundedAndSeek(t *testing.T) {
+	tree, err := Open(DefaultOptions(tempDir(t)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	for _, k := range []string{"d", "a", "c", "b"} {
+		tree.Put([]byte(k), []byte("v"+k))
+	}
+
+	keys, _ := collect(t, tree.Scan(nil, nil))
+	if fmt.Sprint(keys) != "[a b c d]" {
+		t.Fatalf("keys = %v, want [a b c d]", keys)
+	}
+
+	it := tree.Scan([]byte("b"), nil)
+	defer it.Close()
+	it.Seek([]byte("c"))
+	if !it.Valid() || !bytes.Equal(it.Key(), []byte("c")) {
+		t.Fatalf("Seek(c): got (%q, %v)", it.Key(), it.Valid())
+	}
+	// Seeking below the range start is clamped to it.
+	it.Seek([]byte("a"))
+	if !it.Valid() || !bytes.Equal(it.Key(), []byte("b")) {
+		t.Fatalf("Seek(a): got (%q, %v), want b", it.Key(), it.Valid())
+	}
+}
+
+func TestLSMTree_ScanSurvivesCompaction(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.MemTableSize = 1
+	opts.L0CompactThresh = 2
+	opts.BlockSize = 64
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	for i := 0; i < 6; i++ {
+		tree.Put([]byte(fmt.Sprintf("key%02d", i)), []byte("v"))
+	}
+
+	it := tree.Scan(nil, nil)
+	// Further writes compact away the SSTables the iterator has pinned.
+	for i := 6; i < 12; i++ {
+		tree.Put([]byte(fmt.Sprintf("key%02d", i)), []byte("v"))
+	}
+
+	keys, _ := collect(t, it)
+	if len(keys) < 6 {
+		t.Fatalf("got %d keys, want at least 6: %v", len(keys), keys)
+	}
+	for i := 0; i < 6; i++ {
+		if keys[i] != fmt.Sprintf("key%02d", i) {
+			t.Fatalf("keys[%d] = %s, want key%02d", i, keys[i], i)
+		}
+	}
+}
+
+func TestLSMTree_ScanReverse(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.MemTableSize = 1
+	opts.L0CompactThresh = 3
+	opts.BlockSize = 64
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	for i := 0; i < 20; i++ {
+		tree.Put([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("v1-%02d", i)))
+	}
+	for i := 0; i < 20; i += 3 {
+		tree.Put([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("v2-%02d", i)))
+	}
+	for i := 0; i < 20; i += 4 {
+		tree.Delete([]byte(fmt.Sprintf("key%02d", i)))
+	}
+
+	var want []string
+	for i := 14; i >= 5; i-- {
+		if i%4 != 0 {
+			want = append(want, fmt.Sprintf("key%02d", i))
+		}
+	}
+
+	it := tree.Scan([]byte("key05"), []byte("key15"))
+	defer it.Close()
+
+	var got []string
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		got = append(got, string(it.Key()))
+		i := 0
+		fmt.Sscanf(string(it.Key()), "key%02d", &i)
+		wantVal := fmt.Sprintf("v1-%02d", i)
+		if i%3 == 0 {
+			wantVal = fmt.Sprintf("v2-%02d", i)
+		}
+		if string(it.Value()) != wantVal {
+			t.Fatalf("Value(%s) = %s, want %s", it.Key(), it.Value(), wantVal)
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("reverse keys = %v, want %v", got, want)
+	}
+
+	it.SeekForPrev([]byte("key08"))
+	if !it.Valid() || string(it.Key()) != "key07" {
+		t.Fatalf("SeekForPrev(key08) = (%s, %v), want key07 (key08 is deleted)", it.Key(), it.Valid())
+	}
+	it.SeekForPrev([]byte("key09"))
+	if !it.Valid() || string(it.Key()) != "key09" {
+		t.Fatalf("SeekForPrev(key09) = (%s, %v), want key09", it.Key(), it.Valid())
+	}
+}
+
+func TestLSMTree_ScanChangeDirection(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.MemTableSize = 1
+	opts.L0CompactThresh = 100 // keep every write in its own L0 file
+	opts.BlockSize = 64
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		tree.Put([]byte(k), []byte("old"))
+	}
+	tree.Put([]byte("c"), []byte("new"))
+	tree.Delete([]byte("d"))
+
+	it := tree.Scan(nil, nil)
+	defer it.Close()
+
+	steps := []struct {
+		move  func()
+		key   string
+		value string
+	}{
+		{func() {}, "a", "old"},
+		{it.Next, "b", "old"},
+		{it.Next, "c", "new"},
+		{it.Prev, "b", "old"},
+		{it.Next, "c", "new"},
+		{it.Next, "e", "old"},
+		{it.Prev, "c", "new"},
+		{it.Prev, "b", "old"},
+		{it.Prev, "a", "old"},
+	}
+	for i, s := range steps {
+		s.move()
+		if !it.Valid() || string(it.Key()) != s.key || string(it.Value()) != s.value {
+			t.Fatalf("step %d: got (%s=%s, %v), want %s=%s", i, it.Key(), it.Value(), it.Valid(), s.key, s.value)
+		}
+	}
+	it.Prev()
+	if it.Valid() {
+		t.Fatalf("Prev before first key should be invalid, got %s", it.Key())
+	}
+}
+
+func TestLSMTree_ScanContextCancel(t *testing.T) {
+	tree, err := Open(DefaultOptions(tempDir(t)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+	for _, k := range []string{"a", "b", "c"} {
+		tree.Put([]byte(k), []byte("v"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it := tree.ScanContext(ctx, nil, nil)
+	if !it.Valid() || string(it.Key()) != "a" {
+		t.Fatalf("first key = %q, want a", it.Key())
+	}
+	cancel()
+	if it.Next(); it.Valid() {
+		t.Fatalf("Next after cancel: unexpected key %q", it.Key())
+	}
+	if it.SeekToLast(); it.Valid() {
+		t.Fatalf("SeekToLast after cancel: unexpected key %q", it.Key())
+	}
+	if err := it.Close(); err != context.Canceled {
+		t.Fatalf("Close = %v, want context.Canceled", err)
+	}
+}
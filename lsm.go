@@ -1,12 +1,27 @@
 package lmstree
 
 import (
+	"context"
+	"errors"
 	"os"
+	"time"
 
-	"github.com/maksymus/lmstree/internal/memtable"
+	"github.com/maksymus/lmstree/entry"
+	"github.com/maksymus/lmstree/internal/lock"
+	"github.com/maksymus/lmstree/internal/vlog"
 	walPkg "github.com/maksymus/lmstree/internal/wal"
 )
 
+var (
+	// ErrInvalidTTL is returned by PutWithTTL for a ttl that is not positive.
+	ErrInvalidTTL = errors.New("lmstree: ttl must be positive")
+	// ErrInvalidRange is returned by DeleteRange for an empty start or a range
+	// that does not satisfy start < end.
+	ErrInvalidRange = errors.New("lmstree: invalid key range")
+	// ErrClosed is returned by Subscribe on a closed tree.
+	ErrClosed = errors.New("lmstree: tree is closed")
+)
+
 // Open creates or opens the LSMTree rooted at opts.Dir.
 // Any WAL files left from a previous crash are replayed into the MemTable.
 func Open(opts Options) (*LSMTree, error) {
@@ -22,6 +37,15 @@ func Open(opts Options) (*LSMTree, error) {
 	if opts.MaxLevels == 0 {
 		opts.MaxLevels = defaultMaxLevels
 	}
+	if opts.LockTimeout == 0 {
+		opts.LockTimeout = defaultLockTimeout
+	}
+	if opts.ValueLogFileSize == 0 {
+		opts.ValueLogFileSize = defaultValueLogFileSize
+	}
+	if opts.WALSyncInterval == 0 {
+		opts.WALSyncInterval = defaultWALSyncInterval
+	}
 
 	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
 		return nil, err
@@ -32,128 +56,231 @@ func Open(opts Options) (*LSMTree, error) {
 		return nil, err
 	}
 
-	mem := memtable.NewMemTable(opts.Dir, defaultSkipListLevel, w)
+	values, err := vlog.Open(opts.Dir, opts.ValueLogFileSize)
+	if err != nil {
+		return nil, err
+	}
 
 	t := &LSMTree{
-		opts:     opts,
-		memTable: mem,
-		wal:      w,
-		levels:   make([][]*sstableFile, opts.MaxLevels),
-		flushCh:  make(chan flushJob, 1),
-		done:     make(chan struct{}),
+		opts:         opts,
+		families:     make(map[string]*ColumnFamily),
+		familyIDs:    make(map[uint32]*ColumnFamily),
+		nextFamilyID: 1,
+		wal:          w,
+		flushCh:      make(chan flushJob, 1),
+		done:         make(chan struct{}),
+		snapshots:    make(map[*Snapshot]struct{}),
+		locks:        lock.NewManager(),
+		vlog:         values,
+		indexes:      make(map[string]*index),
+		changed:      make(chan struct{}),
 	}
+	t.defaultCF = t.newColumnFamily(0, DefaultColumnFamily, opts.Dir, ColumnFamilyOptions{})
+	t.families[DefaultColumnFamily] = t.defaultCF
+	t.familyIDs[0] = t.defaultCF
 
-	if err := mem.Recover(); err != nil {
+	if err := t.loadFamilies(); err != nil {
 		return nil, err
 	}
 
-	if err := t.loadSSTables(); err != nil {
+	if err := t.recoverWAL(); err != nil {
 		return nil, err
 	}
 
+	// Resume sequence numbering after the newest write found on disk.
+	for _, cf := range t.families {
+		if err := cf.loadSSTables(); err != nil {
+			return nil, err
+		}
+		t.seq = max(t.seq, cf.memTable.MaxSeq())
+		for _, level := range cf.levels {
+			for _, sst := range level {
+				t.seq = max(t.seq, sst.reader.MaxSeq())
+			}
+		}
+	}
+	t.syncedSeq = t.seq // recoverWAL synced what it replayed
+
 	t.wg.Add(1)
 	go t.flushWorker()
+	if opts.WALSyncMode == WALSyncInterval {
+		t.wg.Add(1)
+		go t.syncWorker()
+	}
+
+	if err := t.openIndexes(); err != nil {
+		t.Close()
+		return nil, err
+	}
 
 	return t, nil
 }
 
 // Put stores key → value. If the MemTable exceeds MemTableSize and no flush is
-// already in progress, it is rotated out to the background flush worker.
+// already in progress, it is rotated out to the background flush worker. While
+// a flush is in progress and the MemTable has grown to twice MemTableSize, Put
+// stalls until the flush completes.
 func (t *LSMTree) Put(key, value []byte) error {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+	return t.write(&entry.Entry{Key: key, Value: value})
+}
 
-	if err := t.memTable.Set(key, value); err != nil {
-		return err
-	}
-	if t.memTable.Size() >= t.opts.MemTableSize && t.immutable == nil {
-		t.rotateMemTable()
+// PutContext is like Put but returns ctx.Err(), without writing, if ctx is done
+// before the write is applied — in particular while the write is stalled.
+func (t *LSMTree) PutContext(ctx context.Context, key, value []byte) error {
+	return t.writeContext(ctx, &entry.Entry{Key: key, Value: value})
+}
+
+// PutWithTTL stores key → value until ttl has elapsed. From then on Get and
+// iterators treat the key as deleted, and compaction drops it. ttl must be positive.
+func (t *LSMTree) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
 	}
-	return nil
+	return t.write(&entry.Entry{Key: key, Value: value, ExpiresAt: time.Now().Add(ttl).UnixNano()})
 }
 
 // Delete marks key as deleted. The tombstone shadows any older value in SSTables
 // until the next compaction removes both.
 func (t *LSMTree) Delete(key []byte) error {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+	return t.write(&entry.Entry{Key: key, Value: []byte{}, Tombstone: true})
+}
 
-	if err := t.memTable.Delete(key); err != nil {
-		return err
-	}
-	if t.memTable.Size() >= t.opts.MemTableSize && t.immutable == nil {
-		t.rotateMemTable()
+// DeleteContext is like Delete but gives up with ctx.Err() like PutContext.
+func (t *LSMTree) DeleteContext(ctx context.Context, key []byte) error {
+	return t.writeContext(ctx, &entry.Entry{Key: key, Value: []byte{}, Tombstone: true})
+}
+
+// DeleteRange deletes every key in [start, end) with a single range tombstone,
+// however many keys the range holds. Compaction drops the data it covers, and
+// whole SSTables whose keys all lie in the range.
+func (t *LSMTree) DeleteRange(start, end []byte) error {
+	if !t.defaultCF.validRange(start, end) {
+		return ErrInvalidRange
 	}
-	return nil
+	return t.write(&entry.Entry{Key: start, Value: end, RangeDelete: true})
 }
 
-// Get returns the value for key, or nil and false if the key does not exist or
-// has been deleted.
-func (t *LSMTree) Get(key []byte) ([]byte, bool) {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
+// validRange reports whether [start, end) is a range of cf DeleteRange accepts.
+func (cf *ColumnFamily) validRange(start, end []byte) bool {
+	return len(start) > 0 && cf.opts.Comparator.Compare(start, end) < 0
+}
 
-	// Active MemTable has the freshest data.
-	if e, ok := t.memTable.GetEntry(key); ok {
-		if e.Tombstone {
-			return nil, false
-		}
-		return e.Value, true
+// Write applies every operation in batch atomically. An empty batch is a no-op.
+func (t *LSMTree) Write(batch *WriteBatch) error {
+	if batch.Count() == 0 {
+		return nil
 	}
+	return t.write(batch.entries...)
+}
 
-	// Immutable MemTable is older than active but newer than any SSTable.
-	if t.immutable != nil {
-		if e, ok := t.immutable.GetEntry(key); ok {
-			if e.Tombstone {
-				return nil, false
-			}
-			return e.Value, true
-		}
+// WriteContext is like Write but gives up with ctx.Err() like PutContext. The
+// batch is then applied entirely or not at all.
+func (t *LSMTree) WriteContext(ctx context.Context, batch *WriteBatch) error {
+	if batch.Count() == 0 {
+		return ctx.Err()
 	}
+	return t.writeContext(ctx, batch.entries...)
+}
 
-	// Search SSTables level by level (L0 first = newest data).
-	for _, level := range t.levels {
-		for _, sst := range level {
-			e, ok := sst.reader.Search(key)
-			if !ok {
-				continue
-			}
-			if e.Tombstone {
-				return nil, false
-			}
-			return e.Value, true
-		}
+// PutIfAbsent stores key → value only if key does not currently exist. It
+// reports whether the write happened.
+func (t *LSMTree) PutIfAbsent(key, value []byte) (bool, error) {
+	return t.writeIf(key, nil, &entry.Entry{Key: key, Value: value})
+}
+
+// CompareAndSwap stores key → value only if the current value of key equals
+// expected; a nil expected matches only a missing key. It reports whether the
+// swap happened.
+func (t *LSMTree) CompareAndSwap(key, expected, value []byte) (bool, error) {
+	return t.writeIf(key, expected, &entry.Entry{Key: key, Value: value})
+}
+
+// DeleteIf deletes key only if its current value equals expected. It reports
+// whether the delete happened.
+func (t *LSMTree) DeleteIf(key, expected []byte) (bool, error) {
+	if expected == nil {
+		return false, nil
 	}
+	return t.writeIf(key, expected, &entry.Entry{Key: key, Value: []byte{}, Tombstone: true})
+}
+
+// Get returns the value for key, or nil and false if the key does not exist or
+// has been deleted.
+func (t *LSMTree) Get(key []byte) ([]byte, bool) {
+	value, ok, _ := t.GetContext(context.Background(), key)
+	return value, ok
+}
 
-	return nil, false
+// GetContext is like Get but returns ctx.Err() if ctx is done before the lookup
+// completes; ctx is checked before each SSTable is probed.
+func (t *LSMTree) GetContext(ctx context.Context, key []byte) ([]byte, bool, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.getAt(ctx, t.defaultCF, key, t.seq)
 }
 
 // Close stops the background flush worker, flushes remaining data to disk,
 // closes all SSTable readers, and releases the WAL.
 func (t *LSMTree) Close() error {
+	return t.close(context.Background())
+}
+
+// CloseContext is like Close but returns ctx.Err() as soon as ctx is done. The
+// tree then finishes closing in the background without flushing: the MemTables
+// stay in the WAL, and the next Open recovers them.
+func (t *LSMTree) CloseContext(ctx context.Context) error {
+	result := make(chan error, 1)
+	go func() { result <- t.close(ctx) }()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close implements Close. The MemTables are flushed only if ctx is not done once
+// the flush worker has stopped.
+func (t *LSMTree) close(ctx context.Context) error {
+	// Under t.mu, so that subscribe sees either the tree open or done closed
+	// before it adds to t.wg.
+	t.mu.Lock()
 	close(t.done)
+	t.mu.Unlock()
 	t.wg.Wait()
+	flush := ctx.Err() == nil
 
-	// If the worker exited before picking up a pending job, process it now.
+	// If the worker exited before picking up a pending job, process it now, or
+	// leave its WAL to be replayed.
 	select {
 	case job := <-t.flushCh:
-		t.processFlush(job)
+		if flush {
+			t.processFlush(job)
+		} else {
+			job.oldWAL.Close()
+		}
 	default:
 	}
 
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	if t.memTable.Size() > 0 {
+	unflushed := false
+	for _, cf := range t.families {
+		unflushed = unflushed || cf.memTable.Size() > 0
+	}
+	if flush && unflushed {
 		if err := t.flush(); err != nil {
 			return err
 		}
 	}
 
-	for _, level := range t.levels {
-		for _, sst := range level {
-			sst.reader.Close()
+	for _, cf := range t.families {
+		for _, level := range cf.levels {
+			for _, sst := range level {
+				sst.unref()
+			}
 		}
 	}
-	return t.wal.Close()
+	return errors.Join(t.vlog.Sync(), t.wal.Close(), t.vlog.Close())
 }
@@ -0,0 +1,52 @@
+package lmstree
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/maksymus/lmstree/entry"
+	"github.com/maksymus/lmstree/internal/sstable"
+)
+
+// ErrNoMergeOperator is returned by Merge when Options.MergeOperator is not set.
+var ErrNoMergeOperator = errors.New("lmstree: no merge operator configured")
+
+// MergeOperator combines merge operands written with LSMTree.Merge into a value.
+// Operands are stored as they are and combined lazily: on reads, in iterators,
+// and when flush or compaction finds the value they apply to.
+//
+// Merge must be deterministic, and may be called more than once for the same
+// operands.
+type MergeOperator interface {
+	// Merge returns the value of key after applying operands, oldest first, to
+	// existing. existing is nil if the key has no value (never written or deleted).
+	Merge(key, existing []byte, operands [][]byte) []byte
+}
+
+// Merge records operand against key without reading the current value. The
+// tree's MergeOperator combines it with the older versions when key is read.
+func (t *LSMTree) Merge(key, operand []byte) error {
+	if t.defaultCF.opts.MergeOperator == nil {
+		return ErrNoMergeOperator
+	}
+	return t.write(&entry.Entry{Key: key, Value: operand, Operand: true})
+}
+
+// mergeValue applies operands, collected newest first, to existing. Without a
+// merge operator the newest operand reads as a plain value.
+func mergeValue(op MergeOperator, key, existing []byte, operands [][]byte) []byte {
+	if op == nil {
+		return operands[0]
+	}
+	ordered := slices.Clone(operands)
+	slices.Reverse(ordered)
+	return op.Merge(key, existing, ordered)
+}
+
+// mergeFunc adapts op for sstable.MergeWith; nil keeps operands unmerged.
+func mergeFunc(op MergeOperator) sstable.MergeFunc {
+	if op == nil {
+		return nil
+	}
+	return op.Merge
+}
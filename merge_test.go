@@ -0,0 +1,153 @@
+package lmstree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// counterOperator treats values and operands as big-endian uint64s and adds them.
+type counterOperator struct{}
+
+func (counterOperator) Merge(key, existing []byte, operands [][]byte) []byte {
+	var sum uint64
+	if existing != nil {
+		sum = binary.BigEndian.Uint64(existing)
+	}
+	for _, op := range operands {
+		sum += binary.BigEndian.Uint64(op)
+	}
+	return binary.BigEndian.AppendUint64(nil, sum)
+}
+
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+func counterValue(t *testing.T, tree *LSMTree, key string) uint64 {
+	t.Helper()
+	v, ok := tree.Get([]byte(key))
+	if !ok {
+		t.Fatalf("Get(%s): not found", key)
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+func TestMerge_RequiresOperator(t *testing.T) {
+	tree, err := Open(DefaultOptions(tempDir(t)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	if err := tree.Merge([]byte("k"), u64(1)); !errors.Is(err, ErrNoMergeOperator) {
+		t.Fatalf("Merge without operator = %v, want ErrNoMergeOperator", err)
+	}
+}
+
+func TestMerge_Counter(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.MergeOperator = counterOperator{}
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	for i := 0; i < 5; i++ {
+		tree.Merge([]byte("hits"), u64(1))
+	}
+	if got := counterValue(t, tree, "hits"); got != 5 {
+		t.Fatalf("hits = %d, want 5", got)
+	}
+
+	tree.Put([]byte("base"), u64(100))
+	snap := tree.NewSnapshot()
+	defer snap.Release()
+	tree.Merge([]byte("base"), u64(10))
+	tree.Merge([]byte("base"), u64(1))
+	if got := counterValue(t, tree, "base"); got != 111 {
+		t.Fatalf("base = %d, want 111", got)
+	}
+	if v, _ := tree.GetAt(snap, []byte("base")); binary.BigEndian.Uint64(v) != 100 {
+		t.Fatalf("base at snapshot = %d, want 100", binary.BigEndian.Uint64(v))
+	}
+
+	// A delete resets the counter; later operands start from nothing.
+	tree.Delete([]byte("hits"))
+	tree.Merge([]byte("hits"), u64(7))
+	if got := counterValue(t, tree, "hits"); got != 7 {
+		t.Fatalf("hits after delete = %d, want 7", got)
+	}
+
+	ok, err := tree.CompareAndSwap([]byte("hits"), u64(7), u64(0))
+	if err != nil || !ok {
+		t.Fatalf("CompareAndSwap on merged value = (%v, %v), want true", ok, err)
+	}
+
+	// Folding the last key's operands runs the merged iterator off the end;
+	// stepping back from there must still land on the previous key.
+	tree.Merge([]byte("last"), u64(1))
+	tree.Merge([]byte("last"), u64(2))
+	it := tree.Scan([]byte("hits"), nil)
+	defer it.Close()
+	it.Next()
+	if !it.Valid() || string(it.Key()) != "last" || binary.BigEndian.Uint64(it.Value()) != 3 {
+		t.Fatalf("Next: valid=%v key=%q, want last=3", it.Valid(), it.Key())
+	}
+	it.Prev()
+	if !it.Valid() || string(it.Key()) != "hits" {
+		t.Fatalf("Prev from last key: valid=%v key=%q, want hits", it.Valid(), it.Key())
+	}
+}
+
+func TestMerge_AcrossFlushAndCompaction(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.MergeOperator = counterOperator{}
+	opts.MemTableSize = 512
+	opts.L0CompactThresh = 2
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	const keys, rounds = 10, 40
+	for r := 0; r < rounds; r++ {
+		for k := 0; k < keys; k++ {
+			if err := tree.Merge([]byte(fmt.Sprintf("key%02d", k)), u64(uint64(k))); err != nil {
+				t.Fatalf("Merge: %v", err)
+			}
+		}
+	}
+	tree.Close()
+
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer tree.Close()
+
+	for k := 0; k < keys; k++ {
+		if got, want := counterValue(t, tree, fmt.Sprintf("key%02d", k)), uint64(k*rounds); got != want {
+			t.Errorf("key%02d = %d, want %d", k, got, want)
+		}
+	}
+
+	it := tree.Scan(nil, nil)
+	defer it.Close()
+	var forward []uint64
+	for ; it.Valid(); it.Next() {
+		forward = append(forward, binary.BigEndian.Uint64(it.Value()))
+	}
+	var backward []uint64
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		backward = append([]uint64{binary.BigEndian.Uint64(it.Value())}, backward...)
+	}
+	if len(forward) != keys || fmt.Sprint(forward) != fmt.Sprint(backward) {
+		t.Fatalf("forward scan %v, backward scan %v", forward, backward)
+	}
+	for k, v := range forward {
+		if v != uint64(k*rounds) {
+			t.Errorf("scan value %d = %d, want %d", k, v, k*rounds)
+		}
+	}
+}
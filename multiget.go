@@ -0,0 +1,81 @@
+package lmstree
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/maksymus/lmstree/entry"
+)
+
+// MultiGet looks up several keys at once, as of a single point in time. The
+// results are aligned with keys: values[i] and found[i] are what Get(keys[i])
+// would return.
+//
+// The keys are sorted and each MemTable is searched once for all of them. Keys
+// still unresolved are then passed down the SSTable levels together, so a data
+// block holding several of them is read and decoded only once.
+func (t *LSMTree) MultiGet(keys [][]byte) (values [][]byte, found []bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	cf := t.defaultCF
+	seq := t.seq
+	values = make([][]byte, len(keys))
+	found = make([]bool, len(keys))
+
+	// pending holds the indexes of unresolved keys, in key order.
+	pending := make([]int, len(keys))
+	for i := range pending {
+		pending[i] = i
+	}
+	slices.SortFunc(pending, func(a, b int) int { return cf.opts.Comparator.Compare(keys[a], keys[b]) })
+
+	newest := make([]*entry.Entry, len(keys))
+	search := func(lookup func([][]byte) []*entry.Entry) {
+		batch := make([][]byte, len(pending))
+		for j, i := range pending {
+			batch[j] = keys[i]
+		}
+		results := lookup(batch)
+		remaining := pending[:0]
+		for j, i := range pending {
+			if results[j] != nil {
+				newest[i] = results[j]
+			} else {
+				remaining = append(remaining, i)
+			}
+		}
+		pending = remaining
+	}
+
+	search(func(batch [][]byte) []*entry.Entry { return cf.memTable.GetEntriesAt(batch, seq) })
+	if cf.immutable != nil {
+		search(func(batch [][]byte) []*entry.Entry { return cf.immutable.GetEntriesAt(batch, seq) })
+	}
+	for _, level := range cf.levels {
+		for _, sst := range level {
+			if len(pending) == 0 {
+				break
+			}
+			search(func(batch [][]byte) []*entry.Entry { return sst.reader.MultiSearchAt(batch, seq) })
+		}
+	}
+
+	now := time.Now().UnixNano()
+	tombstones := cf.rangeTombstones(seq)
+	for i, e := range newest {
+		if e == nil || e.Seq < coveringSeq(cf.opts.Comparator, tombstones, keys[i]) {
+			continue
+		}
+		switch {
+		case e.Operand:
+			// Rare: the older versions below it are needed too.
+			values[i], found[i], _ = t.getAt(context.Background(), cf, keys[i], seq)
+		case !e.Tombstone && !e.Expired(now):
+			value, err := t.value(e)
+			values[i], found[i] = value, err == nil
+		}
+	}
+	return values, found
+}
@@ -0,0 +1,52 @@
+package lmstree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestLSMTree_MultiGet(t *testing.T) {
+	dir := tempDir(t)
+	opts := DefaultOptions(dir)
+	opts.BlockSize = 256
+	opts.MergeOperator = counterOperator{}
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		tree.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("old%d", i)))
+	}
+	tree.Put([]byte("counter"), u64(1))
+	tree.Close() // everything above lives in an SSTable of many blocks
+
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer tree.Close()
+	tree.Put([]byte("key010"), []byte("new"))
+	tree.Delete([]byte("key020"))
+	tree.DeleteRange([]byte("key030"), []byte("key040"))
+	tree.Merge([]byte("counter"), u64(2))
+
+	keys := [][]byte{
+		[]byte("key099"), []byte("missing"), []byte("key010"), []byte("key020"),
+		[]byte("key035"), []byte("counter"), []byte("key000"), []byte("key050"), []byte("key010"),
+	}
+	values, found := tree.MultiGet(keys)
+	if len(values) != len(keys) || len(found) != len(keys) {
+		t.Fatalf("MultiGet returned %d values and %d flags for %d keys", len(values), len(found), len(keys))
+	}
+	for i, key := range keys {
+		want, ok := tree.Get(key)
+		if found[i] != ok || !bytes.Equal(values[i], want) {
+			t.Errorf("MultiGet[%d] %s = (%q, %v), Get = (%q, %v)", i, key, values[i], found[i], want, ok)
+		}
+	}
+	if string(values[2]) != "new" || found[3] || found[4] || !found[5] {
+		t.Errorf("MultiGet = %q %v, want the MemTable's view to win", values, found)
+	}
+}
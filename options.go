@@ -1,11 +1,16 @@
 package lmstree
 
+import "time"
+
 const (
-	defaultMemTableSize    int64 = 64 * 1024 * 1024 // 64 MB
-	defaultBlockSize       int   = 4096
-	defaultL0CompactThresh int   = 4
-	defaultMaxLevels       int   = 7
-	defaultSkipListLevel   int   = 16
+	defaultMemTableSize     int64 = 64 * 1024 * 1024 // 64 MB
+	defaultBlockSize        int   = 4096
+	defaultL0CompactThresh  int   = 4
+	defaultMaxLevels        int   = 7
+	defaultSkipListLevel    int   = 16
+	defaultLockTimeout            = time.Second
+	defaultValueLogFileSize int64 = 256 * 1024 * 1024 // 256 MB
+	defaultWALSyncInterval        = 100 * time.Millisecond
 )
 
 // Options configures the LSMTree.
@@ -15,15 +20,53 @@ type Options struct {
 	BlockSize       int    // target SSTable data-block size in bytes
 	L0CompactThresh int    // number of L0 SSTables that triggers a compaction to L1
 	MaxLevels       int    // maximum number of levels
+
+	// LockTimeout bounds how long a pessimistic transaction waits for a key lock.
+	LockTimeout time.Duration
+	// MergeOperator combines operands written with Merge. It is required to call
+	// Merge, and must stay the same across reopens of a tree holding operands.
+	MergeOperator MergeOperator
+	// Comparator orders keys. Nil means BytewiseComparator. It must stay the same
+	// across reopens: Open fails on SSTables written with another comparator.
+	Comparator Comparator
+	// ColumnFamilies holds the options of existing column families, by name, for
+	// when they are reopened. Families not listed inherit the options above.
+	ColumnFamilies map[string]ColumnFamilyOptions
+	// ValueThreshold enables key-value separation: values of at least this many
+	// bytes are stored in the value log and only a pointer to them in the LSM, so
+	// compaction does not rewrite them. 0 keeps every value in the LSM.
+	ValueThreshold int
+	// ValueLogFileSize is the size in bytes at which a new value-log file is
+	// started. ValueLogGC reclaims space a whole file at a time.
+	ValueLogFileSize int64
+	// WALRetentionSize is the number of bytes of flushed WAL segments kept, in a
+	// "wal-archive" subdirectory, for Subscribe to read. 0 deletes a segment as
+	// soon as its data is flushed.
+	WALRetentionSize int64
+	// WALSyncMode selects when the WAL is forced to stable storage, and so which
+	// acknowledged writes a power failure can lose. The default, WALSyncNone,
+	// leaves it to the operating system.
+	WALSyncMode WALSyncMode
+	// WALSyncInterval is the period of the background sync of WALSyncInterval
+	// mode.
+	WALSyncInterval time.Duration
+	// Indexes defines secondary indexes over the default column family, by name.
+	// Open builds an index not yet in the tree from the existing data, and drops
+	// any index of the tree left out. An index's function must stay the same
+	// across reopens.
+	Indexes map[string]IndexFunc
 }
 
 // DefaultOptions returns sensible defaults for the given directory.
 func DefaultOptions(dir string) Options {
 	return Options{
-		Dir:             dir,
-		MemTableSize:    defaultMemTableSize,
-		BlockSize:       defaultBlockSize,
-		L0CompactThresh: defaultL0CompactThresh,
-		MaxLevels:       defaultMaxLevels,
+		Dir:              dir,
+		MemTableSize:     defaultMemTableSize,
+		BlockSize:        defaultBlockSize,
+		L0CompactThresh:  defaultL0CompactThresh,
+		MaxLevels:        defaultMaxLevels,
+		LockTimeout:      defaultLockTimeout,
+		ValueLogFileSize: defaultValueLogFileSize,
+		WALSyncInterval:  defaultWALSyncInterval,
 	}
 }
@@ -0,0 +1,130 @@
+package lmstree
+
+import (
+	"context"
+
+	"github.com/maksymus/lmstree/entry"
+)
+
+// maxGroupSize bounds the bytes of keys and values one leader commits for its
+// followers, so a large group does not delay the leader's own write for long.
+const maxGroupSize = 1 << 20 // 1 MB
+
+// pendingWrite is a write waiting in the queue of LSMTree.writeContext.
+type pendingWrite struct {
+	ctx     context.Context
+	entries []*entry.Entry
+	taken   bool          // part of a leader's group; may no longer leave the queue
+	lead    bool          // at the head of the queue and due to commit a group
+	err     error         // result of the write, once done
+	ready   chan struct{} // closed when the write is done or it is due to lead
+}
+
+// size returns the bytes of keys and values in w.
+func (w *pendingWrite) size() int {
+	n := 0
+	for _, e := range w.entries {
+		n += len(e.Key) + len(e.Value)
+	}
+	return n
+}
+
+// writeContext queues entries behind the writes already waiting. The write at
+// the head of the queue leads: it takes t.mu, stalls if needed, and commits its
+// own entries and those of the writes queued behind it, up to maxGroupSize, as
+// one WAL record with one sync. The others wait for the leader to report their
+// result, or to hand them the lead. A write still waiting gives up with
+// ctx.Err(), without being applied, if ctx is done.
+func (t *LSMTree) writeContext(ctx context.Context, entries ...*entry.Entry) error {
+	w := &pendingWrite{ctx: ctx, entries: entries, ready: make(chan struct{})}
+	t.queueMu.Lock()
+	t.queue = append(t.queue, w)
+	lead := len(t.queue) == 1
+	w.lead = lead
+	t.queueMu.Unlock()
+
+	if !lead {
+		select {
+		case <-w.ready:
+		case <-ctx.Done():
+			if t.leaveQueue(w) {
+				return ctx.Err()
+			}
+			<-w.ready
+		}
+		if !w.lead {
+			return w.err
+		}
+	}
+
+	group := t.commitGroup(w)
+
+	t.queueMu.Lock()
+	clear(t.queue[:len(group)])
+	t.queue = t.queue[len(group):]
+	if len(t.queue) > 0 {
+		t.queue[0].lead = true
+		close(t.queue[0].ready)
+	}
+	t.queueMu.Unlock()
+	for _, f := range group[1:] {
+		close(f.ready)
+	}
+	return w.err
+}
+
+// leaveQueue removes w from the queue unless a leader has taken it, and reports
+// whether it did.
+func (t *LSMTree) leaveQueue(w *pendingWrite) bool {
+	t.queueMu.Lock()
+	defer t.queueMu.Unlock()
+	if w.taken || w.lead {
+		return false
+	}
+	for i, q := range t.queue {
+		if q == w {
+			t.queue = append(t.queue[:i:i], t.queue[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// commitGroup commits the group led by leader, the head of the queue, and
+// returns it, leader first. Each write's err is set.
+func (t *LSMTree) commitGroup(leader *pendingWrite) []*pendingWrite {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.stall(leader.ctx); err != nil {
+		leader.err = err
+		return []*pendingWrite{leader}
+	}
+
+	t.queueMu.Lock()
+	group := []*pendingWrite{leader}
+	size := leader.size()
+	for _, f := range t.queue[1:] {
+		if size += f.size(); size > maxGroupSize {
+			break
+		}
+		f.taken = true
+		group = append(group, f)
+	}
+	t.queueMu.Unlock()
+
+	var batches [][]*entry.Entry
+	var committing []*pendingWrite
+	for _, w := range group {
+		if err := w.ctx.Err(); err != nil {
+			w.err = err
+			continue
+		}
+		batches = append(batches, w.entries)
+		committing = append(committing, w)
+	}
+	for i, err := range t.commitLocked(batches) {
+		committing[i].err = err
+	}
+	return group
+}
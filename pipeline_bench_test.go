@@ -0,0 +1,43 @@
+package lmstree
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func BenchmarkLSMTree_ParallelPut(b *testing.B) {
+	modes := []struct {
+		name string
+		mode WALSyncMode
+	}{
+		{"None", WALSyncNone},
+		{"Always", WALSyncAlways},
+		{"Group", WALSyncGroup},
+	}
+	for _, m := range modes {
+		b.Run(m.name, func(b *testing.B) {
+			opts := DefaultOptions(b.TempDir())
+			opts.WALSyncMode = m.mode
+			tree, err := Open(opts)
+			if err != nil {
+				b.Fatalf("Open failed: %v", err)
+			}
+			defer tree.Close()
+
+			value := make([]byte, 100)
+			var n atomic.Int64
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					key := []byte(fmt.Sprintf("key%012d", n.Add(1)))
+					if err := tree.Put(key, value); err != nil {
+						b.Errorf("Put failed: %v", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package lmstree
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maksymus/lmstree/entry"
+	walPkg "github.com/maksymus/lmstree/internal/wal"
+)
+
+func TestLSMTree_GroupCommit(t *testing.T) {
+	dir := tempDir(t)
+	opts := DefaultOptions(dir)
+	opts.WALSyncMode = WALSyncGroup
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	// Hold the tree lock until every writer has queued, so the first one to
+	// lead finds the others behind it.
+	const writers = 16
+	tree.mu.Lock()
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := tree.Put([]byte(fmt.Sprintf("key%02d", w)), []byte("value")); err != nil {
+				t.Errorf("Put: %v", err)
+			}
+		}()
+	}
+	for queued := 0; queued < writers; {
+		time.Sleep(time.Millisecond)
+		tree.queueMu.Lock()
+		queued = len(tree.queue)
+		tree.queueMu.Unlock()
+	}
+	tree.mu.Unlock()
+	wg.Wait()
+
+	// Every write is applied, with its own sequence number, in one WAL record.
+	if tree.seq != writers {
+		t.Fatalf("seq = %d, want %d", tree.seq, writers)
+	}
+	for w := 0; w < writers; w++ {
+		if _, ok := tree.Get([]byte(fmt.Sprintf("key%02d", w))); !ok {
+			t.Errorf("Get(key%02d): not found", w)
+		}
+	}
+	segments, err := walPkg.Segments(dir)
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("Segments = %v, %v; want the active WAL", segments, err)
+	}
+	wal, err := walPkg.Open(segments[0])
+	if err != nil {
+		t.Fatalf("wal.Open: %v", err)
+	}
+	defer wal.Close()
+	batches, err := wal.ReadBatches()
+	if err != nil {
+		t.Fatalf("ReadBatches: %v", err)
+	}
+	if len(batches) != 1 || len(batches[0]) != writers {
+		t.Errorf("WAL holds %d records, want one of all %d writes", len(batches), writers)
+	}
+}
+
+func TestLSMTree_CommitLockedFailsBatchesAlone(t *testing.T) {
+	tree, err := Open(DefaultOptions(tempDir(t)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	tree.mu.Lock()
+	errs := tree.commitLocked([][]*entry.Entry{
+		{{Key: []byte("a"), Value: []byte("1")}},
+		{{Key: []byte("z"), Value: []byte("b"), RangeDelete: true}},
+		{{Key: []byte("c"), Value: []byte("3")}, {Key: []byte("d"), Value: []byte("4"), Family: 99}},
+		{{Key: []byte("e"), Value: []byte("5")}},
+		{{Key: []byte("f"), Value: []byte("6")}, {Key: []byte("g"), Value: nil}},
+		{{Key: nil, Value: []byte("7")}},
+		{nil},
+		{{Key: []byte("h"), Value: []byte{}, Tombstone: true}},
+	})
+	tree.mu.Unlock()
+
+	want := []error{nil, ErrInvalidRange, ErrColumnFamilyNotFound, nil}
+	for i := range want {
+		if errs[i] != want[i] {
+			t.Errorf("batch %d: error = %v, want %v", i, errs[i], want[i])
+		}
+	}
+	// Entries WAL.Write would refuse fail only their own batch.
+	for i := len(want); i < len(errs)-1; i++ {
+		if errs[i] == nil {
+			t.Errorf("batch %d: error = nil, want an invalid-entry error", i)
+		}
+	}
+	if last := errs[len(errs)-1]; last != nil {
+		t.Errorf("tombstone batch: error = %v, want nil", last)
+	}
+	for key, ok := range map[string]bool{"a": true, "c": false, "e": true, "f": false} {
+		if _, found := tree.Get([]byte(key)); found != ok {
+			t.Errorf("Get(%s) found = %v, want %v", key, found, ok)
+		}
+	}
+}
+
+func TestLSMTree_QueuedWriteContextCanceled(t *testing.T) {
+	tree, err := Open(DefaultOptions(tempDir(t)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	queued := func(n int) {
+		for {
+			tree.queueMu.Lock()
+			l := len(tree.queue)
+			tree.queueMu.Unlock()
+			if l == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	tree.mu.Lock()
+	leader := make(chan error, 1)
+	go func() { leader <- tree.Put([]byte("a"), []byte("1")) }()
+	queued(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	follower := make(chan error, 1)
+	go func() { follower <- tree.PutContext(ctx, []byte("b"), []byte("2")) }()
+	queued(2)
+
+	// The follower leaves the queue while the leader is still blocked.
+	cancel()
+	if err := <-follower; err != context.Canceled {
+		t.Fatalf("queued PutContext = %v, want context.Canceled", err)
+	}
+	tree.mu.Unlock()
+	if err := <-leader; err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, ok := tree.Get([]byte("b")); ok {
+		t.Error("canceled write was applied")
+	}
+	if _, ok := tree.Get([]byte("a")); !ok {
+		t.Error("leader's write was not applied")
+	}
+}
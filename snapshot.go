@@ -0,0 +1,61 @@
+package lmstree
+
+import (
+	"context"
+	"slices"
+)
+
+// Snapshot is a consistent, point-in-time view of the tree. Reads through a
+// snapshot see every write with a sequence number <= Seq() and none after it,
+// and compaction keeps the versions it needs for as long as it is live.
+//
+// A Snapshot must be released once it is no longer needed.
+type Snapshot struct {
+	tree *LSMTree
+	seq  uint64
+}
+
+// NewSnapshot captures the current state of the tree.
+func (t *LSMTree) NewSnapshot() *Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := &Snapshot{tree: t, seq: t.seq}
+	t.snapshots[s] = struct{}{}
+	return s
+}
+
+// Seq returns the sequence number of the last write visible to the snapshot.
+func (s *Snapshot) Seq() uint64 { return s.seq }
+
+// Release lets compaction discard versions kept only for this snapshot.
+// Releasing a snapshot more than once is a no-op.
+func (s *Snapshot) Release() {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	delete(s.tree.snapshots, s)
+}
+
+// GetAt returns the value key had when snapshot was taken.
+func (t *LSMTree) GetAt(snapshot *Snapshot, key []byte) ([]byte, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	value, ok, _ := t.getAt(context.Background(), t.defaultCF, key, snapshot.seq)
+	return value, ok
+}
+
+// ScanAt is like Scan but iterates the tree as it was when snapshot was taken.
+func (t *LSMTree) ScanAt(snapshot *Snapshot, start, end []byte) *Iterator {
+	return t.scan(context.Background(), t.defaultCF, snapshot, start, end)
+}
+
+// snapshotSeqs returns the sequence numbers of all live snapshots, ascending.
+// Must be called with t.mu held (read or write).
+func (t *LSMTree) snapshotSeqs() []uint64 {
+	seqs := make([]uint64, 0, len(t.snapshots))
+	for s := range t.snapshots {
+		seqs = append(seqs, s.seq)
+	}
+	slices.Sort(seqs)
+	return seqs
+}
@@ -0,0 +1,150 @@
+package lmstree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestLSMTree_SnapshotGetAt(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.MemTableSize = 1 // every write rotates, so versions travel through flush and compaction
+	opts.L0CompactThresh = 2
+	opts.BlockSize = 64
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	tree.Put([]byte("k"), []byte("v1"))
+	tree.Put([]byte("gone"), []byte("here"))
+	snap := tree.NewSnapshot()
+	defer snap.Release()
+
+	tree.Put([]byte("k"), []byte("v2"))
+	tree.Delete([]byte("gone"))
+	tree.Put([]byte("new"), []byte("x"))
+	for i := 0; i < 10; i++ {
+		tree.Put([]byte(fmt.Sprintf("filler%02d", i)), []byte("f"))
+	}
+
+	tests := []struct {
+		key      string
+		snapVal  string
+		snapOK   bool
+		latest   string
+		latestOK bool
+	}{
+		{"k", "v1", true, "v2", true},
+		{"gone", "here", true, "", false},
+		{"new", "", false, "x", true},
+	}
+	for _, tt := range tests {
+		got, ok := tree.GetAt(snap, []byte(tt.key))
+		if ok != tt.snapOK || !bytes.Equal(got, []byte(tt.snapVal)) && tt.snapOK {
+			t.Errorf("GetAt(snap, %s) = (%q, %v), want (%q, %v)", tt.key, got, ok, tt.snapVal, tt.snapOK)
+		}
+		got, ok = tree.Get([]byte(tt.key))
+		if ok != tt.latestOK || !bytes.Equal(got, []byte(tt.latest)) && tt.latestOK {
+			t.Errorf("Get(%s) = (%q, %v), want (%q, %v)", tt.key, got, ok, tt.latest, tt.latestOK)
+		}
+	}
+
+	keys, values := collect(t, tree.ScanAt(snap, nil, nil))
+	if fmt.Sprint(keys) != "[gone k]" || fmt.Sprint(values) != "[here v1]" {
+		t.Fatalf("ScanAt(snap) = %v %v, want [gone k] [here v1]", keys, values)
+	}
+}
+
+func TestLSMTree_ScanIsPointInTime(t *testing.T) {
+	tree, err := Open(DefaultOptions(tempDir(t)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	tree.Put([]byte("a"), []byte("1"))
+	tree.Put([]byte("c"), []byte("1"))
+
+	it := tree.Scan(nil, nil)
+	tree.Put([]byte("b"), []byte("2"))
+	tree.Put([]byte("c"), []byte("2"))
+
+	keys, values := collect(t, it)
+	if fmt.Sprint(keys) != "[a c]" || fmt.Sprint(values) != "[1 1]" {
+		t.Fatalf("Scan = %v %v, want [a c] [1 1]", keys, values)
+	}
+}
+
+func TestLSMTree_SequenceSurvivesReopen(t *testing.T) {
+	dir := tempDir(t)
+	tree, err := Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	tree.Put([]byte("a"), []byte("1"))
+	tree.Put([]byte("a"), []byte("2"))
+	before := tree.NewSnapshot().Seq()
+	tree.Close()
+
+	tree, err = Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+	defer tree.Close()
+	tree.Put([]byte("a"), []byte("3"))
+	if after := tree.NewSnapshot().Seq(); after <= before {
+		t.Fatalf("sequence went backwards across reopen: %d -> %d", before, after)
+	}
+	if got, _ := tree.Get([]byte("a")); !bytes.Equal(got, []byte("3")) {
+		t.Fatalf("Get a = %q, want 3", got)
+	}
+}
+
+func TestLSMTree_TombstoneShadowsDeeperLevels(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.MemTableSize = 1 // every batch becomes one L0 file; tiny level budgets cascade to L2
+	opts.L0CompactThresh = 2
+	opts.BlockSize = 64
+
+	// Each session writes one batch and closes, so flushes and compactions
+	// have finished before the next session starts.
+	session := func(fill func(b *WriteBatch)) {
+		t.Helper()
+		tree, err := Open(opts)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		b := NewWriteBatch()
+		fill(b)
+		if err := tree.Write(b); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := tree.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+
+	session(func(b *WriteBatch) {
+		for i := 0; i < 20; i++ {
+			b.Put([]byte(fmt.Sprintf("key%02d", i)), []byte("v"))
+		}
+	})
+	session(func(b *WriteBatch) { b.Put([]byte("key20"), []byte("v")) }) // cascades everything to L2
+	session(func(b *WriteBatch) { b.Delete([]byte("key05")) })
+	session(func(b *WriteBatch) { b.Put([]byte("key21"), []byte("v")) }) // L0 -> L1 while L2 holds key05
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+	if _, ok := tree.Get([]byte("key05")); ok {
+		t.Fatal("deleted key resurfaced after compaction dropped its tombstone")
+	}
+	if _, ok := tree.Get([]byte("key04")); !ok {
+		t.Fatal("key04 lost")
+	}
+}
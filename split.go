@@ -0,0 +1,81 @@
+package lmstree
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/maksymus/lmstree/internal/memtable"
+	"github.com/maksymus/lmstree/internal/sstable"
+)
+
+// SplitKeys returns up to n keys, in key order, that split [start, end) of the
+// default column family into n+1 parts of about the same size in bytes. A nil
+// start or end leaves that side unbounded. The keys are data-block boundaries of
+// SSTables and samples of the MemTables, so no data block is read; each lies
+// strictly inside the range, and fewer than n are returned when the range holds
+// too few such points to tell parts apart.
+func (t *LSMTree) SplitKeys(start, end []byte, n int) [][]byte {
+	return t.defaultCF.SplitKeys(start, end, n)
+}
+
+// SplitKeys returns up to n keys that split [start, end) of the family into n+1
+// parts of about the same size, like LSMTree.SplitKeys.
+func (cf *ColumnFamily) SplitKeys(start, end []byte, n int) [][]byte {
+	if n <= 0 {
+		return nil
+	}
+	points := cf.splitPoints(start, end)
+	cmp := cf.opts.Comparator
+	slices.SortStableFunc(points, func(a, b sstable.Boundary) int { return cmp.Compare(a.Key, b.Key) })
+	var total int64
+	for _, p := range points {
+		total += p.Size
+	}
+	if total == 0 {
+		return nil
+	}
+
+	// The i-th split key is the first point at which the bytes before it reach
+	// i/(n+1) of the total.
+	var keys [][]byte
+	var before int64
+	for _, p := range points {
+		if len(keys) == n {
+			break
+		}
+		target := total * int64(len(keys)+1) / int64(n+1)
+		inside := start == nil || cmp.Compare(p.Key, start) > 0
+		if before >= target && inside && (len(keys) == 0 || cmp.Compare(p.Key, keys[len(keys)-1]) > 0) {
+			keys = append(keys, bytes.Clone(p.Key))
+		}
+		before += p.Size
+	}
+	return keys
+}
+
+// splitPoints returns the weighted points of cf's MemTables and SSTables in
+// [start, end), unordered.
+func (cf *ColumnFamily) splitPoints(start, end []byte) []sstable.Boundary {
+	cf.tree.mu.RLock()
+	defer cf.tree.mu.RUnlock()
+	if cf.dropped {
+		return nil
+	}
+	var points []sstable.Boundary
+	sample := func(mem *memtable.MemTable) {
+		keys, size := mem.Sample(start, end)
+		for _, key := range keys {
+			points = append(points, sstable.Boundary{Key: key, Size: size})
+		}
+	}
+	sample(cf.memTable)
+	if cf.immutable != nil {
+		sample(cf.immutable)
+	}
+	for _, level := range cf.levels {
+		for _, sst := range level {
+			points = append(points, sst.reader.Boundaries(start, end)...)
+		}
+	}
+	return points
+}
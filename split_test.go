@@ -0,0 +1,52 @@
+package lmstree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestLSMTree_SplitKeys(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.MemTableSize = 16 * 1024
+	opts.BlockSize = 1024
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	value := make([]byte, 100)
+	for i := 0; i < 4000; i++ {
+		tree.Put([]byte(fmt.Sprintf("key%05d", i)), value)
+	}
+
+	// Four splits of the whole tree: near 800, 1600, 2400 and 3200.
+	splits := tree.SplitKeys(nil, nil, 4)
+	if len(splits) != 4 {
+		t.Fatalf("SplitKeys(4) = %q, want 4 keys", splits)
+	}
+	for i, key := range splits {
+		var n int
+		fmt.Sscanf(string(key), "key%d", &n)
+		if want := 800 * (i + 1); n < want-200 || n > want+200 {
+			t.Errorf("split %d = %s, want near key%05d", i, key, want)
+		}
+	}
+
+	// Within a range, splits lie strictly inside it.
+	start, end := []byte("key01000"), []byte("key02000")
+	splits = tree.SplitKeys(start, end, 3)
+	if len(splits) == 0 {
+		t.Fatalf("SplitKeys(%s, %s, 3) returned no keys", start, end)
+	}
+	for i, key := range splits {
+		if bytes.Compare(key, start) <= 0 || bytes.Compare(key, end) >= 0 || i > 0 && bytes.Compare(key, splits[i-1]) <= 0 {
+			t.Errorf("SplitKeys(%s, %s, 3) = %q", start, end, splits)
+		}
+	}
+
+	if splits := tree.SplitKeys([]byte("x"), nil, 3); len(splits) != 0 {
+		t.Errorf("SplitKeys of an empty range = %q", splits)
+	}
+}
@@ -1,65 +1,445 @@
 package lmstree
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/maksymus/lmstree/entry"
+	"github.com/maksymus/lmstree/internal/lock"
 	"github.com/maksymus/lmstree/internal/memtable"
 	"github.com/maksymus/lmstree/internal/sstable"
+	"github.com/maksymus/lmstree/internal/vlog"
 	walPkg "github.com/maksymus/lmstree/internal/wal"
 )
 
 // sstableFile is an in-memory handle for one SSTable file on disk.
+// It is reference counted so open iterators keep the reader usable after a
+// compaction has replaced the file; the last unref closes (and, if obsolete, removes) it.
 type sstableFile struct {
-	path   string
-	level  int
-	reader *sstable.Reader
+	path     string
+	level    int
+	reader   *sstable.Reader
+	refs     atomic.Int32
+	obsolete atomic.Bool
 }
 
-// flushJob carries a frozen MemTable and its WAL to the background flush worker.
+// newSSTableFile returns a handle holding the tree's own reference.
+func newSSTableFile(path string, level int, reader *sstable.Reader) *sstableFile {
+	sst := &sstableFile{path: path, level: level, reader: reader}
+	sst.refs.Store(1)
+	return sst
+}
+
+func (s *sstableFile) ref() { s.refs.Add(1) }
+
+func (s *sstableFile) unref() {
+	if s.refs.Add(-1) == 0 {
+		s.reader.Close()
+		if s.obsolete.Load() {
+			os.Remove(s.path)
+		}
+	}
+}
+
+// flushJob carries the frozen MemTables of every family that had data, and the
+// WAL they were logged to, to the background flush worker.
 type flushJob struct {
-	mem    *memtable.MemTable
-	oldWAL *walPkg.WAL
+	families []*ColumnFamily // each with immutable set
+	oldWAL   *walPkg.WAL
 }
 
-// LSMTree is a Log-Structured Merge Tree backed by a MemTable and leveled SSTables.
+// LSMTree is a Log-Structured Merge Tree backed by MemTables and leveled SSTables,
+// one set per column family.
 //
-//	Memory:  active MemTable (SkipList + WAL)
-//	         immutable MemTable (being flushed by background worker)
-//	Disk:    WAL | Level 0 SSTables  (unsorted, may overlap)
-//	              | Level 1 SSTables
-//	              | Level N SSTables  (merged, no overlap within a level)
+//	Memory:  active MemTable per family (SkipList)
+//	         immutable MemTables (being flushed by background worker)
+//	Disk:    WAL shared by all families
+//	         per family: Level 0 SSTables  (unsorted, may overlap)
+//	                     Level 1 SSTables
+//	                     Level N SSTables  (merged, no overlap within a level)
 type LSMTree struct {
-	mu        sync.RWMutex
-	opts      Options
-	memTable  *memtable.MemTable
-	immutable *memtable.MemTable // frozen; being flushed by flushWorker
-	wal       *walPkg.WAL        // current active WAL
-	levels    [][]*sstableFile   // levels[i] = SSTables at level i, newest first
-	flushCh   chan flushJob       // capacity 1; at most one flush in flight at a time
-	done      chan struct{}       // closed by Close() to stop the worker
-	wg        sync.WaitGroup     // tracks the flush worker goroutine
-}
-
-// rotateMemTable atomically swaps the active MemTable for a fresh one and hands
-// the old one to the background flush worker. Must be called with t.mu held.
-func (t *LSMTree) rotateMemTable() {
+	mu           sync.RWMutex
+	opts         Options
+	defaultCF    *ColumnFamily            // target of LSMTree's own Get/Put/Delete/Scan
+	families     map[string]*ColumnFamily // by name, including the default family
+	familyIDs    map[uint32]*ColumnFamily // by id, as recorded in the WAL
+	nextFamilyID uint32                   // id for the next CreateColumnFamily
+	wal          *walPkg.WAL              // current active WAL
+	oldWAL       *walPkg.WAL              // WAL of the MemTables being flushed, if any
+	flushing     bool                     // a flush job is queued or running
+	flushDone    chan struct{}            // closed when the flush in flight completes
+	flushCh      chan flushJob            // capacity 1; at most one flush in flight at a time
+	done         chan struct{}            // closed by Close() to stop the worker
+	wg           sync.WaitGroup           // tracks the flush worker goroutine
+	seq          uint64                   // sequence number of the last applied write
+	syncedSeq    uint64                   // last write known to be synced, in the sync modes
+	walErr       error                    // set once the WAL fails; every later write fails with it
+	snapshots    map[*Snapshot]struct{}   // live snapshots; their versions survive compaction
+	locks        *lock.Manager            // key locks held by pessimistic transactions
+	txnID        atomic.Uint64            // last transaction id handed out
+	vlog         *vlog.Log                // values of at least ValueThreshold bytes
+	indexes      map[string]*index        // secondary indexes over the default family, by name
+	changed      chan struct{}            // closed by the next write if watched
+	watched      bool                     // a subscriber waits on changed
+	queueMu      sync.Mutex               // guards queue
+	queue        []*pendingWrite          // writes waiting to commit; the head leads
+}
+
+// stallFactor bounds MemTable growth while a flush is in flight: once a MemTable
+// reaches stallFactor × its MemTableSize, writes wait for the flush to finish.
+const stallFactor = 2
+
+// write stamps entries with consecutive sequence numbers, logs them as one WAL
+// record, applies them to their families' MemTables and rotates the MemTables
+// out if one has grown past its MemTableSize and no flush is in flight.
+// Concurrent writes are committed in groups by writeContext.
+func (t *LSMTree) write(entries ...*entry.Entry) error {
+	return t.writeContext(context.Background(), entries...)
+}
+
+// stall waits while a flush is in flight and some MemTable has reached
+// stallFactor × its MemTableSize, so a slow disk cannot let MemTables grow
+// without bound. t.mu is released during the wait. It returns ctx.Err() if ctx
+// is done. Must be called with t.mu held for writing.
+func (t *LSMTree) stall(ctx context.Context) error {
+	for t.flushing && t.overFull() {
+		done := t.flushDone
+		t.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+		t.mu.Lock()
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// overFull reports whether some family's MemTable has reached the stall limit.
+// Must be called with t.mu held.
+func (t *LSMTree) overFull() bool {
+	for _, cf := range t.families {
+		if cf.memTable.Size() >= stallFactor*cf.opts.MemTableSize {
+			return true
+		}
+	}
+	return false
+}
+
+// writeLocked is write for callers that already hold t.mu for writing, such as
+// transaction commits that must validate and apply under one lock.
+func (t *LSMTree) writeLocked(entries ...*entry.Entry) error {
+	return t.commitLocked([][]*entry.Entry{entries})[0]
+}
+
+// commitLocked writes batches, each atomically, and returns the error of each.
+// A batch naming an unknown family or an invalid range fails on its own; the
+// others are stamped with consecutive sequence numbers in order, logged together
+// as one WAL record with at most one sync, and applied to their families'
+// MemTables, then MemTables are rotated out as in write. In WALSyncGroup mode
+// t.mu is released during the sync. Must be called with t.mu held for writing.
+func (t *LSMTree) commitLocked(batches [][]*entry.Entry) []error {
+	errs := make([]error, len(batches))
+	var entries []*entry.Entry
+	var valid []int
+	for i, batch := range batches {
+		if errs[i] = t.validate(batch); errs[i] == nil {
+			entries = append(entries, batch...)
+			valid = append(valid, i)
+		}
+	}
+	if err := t.commitEntries(entries); err != nil {
+		for _, i := range valid {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+// validate checks that every entry has a key, and a value unless it is a
+// tombstone, names an existing family, and that range tombstones have
+// start < end. These are the checks of WAL.Write, made per batch so that a bad
+// batch cannot fail the others logged in the same record.
+func (t *LSMTree) validate(entries []*entry.Entry) error {
+	for i, e := range entries {
+		switch {
+		case e == nil:
+			return fmt.Errorf("entry at index %d is nil", i)
+		case len(e.Key) == 0:
+			return fmt.Errorf("entry at index %d has empty Key", i)
+		case len(e.Value) == 0 && !e.Tombstone:
+			return fmt.Errorf("entry at index %d has empty Value", i)
+		}
+		cf, ok := t.familyIDs[e.Family]
+		if !ok {
+			return ErrColumnFamilyNotFound
+		}
+		if e.RangeDelete && !cf.validRange(e.Key, e.Value) {
+			return ErrInvalidRange
+		}
+	}
+	return nil
+}
+
+// commitEntries logs validated entries as one WAL record and applies them.
+// Must be called with t.mu held for writing.
+func (t *LSMTree) commitEntries(entries []*entry.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if t.walErr != nil {
+		return t.walErr
+	}
+	if len(t.indexes) > 0 {
+		var err error
+		if entries, err = t.withIndexEntries(entries); err != nil {
+			return err
+		}
+	}
+	for i, e := range entries {
+		e.Seq = t.seq + uint64(i) + 1
+	}
+	entries, err := t.separateValues(entries)
+	if err != nil {
+		return err
+	}
+	w := t.wal
+	if err := w.Write(entries...); err != nil {
+		return t.failWAL(err) // the record may be in the file in part
+	}
+	if t.opts.WALSyncMode == WALSyncAlways {
+		// Separated values first: the WAL only holds pointers to them.
+		if err := t.vlog.Sync(); err != nil {
+			return t.failWAL(err)
+		}
+		if err := w.Sync(); err != nil {
+			return t.failWAL(err)
+		}
+	}
+	if err := t.apply(entries); err != nil {
+		return t.failWAL(err)
+	}
+	t.seq += uint64(len(entries))
+	last := t.seq
+	if t.opts.WALSyncMode == WALSyncAlways {
+		t.markSynced(last)
+	}
+	t.notifyWatchers()
+
+	if !t.flushing {
+		for _, cf := range t.families {
+			if cf.memTable.Size() >= cf.opts.MemTableSize {
+				t.rotateMemTables()
+				break
+			}
+		}
+	}
+	if t.opts.WALSyncMode == WALSyncGroup {
+		if err := t.groupSync(w); err != nil {
+			return t.failWAL(err)
+		}
+		t.markSynced(last)
+	}
+	return nil
+}
+
+// apply inserts logged entries into their families' active MemTables.
+// Must be called with t.mu held.
+func (t *LSMTree) apply(entries []*entry.Entry) error {
+	if len(t.familyIDs) == 1 {
+		return t.defaultCF.memTable.Apply(entries...)
+	}
+	byFamily := make(map[uint32][]*entry.Entry)
+	for _, e := range entries {
+		byFamily[e.Family] = append(byFamily[e.Family], e)
+	}
+	for id, group := range byFamily {
+		if err := t.familyIDs[id].memTable.Apply(group...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeIf applies e only if the current value of key equals expected (nil meaning
+// absent). The read and the write happen under one hold of t.mu, so no other
+// writer can slip in between; the read covers every MemTable and SSTable level.
+func (t *LSMTree) writeIf(key, expected []byte, e *entry.Entry) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ctx := context.Background()
+	if err := t.stall(ctx); err != nil {
+		return false, err
+	}
+	current, ok, err := t.getAt(ctx, t.defaultCF, key, t.seq)
+	if err != nil {
+		return false, err
+	}
+	if ok != (expected != nil) || !bytes.Equal(current, expected) {
+		return false, nil
+	}
+	if err := t.writeLocked(e); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// getAt returns the newest value of key in cf with a sequence number <= seq,
+// folding any merge operands on top of it into one value. Expired values and
+// versions under a range tombstone read as deleted. It returns ctx.Err() if ctx
+// is done before the lookup completes. Must be called with t.mu held (read or write).
+func (t *LSMTree) getAt(ctx context.Context, cf *ColumnFamily, key []byte, seq uint64) ([]byte, bool, error) {
+	now := time.Now().UnixNano()
+	deletedAt := cf.rangeDeletedAt(key, seq)
+	var operands [][]byte // newest first
+	for {
+		e, ok, err := cf.getEntryAt(ctx, key, seq)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok && e.Seq < deletedAt {
+			e, ok = nil, false
+		}
+		if !ok || !e.Operand {
+			live := ok && !e.Tombstone && !e.Expired(now)
+			if len(operands) > 0 {
+				var existing []byte
+				if live {
+					if existing, err = t.value(e); err != nil {
+						return nil, false, err
+					}
+				}
+				return mergeValue(cf.opts.MergeOperator, key, existing, operands), true, nil
+			}
+			if !live {
+				return nil, false, nil
+			}
+			value, err := t.value(e)
+			if err != nil {
+				return nil, false, err
+			}
+			return value, true, nil
+		}
+		operands = append(operands, e.Value)
+		if e.Seq == 0 {
+			return mergeValue(cf.opts.MergeOperator, key, nil, operands), true, nil
+		}
+		seq = e.Seq - 1
+	}
+}
+
+// getEntryAt returns the newest version of key with a sequence number <= seq,
+// including tombstones. ctx is checked before each SSTable probe. Must be called
+// with the tree's mu held (read or write).
+func (cf *ColumnFamily) getEntryAt(ctx context.Context, key []byte, seq uint64) (*entry.Entry, bool, error) {
+	// Active MemTable has the freshest data.
+	if e, ok := cf.memTable.GetEntryAt(key, seq); ok {
+		return e, true, nil
+	}
+
+	// Immutable MemTable is older than active but newer than any SSTable.
+	if cf.immutable != nil {
+		if e, ok := cf.immutable.GetEntryAt(key, seq); ok {
+			return e, true, nil
+		}
+	}
+
+	// Search SSTables level by level (L0 first = newest data).
+	for _, level := range cf.levels {
+		for _, sst := range level {
+			if err := ctx.Err(); err != nil {
+				return nil, false, err
+			}
+			if e, ok := sst.reader.SearchAt(key, seq); ok {
+				return e, true, nil
+			}
+		}
+	}
+
+	return nil, false, nil
+}
+
+// rangeTombstones returns the range tombstones of cf with a sequence number <=
+// seq. Must be called with the tree's mu held (read or write).
+func (cf *ColumnFamily) rangeTombstones(seq uint64) []*entry.Entry {
+	var tombstones []*entry.Entry
+	add := func(list []*entry.Entry) {
+		for _, t := range list {
+			if t.Seq <= seq {
+				tombstones = append(tombstones, t)
+			}
+		}
+	}
+	add(cf.memTable.RangeTombstones())
+	if cf.immutable != nil {
+		add(cf.immutable.RangeTombstones())
+	}
+	for _, level := range cf.levels {
+		for _, sst := range level {
+			add(sst.reader.RangeTombstones())
+		}
+	}
+	return tombstones
+}
+
+// rangeDeletedAt returns the sequence number of the newest range tombstone of cf
+// with a sequence number <= seq that covers key, or 0 if there is none. Versions
+// of key older than it are deleted. Must be called with the tree's mu held.
+func (cf *ColumnFamily) rangeDeletedAt(key []byte, seq uint64) uint64 {
+	return coveringSeq(cf.opts.Comparator, cf.rangeTombstones(seq), key)
+}
+
+// coveringSeq returns the highest sequence number among the tombstones covering
+// key, in the order of cmp.
+func coveringSeq(cmp Comparator, tombstones []*entry.Entry, key []byte) uint64 {
+	var seq uint64
+	for _, t := range tombstones {
+		if t.Seq > seq && t.CoversWith(key, cmp.Compare) {
+			seq = t.Seq
+		}
+	}
+	return seq
+}
+
+// rotateMemTables atomically swaps every non-empty active MemTable, and the WAL
+// they share, for fresh ones and hands the old ones to the background flush
+// worker. Must be called with t.mu held.
+func (t *LSMTree) rotateMemTables() {
 	oldWAL := t.wal
 	newWAL, err := walPkg.Create(t.opts.Dir)
 	if err != nil {
 		return
 	}
-	t.immutable = t.memTable
+	var frozen []*ColumnFamily
+	for _, cf := range t.families {
+		if cf.memTable.Size() == 0 {
+			continue
+		}
+		cf.immutable = cf.memTable
+		cf.memTable = newFamilyMemTable(cf.opts.Comparator)
+		frozen = append(frozen, cf)
+	}
 	t.wal = newWAL
-	t.memTable = memtable.NewMemTable(t.opts.Dir, defaultSkipListLevel, newWAL)
-	t.flushCh <- flushJob{mem: t.immutable, oldWAL: oldWAL}
+	t.oldWAL = oldWAL
+	t.flushing = true
+	t.flushDone = make(chan struct{})
+	// Never blocks: flushing guards against a second job while one is in flight.
+	// Writers that must wait for the worker do so in stall, outside t.mu.
+	t.flushCh <- flushJob{families: frozen, oldWAL: oldWAL}
 }
 
 // flushWorker runs in a background goroutine and processes flush jobs one at a time.
@@ -75,104 +455,166 @@ func (t *LSMTree) flushWorker() {
 	}
 }
 
-// processFlush builds an SSTable from the frozen MemTable, writes it to disk, and
-// installs it into levels[0] — without holding t.mu during the heavy I/O.
+// processFlush builds an SSTable from each frozen MemTable, writes it to disk, and
+// installs it into its family's levels[0] — without holding t.mu during the heavy
+// I/O. The old WAL is deleted only once every family's data is safely on disk.
 func (t *LSMTree) processFlush(job flushJob) {
-	entries := job.mem.Entries()
-	if len(entries) == 0 {
-		t.mu.Lock()
-		t.immutable = nil
-		t.mu.Unlock()
-		job.oldWAL.Delete()
-		return
-	}
-
-	data, err := sstable.Build(entries, t.opts.BlockSize, 0)
-	if err != nil {
-		t.mu.Lock()
-		t.immutable = nil
-		t.mu.Unlock()
-		return
+	t.mu.RLock()
+	snapshots := t.snapshotSeqs()
+	t.mu.RUnlock()
+
+	// The separated values the MemTables point to must be durable before an
+	// SSTable refers to them and the WAL is retired.
+	flushed := true
+	families := job.families
+	if err := t.vlog.Sync(); err != nil {
+		flushed, families = false, nil
 	}
+	for _, cf := range families {
+		sst, err := t.writeL0(cf, cf.immutable, snapshots)
+		if err != nil {
+			flushed = false
+			continue
+		}
 
-	path, err := t.writeSSTFile(0, data)
-	if err != nil {
 		t.mu.Lock()
-		t.immutable = nil
-		t.mu.Unlock()
-		return
-	}
-
-	reader, err := sstable.OpenReader(path)
-	if err != nil {
-		os.Remove(path)
-		t.mu.Lock()
-		t.immutable = nil
+		if sst != nil && cf.dropped {
+			sst.obsolete.Store(true)
+			sst.unref()
+		} else if sst != nil {
+			cf.levels[0] = append([]*sstableFile{sst}, cf.levels[0]...)
+			if len(cf.levels[0]) >= cf.opts.L0CompactThresh {
+				_ = t.compact(cf, 0)
+			}
+		}
 		t.mu.Unlock()
-		return
 	}
 
 	t.mu.Lock()
-	t.levels[0] = append([]*sstableFile{{path: path, level: 0, reader: reader}}, t.levels[0]...)
-	t.immutable = nil
-	if len(t.levels[0]) >= t.opts.L0CompactThresh {
-		_ = t.compact(0)
+	for _, cf := range job.families {
+		cf.immutable = nil
 	}
+	t.oldWAL = nil
+	t.flushing = false
+	close(t.flushDone)
 	t.mu.Unlock()
 
-	job.oldWAL.Delete()
+	if flushed {
+		t.retireWAL(job.oldWAL)
+	}
 }
 
 // flush is the synchronous flush path used only by Close(). Must be called with t.mu held.
 func (t *LSMTree) flush() error {
-	entries := t.memTable.Entries()
-	if len(entries) == 0 {
-		return nil
+	if err := t.vlog.Sync(); err != nil {
+		return err
+	}
+	snapshots := t.snapshotSeqs()
+	for _, cf := range t.families {
+		if cf.memTable.Size() == 0 {
+			continue
+		}
+		sst, err := t.writeL0(cf, cf.memTable, snapshots)
+		if err != nil {
+			return err
+		}
+		if sst != nil {
+			cf.levels[0] = append([]*sstableFile{sst}, cf.levels[0]...)
+		}
 	}
 
-	data, err := sstable.Build(entries, t.opts.BlockSize, 0)
+	oldWAL := t.wal
+	newWAL, err := walPkg.Create(t.opts.Dir)
 	if err != nil {
 		return err
 	}
+	t.wal = newWAL
+	for _, cf := range t.families {
+		cf.memTable = newFamilyMemTable(cf.opts.Comparator)
+	}
+	t.retireWAL(oldWAL)
 
-	path, err := t.writeSSTFile(0, data)
-	if err != nil {
-		return err
+	for _, cf := range t.families {
+		if len(cf.levels[0]) >= cf.opts.L0CompactThresh {
+			if err := t.compact(cf, 0); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
+}
 
-	reader, err := sstable.OpenReader(path)
-	if err != nil {
-		return err
+// writeL0 writes the versions of mem worth keeping to a new L0 SSTable of cf and
+// opens it. It returns nil if there is nothing to write.
+func (t *LSMTree) writeL0(cf *ColumnFamily, mem *memtable.MemTable, snapshots []uint64) (*sstableFile, error) {
+	entries, err := cf.flushEntries(mem, snapshots)
+	if err != nil || len(entries) == 0 {
+		return nil, err
 	}
 
-	t.levels[0] = append([]*sstableFile{{path: path, level: 0, reader: reader}}, t.levels[0]...)
+	data, err := sstable.BuildWith(entries, cf.opts.BlockSize, 0, cf.opts.Comparator)
+	if err != nil {
+		return nil, err
+	}
 
-	oldWAL := t.wal
-	newWAL, err := walPkg.Create(t.opts.Dir)
+	path, err := cf.writeSSTFile(0, data)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	t.wal = newWAL
-	t.memTable = memtable.NewMemTable(t.opts.Dir, defaultSkipListLevel, newWAL)
-	oldWAL.Delete()
 
-	if len(t.levels[0]) >= t.opts.L0CompactThresh {
-		return t.compact(0)
+	reader, err := sstable.OpenReaderWith(path, cf.opts.Comparator)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
 	}
-	return nil
+	return newSSTableFile(path, 0, reader), nil
 }
 
-// compact merges all SSTables at level into a single SSTable at level+1.
-// Must be called with t.mu held.
-func (t *LSMTree) compact(level int) error {
-	if level >= t.opts.MaxLevels-1 {
+// flushEntries returns the versions of mem worth writing to an L0 SSTable: the
+// newest of each key plus any still visible to a live snapshot. Tombstones, range
+// tombstones included, are kept because they must shadow data in older SSTables,
+// and merge operands are folded only into a value found in mem itself.
+func (cf *ColumnFamily) flushEntries(mem *memtable.MemTable, snapshots []uint64) ([]*entry.Entry, error) {
+	return sstable.MergeWith(sstable.MergeOptions{
+		Snapshots:       snapshots,
+		KeepTombstones:  true,
+		MergeFunc:       mergeFunc(cf.opts.MergeOperator),
+		Now:             time.Now().UnixNano(),
+		RangeTombstones: mem.RangeTombstones(),
+		Resolve:         cf.tree.value,
+		Comparator:      cf.opts.Comparator,
+	}, mem.AllEntries())
+}
+
+// compact merges all SSTables of cf at level into a single SSTable at level+1,
+// keeping every version a live snapshot can still see. Expired entries count as
+// tombstones. Tombstones are dropped, and merge operands with no value below them
+// folded, only when no deeper level holds data they could apply to.
+//
+// Data deleted by a range tombstone of the merged tables is dropped; so is any
+// table, at these levels or deeper, whose keys all lie under one such tombstone
+// that every reader can see. Must be called with t.mu held.
+func (t *LSMTree) compact(cf *ColumnFamily, level int) error {
+	if level >= cf.opts.MaxLevels-1 {
 		return nil
 	}
 
+	snapshots := t.snapshotSeqs()
+	var rangeDels []*entry.Entry
+	for _, sst := range cf.levels[level+1] {
+		rangeDels = append(rangeDels, sst.reader.RangeTombstones()...)
+	}
+	for _, sst := range cf.levels[level] {
+		rangeDels = append(rangeDels, sst.reader.RangeTombstones()...)
+	}
+
 	// level+1 entries first (lower priority), then current level oldest-first.
 	var allEntries [][]*entry.Entry
 
-	for _, sst := range t.levels[level+1] {
+	for _, sst := range cf.levels[level+1] {
+		if cf.deletedTable(sst, rangeDels, snapshots) {
+			continue
+		}
 		entries, err := sst.reader.Entries()
 		if err != nil {
 			return err
@@ -180,61 +622,105 @@ func (t *LSMTree) compact(level int) error {
 		allEntries = append(allEntries, entries)
 	}
 
-	for i := len(t.levels[level]) - 1; i >= 0; i-- {
-		entries, err := t.levels[level][i].reader.Entries()
+	for i := len(cf.levels[level]) - 1; i >= 0; i-- {
+		if cf.deletedTable(cf.levels[level][i], rangeDels, snapshots) {
+			continue
+		}
+		entries, err := cf.levels[level][i].reader.Entries()
 		if err != nil {
 			return err
 		}
 		allEntries = append(allEntries, entries)
 	}
 
-	merged, err := sstable.Merge(allEntries...)
+	// Tables deeper down are dropped outright, unless they carry range
+	// tombstones of their own that may reach beyond their keys.
+	keepTombstones := false
+	for i := level + 2; i < len(cf.levels); i++ {
+		cf.levels[i] = slices.DeleteFunc(cf.levels[i], func(sst *sstableFile) bool {
+			if len(sst.reader.RangeTombstones()) > 0 || !cf.deletedTable(sst, rangeDels, snapshots) {
+				return false
+			}
+			sst.obsolete.Store(true)
+			sst.unref()
+			return true
+		})
+		if len(cf.levels[i]) > 0 {
+			keepTombstones = true
+		}
+	}
+	merged, err := sstable.MergeWith(sstable.MergeOptions{
+		Snapshots:       snapshots,
+		KeepTombstones:  keepTombstones,
+		MergeFunc:       mergeFunc(cf.opts.MergeOperator),
+		Now:             time.Now().UnixNano(),
+		RangeTombstones: rangeDels,
+		Resolve:         t.value,
+		Comparator:      cf.opts.Comparator,
+	}, allEntries...)
 	if err != nil {
 		return err
 	}
 
-	toDelete := make([]*sstableFile, 0, len(t.levels[level])+len(t.levels[level+1]))
-	toDelete = append(toDelete, t.levels[level]...)
-	toDelete = append(toDelete, t.levels[level+1]...)
+	toDelete := make([]*sstableFile, 0, len(cf.levels[level])+len(cf.levels[level+1]))
+	toDelete = append(toDelete, cf.levels[level]...)
+	toDelete = append(toDelete, cf.levels[level+1]...)
 
-	t.levels[level] = nil
-	t.levels[level+1] = nil
+	cf.levels[level] = nil
+	cf.levels[level+1] = nil
 
 	if len(merged) > 0 {
-		data, err := sstable.Build(merged, t.opts.BlockSize, level+1)
+		data, err := sstable.BuildWith(merged, cf.opts.BlockSize, level+1, cf.opts.Comparator)
 		if err != nil {
 			return err
 		}
 
-		path, err := t.writeSSTFile(level+1, data)
+		path, err := cf.writeSSTFile(level+1, data)
 		if err != nil {
 			return err
 		}
 
-		reader, err := sstable.OpenReader(path)
+		reader, err := sstable.OpenReaderWith(path, cf.opts.Comparator)
 		if err != nil {
 			return err
 		}
-		t.levels[level+1] = []*sstableFile{{path: path, level: level + 1, reader: reader}}
+		cf.levels[level+1] = []*sstableFile{newSSTableFile(path, level+1, reader)}
 	}
 
 	for _, sst := range toDelete {
-		sst.reader.Close()
-		os.Remove(sst.path)
+		sst.obsolete.Store(true)
+		sst.unref()
 	}
 
 	// Cascade: compact level+1 if it now exceeds its size budget.
-	if len(t.levels[level+1]) > 0 && t.levelSize(level+1) > t.levelSizeLimit(level+1) {
-		return t.compact(level + 1)
+	if len(cf.levels[level+1]) > 0 && cf.levelSize(level+1) > cf.levelSizeLimit(level+1) {
+		return t.compact(cf, level+1)
 	}
 
 	return nil
 }
 
+// deletedTable reports whether every point entry of sst lies under a single
+// range tombstone that is newer than the table and visible to every snapshot,
+// so no reader can see any of them.
+func (cf *ColumnFamily) deletedTable(sst *sstableFile, rangeDels []*entry.Entry, snapshots []uint64) bool {
+	smallest, largest, ok := sst.reader.KeyRange()
+	if !ok {
+		return false
+	}
+	for _, t := range rangeDels {
+		if t.Seq > sst.reader.MaxSeq() && t.CoversWith(smallest, cf.opts.Comparator.Compare) && t.CoversWith(largest, cf.opts.Comparator.Compare) &&
+			(len(snapshots) == 0 || snapshots[0] >= t.Seq) {
+			return true
+		}
+	}
+	return false
+}
+
 // levelSizeLimit returns the byte budget for the given level.
 // Base (level 1) = MemTableSize × L0CompactThresh; each subsequent level is 10× larger.
-func (t *LSMTree) levelSizeLimit(level int) int64 {
-	limit := t.opts.MemTableSize * int64(t.opts.L0CompactThresh)
+func (cf *ColumnFamily) levelSizeLimit(level int) int64 {
+	limit := cf.opts.MemTableSize * int64(cf.opts.L0CompactThresh)
 	for i := 1; i < level; i++ {
 		limit *= 10
 	}
@@ -242,9 +728,9 @@ func (t *LSMTree) levelSizeLimit(level int) int64 {
 }
 
 // levelSize returns the total on-disk byte size of all SSTables at the given level.
-func (t *LSMTree) levelSize(level int) int64 {
+func (cf *ColumnFamily) levelSize(level int) int64 {
 	var total int64
-	for _, sst := range t.levels[level] {
+	for _, sst := range cf.levels[level] {
 		if info, err := os.Stat(sst.path); err == nil {
 			total += info.Size()
 		}
@@ -253,11 +739,11 @@ func (t *LSMTree) levelSize(level int) int64 {
 }
 
 // writeSSTFile writes data to a new uniquely-named SSTable file for the given level.
-func (t *LSMTree) writeSSTFile(level int, data []byte) (string, error) {
+func (cf *ColumnFamily) writeSSTFile(level int, data []byte) (string, error) {
 	now := time.Now()
-	version := fmt.Sprintf("%s-%d", now.Format("20060102150405"), now.Nanosecond())
+	version := fmt.Sprintf("%s-%09d", now.Format("20060102150405"), now.Nanosecond())
 	name := fmt.Sprintf("sst-%d-%s.sst", level, version)
-	path := filepath.Join(t.opts.Dir, name)
+	path := filepath.Join(cf.dir, name)
 
 	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
@@ -274,9 +760,12 @@ func (t *LSMTree) writeSSTFile(level int, data []byte) (string, error) {
 	return path, f.Close()
 }
 
-// loadSSTables scans opts.Dir for existing SSTable files and opens Readers for them.
-func (t *LSMTree) loadSSTables() error {
-	dirEntries, err := os.ReadDir(t.opts.Dir)
+// loadSSTables scans the family's directory for existing SSTable files and opens Readers for them.
+func (cf *ColumnFamily) loadSSTables() error {
+	dirEntries, err := os.ReadDir(cf.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -286,20 +775,20 @@ func (t *LSMTree) loadSSTables() error {
 			continue
 		}
 		level, ok := sstLevel(de.Name())
-		if !ok || level >= t.opts.MaxLevels {
+		if !ok || level >= cf.opts.MaxLevels {
 			continue
 		}
 
-		path := filepath.Join(t.opts.Dir, de.Name())
-		reader, err := sstable.OpenReader(path)
+		path := filepath.Join(cf.dir, de.Name())
+		reader, err := sstable.OpenReaderWith(path, cf.opts.Comparator)
 		if err != nil {
 			return err
 		}
-		t.levels[level] = append(t.levels[level], &sstableFile{path: path, level: level, reader: reader})
+		cf.levels[level] = append(cf.levels[level], newSSTableFile(path, level, reader))
 	}
 
-	for i := range t.levels {
-		lvl := t.levels[i]
+	for i := range cf.levels {
+		lvl := cf.levels[i]
 		sort.Slice(lvl, func(a, b int) bool {
 			return lvl[a].path > lvl[b].path
 		})
@@ -308,6 +797,65 @@ func (t *LSMTree) loadSSTables() error {
 	return nil
 }
 
+// recoverWAL replays WAL files older than the active one, left by a crash, into
+// the families' MemTables. Each record is re-logged to the active WAL and applied
+// as a whole; a record torn by the crash is skipped entirely, and entries of
+// dropped families are discarded.
+func (t *LSMTree) recoverWAL() error {
+	dirs, err := os.ReadDir(t.opts.Dir)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	for _, file := range dirs {
+		if version, err := walPkg.VersionFromFileName(file.Name()); err == nil {
+			if !file.IsDir() && t.wal.CompareVersion(version) > 0 {
+				files = append(files, file.Name())
+			}
+		}
+	}
+	slices.Sort(files)
+
+	for _, file := range files {
+		walFile, err := walPkg.Open(filepath.Join(t.opts.Dir, file))
+		if err != nil {
+			return err
+		}
+
+		batches, err := walFile.ReadBatches()
+		if err != nil {
+			return err
+		}
+
+		for _, batch := range batches {
+			batch = slices.DeleteFunc(batch, func(e *entry.Entry) bool {
+				_, ok := t.familyIDs[e.Family]
+				return !ok
+			})
+			if len(batch) == 0 {
+				continue
+			}
+			if err := t.wal.Write(batch...); err != nil {
+				return err
+			}
+			if err := t.apply(batch); err != nil {
+				return err
+			}
+		}
+
+		// The replayed records must be durable before their old copy goes.
+		if err := t.wal.Sync(); err != nil {
+			return err
+		}
+		if err := walFile.Delete(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // sstLevel parses the level from an SSTable filename "sst-{level}-{ts}-{ns}.sst".
 var sstPattern = regexp.MustCompile(`^sst-(\d+)-\d+-\d+\.sst$`)
 
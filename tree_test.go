@@ -2,9 +2,11 @@ package lmstree
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"testing"
+	"time"
 )
 
 func tempDir(t *testing.T) string {
@@ -181,6 +183,33 @@ func TestLSMTree_CloseFlushesAndClosesWAL(t *testing.T) {
 	}
 }
 
+func TestLSMTree_RecoversLegacyWAL(t *testing.T) {
+	// A WAL left by a release that wrote bare entries: a=1, b=2, c=3, a
+	// deleted, b=22.
+	dir := tempDir(t)
+	data, err := os.ReadFile("internal/wal/testdata/wal-20240101000000-5000000.log")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if err := os.WriteFile(dir+"/wal-20240101000000-5000000.log", data, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	tree, err := Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+	for key, want := range map[string]string{"b": "22", "c": "3"} {
+		if val, ok := tree.Get([]byte(key)); !ok || string(val) != want {
+			t.Errorf("Get(%s) = %q, %v, want %q", key, val, ok, want)
+		}
+	}
+	if val, ok := tree.Get([]byte("a")); ok {
+		t.Errorf("Get(a) = %q, want deleted", val)
+	}
+}
+
 func TestLSMTree_CascadeCompaction(t *testing.T) {
 	opts := DefaultOptions(tempDir(t))
 	opts.MemTableSize = 1   // flush on every Put
@@ -209,10 +238,10 @@ func TestLSMTree_CascadeCompaction(t *testing.T) {
 		t.Fatalf("Close: %v", err)
 	}
 
-	// tree.levels is still readable after Close (slices intact, readers closed).
+	// tree.defaultCF.levels is still readable after Close (slices intact, readers closed).
 	hasDeepLevel := false
 	for i := 2; i < opts.MaxLevels; i++ {
-		if len(tree.levels[i]) > 0 {
+		if len(tree.defaultCF.levels[i]) > 0 {
 			hasDeepLevel = true
 			break
 		}
@@ -239,3 +268,392 @@ func TestLSMTree_CascadeCompaction(t *testing.T) {
 		}
 	}
 }
+
+func TestLSMTree_ConditionalWrites(t *testing.T) {
+	dir := tempDir(t)
+	tree, err := Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	check := func(name string, got bool, err error, want bool) {
+		t.Helper()
+		if err != nil || got != want {
+			t.Fatalf("%s = (%v, %v), want %v", name, got, err, want)
+		}
+	}
+
+	ok, err := tree.PutIfAbsent([]byte("lease"), []byte("node-1"))
+	check("PutIfAbsent on missing key", ok, err, true)
+	ok, err = tree.PutIfAbsent([]byte("lease"), []byte("node-2"))
+	check("PutIfAbsent on existing key", ok, err, false)
+	ok, err = tree.CompareAndSwap([]byte("lease"), []byte("node-2"), []byte("node-3"))
+	check("CompareAndSwap with stale expected", ok, err, false)
+	ok, err = tree.CompareAndSwap([]byte("lease"), nil, []byte("node-3"))
+	check("CompareAndSwap expecting absent", ok, err, false)
+	tree.Close()
+
+	// The current value now lives in an SSTable; the read must still see it.
+	tree, err = Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer tree.Close()
+
+	ok, err = tree.CompareAndSwap([]byte("lease"), []byte("node-1"), []byte("node-2"))
+	check("CompareAndSwap against SSTable value", ok, err, true)
+	ok, err = tree.DeleteIf([]byte("lease"), []byte("node-1"))
+	check("DeleteIf with stale expected", ok, err, false)
+	ok, err = tree.DeleteIf([]byte("lease"), []byte("node-2"))
+	check("DeleteIf", ok, err, true)
+	if _, found := tree.Get([]byte("lease")); found {
+		t.Fatal("Get after DeleteIf: key still present")
+	}
+	ok, err = tree.PutIfAbsent([]byte("lease"), []byte("node-4"))
+	check("PutIfAbsent after delete", ok, err, true)
+}
+
+func TestLSMTree_PutIfAbsentElectsOneLeader(t *testing.T) {
+	tree, err := Open(DefaultOptions(tempDir(t)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	const candidates = 16
+	wins := make(chan string, candidates)
+	done := make(chan struct{})
+	for i := 0; i < candidates; i++ {
+		go func(id string) {
+			defer func() { done <- struct{}{} }()
+			if ok, _ := tree.PutIfAbsent([]byte("leader"), []byte(id)); ok {
+				wins <- id
+			}
+		}(fmt.Sprintf("node-%d", i))
+	}
+	for i := 0; i < candidates; i++ {
+		<-done
+	}
+	close(wins)
+
+	if len(wins) != 1 {
+		t.Fatalf("%d candidates won the election, want 1", len(wins))
+	}
+	leader := <-wins
+	if got, _ := tree.Get([]byte("leader")); string(got) != leader {
+		t.Fatalf("leader = %q, want %q", got, leader)
+	}
+}
+
+func TestLSMTree_PutWithTTL(t *testing.T) {
+	tree, err := Open(DefaultOptions(tempDir(t)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	if err := tree.PutWithTTL([]byte("k"), []byte("v"), 0); err != ErrInvalidTTL {
+		t.Fatalf("PutWithTTL(0) = %v, want ErrInvalidTTL", err)
+	}
+
+	tree.Put([]byte("session"), []byte("old"))
+	tree.PutWithTTL([]byte("session"), []byte("short"), 10*time.Millisecond)
+	tree.PutWithTTL([]byte("sticky"), []byte("long"), time.Hour)
+
+	if got, ok := tree.Get([]byte("session")); !ok || string(got) != "short" {
+		t.Fatalf("Get before expiry = (%q, %v), want short", got, ok)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// An expired value reads as deleted; it does not uncover the older one.
+	if got, ok := tree.Get([]byte("session")); ok {
+		t.Fatalf("Get after expiry = %q, want not found", got)
+	}
+	it := tree.Scan(nil, nil)
+	if !it.Valid() || string(it.Key()) != "sticky" {
+		t.Fatalf("Scan first key = %q, want sticky", it.Key())
+	}
+	if it.Next(); it.Valid() {
+		t.Fatalf("Scan: unexpected key %q after sticky", it.Key())
+	}
+	if it.SeekToLast(); !it.Valid() || string(it.Key()) != "sticky" {
+		t.Fatalf("SeekToLast = %q, want sticky", it.Key())
+	}
+	it.Close()
+
+	if ok, _ := tree.PutIfAbsent([]byte("session"), []byte("new")); !ok {
+		t.Fatal("PutIfAbsent on expired key: expected the write to happen")
+	}
+}
+
+func TestLSMTree_CompactionDropsExpired(t *testing.T) {
+	dir := tempDir(t)
+	opts := DefaultOptions(dir)
+	opts.L0CompactThresh = 2
+
+	for session := 0; session < 2; session++ {
+		tree, err := Open(opts)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		for i := 0; i < 50; i++ {
+			key := []byte(fmt.Sprintf("key%02d-%d", i, session))
+			if i%2 == 0 {
+				tree.PutWithTTL(key, bytes.Repeat([]byte("x"), 100), time.Millisecond)
+			} else {
+				tree.Put(key, []byte("v"))
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		tree.Close() // the second close reaches L0CompactThresh and compacts to L1
+	}
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer tree.Close()
+
+	if len(tree.defaultCF.levels[0]) != 0 || len(tree.defaultCF.levels[1]) != 1 {
+		t.Fatalf("levels = %d L0, %d L1 files, want 0 and 1", len(tree.defaultCF.levels[0]), len(tree.defaultCF.levels[1]))
+	}
+	entries, err := tree.defaultCF.levels[1][0].reader.Entries()
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if len(entries) != 50 {
+		t.Fatalf("L1 holds %d entries, want the 50 unexpired ones", len(entries))
+	}
+	for _, e := range entries {
+		if e.ExpiresAt != 0 || e.Tombstone {
+			t.Fatalf("L1 kept expired entry %q", e.Key)
+		}
+	}
+}
+
+func TestLSMTree_DeleteRange(t *testing.T) {
+	dir := tempDir(t)
+	opts := DefaultOptions(dir)
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := tree.DeleteRange([]byte("b"), []byte("a")); err != ErrInvalidRange {
+		t.Fatalf("DeleteRange(b, a) = %v, want ErrInvalidRange", err)
+	}
+	for i := 0; i < 10; i++ {
+		tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte("v"))
+	}
+	tree.Close() // the keys move to an SSTable, below the range tombstone
+
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	snap := tree.NewSnapshot()
+	if err := tree.DeleteRange([]byte("key3"), []byte("key7")); err != nil {
+		t.Fatalf("DeleteRange: %v", err)
+	}
+	tree.Put([]byte("key5"), []byte("new")) // written after the range tombstone
+
+	want := "[key0 key1 key2 key5 key7 key8 key9]"
+	check := func(stage string) {
+		t.Helper()
+		for i := 0; i < 10; i++ {
+			key := []byte(fmt.Sprintf("key%d", i))
+			_, ok := tree.Get(key)
+			if deleted := i >= 3 && i < 7 && i != 5; ok == deleted {
+				t.Fatalf("%s: Get(%s) found = %v, want %v", stage, key, ok, !deleted)
+			}
+		}
+		it := tree.Scan(nil, nil)
+		keys, _ := collect(t, it)
+		if fmt.Sprint(keys) != want {
+			t.Fatalf("%s: Scan = %v, want %s", stage, keys, want)
+		}
+		var reversed []string
+		for it.SeekToLast(); it.Valid(); it.Prev() {
+			reversed = append([]string{string(it.Key())}, reversed...)
+		}
+		if fmt.Sprint(reversed) != want {
+			t.Fatalf("%s: reverse Scan = %v, want %s", stage, reversed, want)
+		}
+		it.Close()
+	}
+	check("memtable")
+
+	if got, ok := tree.GetAt(snap, []byte("key4")); !ok || string(got) != "v" {
+		t.Fatalf("GetAt(snapshot, key4) = (%q, %v), want v", got, ok)
+	}
+	snap.Release()
+
+	tree.Close()
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer tree.Close()
+	check("sstable")
+}
+
+func TestLSMTree_CompactionDropsRangeDeleted(t *testing.T) {
+	dir := tempDir(t)
+	opts := DefaultOptions(dir)
+	opts.L0CompactThresh = 2
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		tree.Put([]byte(fmt.Sprintf("key%02d", i)), []byte("v"))
+	}
+	tree.Close()
+
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	tree.DeleteRange([]byte("key10"), []byte("key40"))
+	tree.Close() // the second L0 table triggers a compaction to L1
+
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	cf := tree.defaultCF
+	if len(cf.levels[0]) != 0 || len(cf.levels[1]) != 1 {
+		t.Fatalf("levels = %d L0, %d L1 files, want 0 and 1", len(cf.levels[0]), len(cf.levels[1]))
+	}
+	entries, err := cf.levels[1][0].reader.Entries()
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if len(entries) != 20 || len(cf.levels[1][0].reader.RangeTombstones()) != 0 {
+		t.Fatalf("L1 holds %d entries and %d range tombstones, want 20 and none",
+			len(entries), len(cf.levels[1][0].reader.RangeTombstones()))
+	}
+
+	// Park the L1 table a level deeper: a range tombstone compacted above it
+	// drops the whole file without reading it.
+	deep := cf.levels[1][0]
+	tree.mu.Lock()
+	cf.levels[2], cf.levels[1] = cf.levels[1], nil
+	cf.opts.L0CompactThresh = 1
+	tree.mu.Unlock()
+	tree.DeleteRange([]byte("key"), []byte("kez"))
+	tree.Put([]byte("other"), []byte("v"))
+	tree.Close() // flushes and compacts the tombstone into L1
+
+	if _, err := os.Stat(deep.path); !os.IsNotExist(err) {
+		t.Fatalf("covered table %s still exists (err %v)", deep.path, err)
+	}
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer tree.Close()
+	keys, _ := collect(t, tree.Scan(nil, nil))
+	if fmt.Sprint(keys) != "[other]" {
+		t.Fatalf("Scan = %v, want [other]", keys)
+	}
+}
+
+func TestLSMTree_ContextCancellation(t *testing.T) {
+	dir := tempDir(t)
+	tree, err := Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	tree.Put([]byte("k"), []byte("v"))
+	tree.Close() // k now lives in an SSTable
+
+	tree, err = Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer tree.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := tree.PutContext(ctx, []byte("k"), []byte("new")); err != context.Canceled {
+		t.Fatalf("PutContext = %v, want context.Canceled", err)
+	}
+	if err := tree.DeleteContext(ctx, []byte("k")); err != context.Canceled {
+		t.Fatalf("DeleteContext = %v, want context.Canceled", err)
+	}
+	if _, _, err := tree.GetContext(ctx, []byte("k")); err != context.Canceled {
+		t.Fatalf("GetContext = %v, want context.Canceled before probing the SSTable", err)
+	}
+	got, ok, err := tree.GetContext(context.Background(), []byte("k"))
+	if err != nil || !ok || string(got) != "v" {
+		t.Fatalf("GetContext = (%q, %v, %v), want the unchanged value v", got, ok, err)
+	}
+}
+
+func TestLSMTree_WriteStall(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.MemTableSize = 1024
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	// Pretend a flush is in flight so the MemTable cannot rotate out.
+	done := make(chan struct{})
+	tree.mu.Lock()
+	tree.flushing, tree.flushDone = true, done
+	tree.mu.Unlock()
+	for i := 0; tree.defaultCF.memTable.Size() < stallFactor*opts.MemTableSize; i++ {
+		tree.Put([]byte(fmt.Sprintf("key%02d", i)), bytes.Repeat([]byte("x"), 100))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := tree.PutContext(ctx, []byte("stalled"), []byte("v")); err != context.DeadlineExceeded {
+		t.Fatalf("stalled PutContext = %v, want context.DeadlineExceeded", err)
+	}
+	if _, ok := tree.Get([]byte("stalled")); ok {
+		t.Fatal("a write that gave up was applied")
+	}
+
+	result := make(chan error, 1)
+	go func() { result <- tree.PutContext(context.Background(), []byte("stalled"), []byte("v")) }()
+	tree.mu.Lock()
+	tree.flushing = false
+	close(done)
+	tree.mu.Unlock()
+	if err := <-result; err != nil {
+		t.Fatalf("PutContext after the flush finished = %v", err)
+	}
+}
+
+func TestLSMTree_CloseContextKeepsWAL(t *testing.T) {
+	dir := tempDir(t)
+	tree, err := Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	tree.Put([]byte("k"), []byte("v"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := tree.CloseContext(ctx); err != nil && err != context.Canceled {
+		t.Fatalf("CloseContext = %v, want nil or context.Canceled", err)
+	}
+
+	// Nothing was flushed; the value comes back from the WAL.
+	tree, err = Open(DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer tree.Close()
+	if len(tree.defaultCF.levels[0]) != 0 {
+		t.Fatalf("CloseContext flushed %d SSTables, want none", len(tree.defaultCF.levels[0]))
+	}
+	if got, ok := tree.Get([]byte("k")); !ok || string(got) != "v" {
+		t.Fatalf("Get after reopen = (%q, %v), want v", got, ok)
+	}
+}
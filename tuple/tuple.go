@@ -0,0 +1,315 @@
+// Package tuple encodes composite keys so that bytes.Compare on the encoded keys
+// agrees with the order of the tuples. It lets hierarchical keys such as
+// (tenant, table, row) be stored in an lmstree with the default comparator and
+// scanned by prefix.
+//
+// Each element is a type code followed by its encoding. Elements of different
+// types sort by type code, in this order: nil, []byte, string, nested Tuple,
+// integers, floats, false, true. Strings and byte strings are escaped like the
+// String and Bytes key codecs of package typed.
+package tuple
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/maksymus/lmstree/internal/keyenc"
+)
+
+// ErrInvalidTuple is returned by Unpack for bytes that are not a packed tuple.
+var ErrInvalidTuple = errors.New("tuple: invalid encoding")
+
+// Type codes.
+const (
+	codeNil     byte = 0x00
+	codeBytes   byte = 0x01
+	codeString  byte = 0x02
+	codeNested  byte = 0x05
+	codeIntZero byte = 0x14 // 0x0c-0x13 negative, 0x15-0x1c positive, by byte length
+	codeFloat   byte = 0x21
+	codeFalse   byte = 0x26
+	codeTrue    byte = 0x27
+)
+
+// escape follows a nil element's 0x00 inside a nested tuple, to tell it from the
+// tuple's terminator.
+const escape byte = 0xff
+
+// Tuple is an ordered list of elements. An element is nil, a bool, a signed or
+// unsigned integer up to 64 bits (unsigned ones no larger than math.MaxInt64), a
+// float32 or float64, a string, a []byte or a nested Tuple.
+//
+// Integers of every width compare by value and unpack as int64; floats unpack as
+// float64. Strings and byte strings compare bytewise, a shorter one before every
+// longer one it is a prefix of, and so do tuples element by element. Floats are
+// ordered by value, with -0 before +0 and NaNs at the ends.
+type Tuple []any
+
+// Pack encodes t.
+func (t Tuple) Pack() ([]byte, error) {
+	return t.append(nil, false)
+}
+
+// append appends the encoding of t's elements to dst. Inside a nested tuple a nil
+// element is escaped, since a bare 0x00 ends the tuple.
+func (t Tuple) append(dst []byte, nested bool) ([]byte, error) {
+	for _, e := range t {
+		var err error
+		if dst, err = appendElement(dst, e, nested); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+func appendElement(dst []byte, e any, nested bool) ([]byte, error) {
+	switch v := e.(type) {
+	case nil:
+		if nested {
+			return append(dst, codeNil, escape), nil
+		}
+		return append(dst, codeNil), nil
+	case bool:
+		if v {
+			return append(dst, codeTrue), nil
+		}
+		return append(dst, codeFalse), nil
+	case int:
+		return appendInt(dst, int64(v)), nil
+	case int8:
+		return appendInt(dst, int64(v)), nil
+	case int16:
+		return appendInt(dst, int64(v)), nil
+	case int32:
+		return appendInt(dst, int64(v)), nil
+	case int64:
+		return appendInt(dst, v), nil
+	case uint8:
+		return appendInt(dst, int64(v)), nil
+	case uint16:
+		return appendInt(dst, int64(v)), nil
+	case uint32:
+		return appendInt(dst, int64(v)), nil
+	case uint:
+		return appendUint(dst, uint64(v))
+	case uint64:
+		return appendUint(dst, v)
+	case float32:
+		return appendFloat(dst, float64(v)), nil
+	case float64:
+		return appendFloat(dst, v), nil
+	case string:
+		return keyenc.AppendEscaped(append(dst, codeString), []byte(v)), nil
+	case []byte:
+		return keyenc.AppendEscaped(append(dst, codeBytes), v), nil
+	case Tuple:
+		dst, err := v.append(append(dst, codeNested), true)
+		if err != nil {
+			return nil, err
+		}
+		return append(dst, 0x00), nil
+	}
+	return nil, fmt.Errorf("tuple: unsupported element type %T", e)
+}
+
+// appendInt writes v in as few big-endian bytes as it needs, the code telling the
+// length: codeIntZero+n for a positive v of n bytes, codeIntZero-n for a negative
+// one, stored as the ones' complement of its magnitude so that larger magnitudes
+// sort first.
+func appendInt(dst []byte, v int64) []byte {
+	if v == 0 {
+		return append(dst, codeIntZero)
+	}
+	if v > 0 {
+		return appendMagnitude(dst, uint64(v), false)
+	}
+	// -v overflows for math.MinInt64, but its uint64 conversion is still right.
+	return appendMagnitude(dst, uint64(-v), true)
+}
+
+func appendUint(dst []byte, v uint64) ([]byte, error) {
+	if v > math.MaxInt64 {
+		return nil, fmt.Errorf("tuple: integer %d overflows int64", v)
+	}
+	return appendInt(dst, int64(v)), nil
+}
+
+func appendMagnitude(dst []byte, m uint64, negative bool) []byte {
+	n := 8
+	for n > 1 && m>>(8*(n-1)) == 0 {
+		n--
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], m)
+	if !negative {
+		return append(append(dst, codeIntZero+byte(n)), buf[8-n:]...)
+	}
+	dst = append(dst, codeIntZero-byte(n))
+	for _, c := range buf[8-n:] {
+		dst = append(dst, ^c)
+	}
+	return dst
+}
+
+// appendFloat writes the IEEE 754 bits of v big-endian, with the sign bit flipped
+// for a positive v and every bit flipped for a negative one.
+func appendFloat(dst []byte, v float64) []byte {
+	bits := math.Float64bits(v)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(append(dst, codeFloat), bits)
+}
+
+// Unpack decodes a packed tuple.
+func Unpack(data []byte) (Tuple, error) {
+	t, rest, err := decode(data, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, ErrInvalidTuple
+	}
+	return t, nil
+}
+
+// UnpackNested decodes the tuple t at the start of data packed as the only
+// element of another, Tuple{t}.Pack(), and returns the bytes after it. Unlike
+// the output of Pack, that encoding is self-delimiting.
+func UnpackNested(data []byte) (Tuple, []byte, error) {
+	if len(data) == 0 || data[0] != codeNested {
+		return nil, nil, ErrInvalidTuple
+	}
+	return decode(data[1:], true)
+}
+
+// decode decodes elements up to the end of data or, for a nested tuple, up to its
+// terminator, and returns the bytes after them.
+func decode(data []byte, nested bool) (Tuple, []byte, error) {
+	t := Tuple{}
+	for len(data) > 0 {
+		code := data[0]
+		data = data[1:]
+		switch {
+		case code == codeNil:
+			if !nested {
+				t = append(t, nil)
+				continue
+			}
+			if len(data) > 0 && data[0] == escape {
+				t = append(t, nil)
+				data = data[1:]
+				continue
+			}
+			return t, data, nil
+		case code == codeBytes || code == codeString:
+			b, rest, ok := keyenc.DecodeEscaped(data)
+			if !ok {
+				return nil, nil, ErrInvalidTuple
+			}
+			if code == codeString {
+				t = append(t, string(b))
+			} else {
+				t = append(t, b)
+			}
+			data = rest
+		case code == codeNested:
+			inner, rest, err := decode(data, true)
+			if err != nil {
+				return nil, nil, err
+			}
+			t = append(t, inner)
+			data = rest
+		case code >= codeIntZero-8 && code <= codeIntZero+8:
+			v, rest, err := decodeInt(code, data)
+			if err != nil {
+				return nil, nil, err
+			}
+			t = append(t, v)
+			data = rest
+		case code == codeFloat:
+			if len(data) < 8 {
+				return nil, nil, ErrInvalidTuple
+			}
+			bits := binary.BigEndian.Uint64(data)
+			if bits&(1<<63) != 0 {
+				bits &^= 1 << 63
+			} else {
+				bits = ^bits
+			}
+			t = append(t, math.Float64frombits(bits))
+			data = data[8:]
+		case code == codeFalse:
+			t = append(t, false)
+		case code == codeTrue:
+			t = append(t, true)
+		default:
+			return nil, nil, ErrInvalidTuple
+		}
+	}
+	if nested {
+		return nil, nil, ErrInvalidTuple // no terminator
+	}
+	return t, nil, nil
+}
+
+// decodeInt reverses appendInt for the bytes after code.
+func decodeInt(code byte, data []byte) (int64, []byte, error) {
+	if code == codeIntZero {
+		return 0, data, nil
+	}
+	negative := code < codeIntZero
+	n := int(code) - int(codeIntZero)
+	if negative {
+		n = -n
+	}
+	if len(data) < n {
+		return 0, nil, ErrInvalidTuple
+	}
+	var buf [8]byte
+	copy(buf[8-n:], data[:n])
+	m := binary.BigEndian.Uint64(buf[:])
+	if !negative {
+		if m > math.MaxInt64 {
+			return 0, nil, ErrInvalidTuple
+		}
+		return int64(m), data[n:], nil
+	}
+	m = ^m
+	if n < 8 {
+		m &= 1<<(8*n) - 1
+	}
+	if m > 1<<63 {
+		return 0, nil, ErrInvalidTuple
+	}
+	return -int64(m), data[n:], nil
+}
+
+// Range returns the bounds [start, end) of a scan over every packed tuple that
+// extends t with at least one more element.
+func (t Tuple) Range() (start, end []byte, err error) {
+	prefix, err := t.Pack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(bytes.Clone(prefix), 0x00), append(prefix, 0xff), nil
+}
+
+// PrefixRange returns the bounds [start, end) of a scan over every key starting
+// with prefix. end is nil, unbounded, if prefix is empty or all 0xff bytes. Over
+// a packed tuple, unlike Range, it takes in the tuple itself too.
+func PrefixRange(prefix []byte) (start, end []byte) {
+	end = bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return prefix, end[:i+1]
+		}
+	}
+	return prefix, nil
+}
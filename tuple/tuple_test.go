@@ -0,0 +1,124 @@
+package tuple
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func pack(t *testing.T, tuple Tuple) []byte {
+	t.Helper()
+	data, err := tuple.Pack()
+	if err != nil {
+		t.Fatalf("Pack(%v): %v", tuple, err)
+	}
+	return data
+}
+
+func TestTuple_Order(t *testing.T) {
+	// Ascending tuple order.
+	tuples := []Tuple{
+		{},
+		{nil},
+		{nil, nil},
+		{[]byte{}},
+		{[]byte{0}},
+		{""},
+		{"", int64(1)},
+		{"\x00"},
+		{"\x00", "x"},
+		{"\x00\x00"},
+		{"a"},
+		{"a", nil},
+		{"a", Tuple{}},
+		{"a", Tuple{nil}},
+		{"a", Tuple{nil, "x"}},
+		{"a", Tuple{"\x00"}},
+		{"a", int64(math.MinInt64)},
+		{"a", int64(-1 << 32)},
+		{"a", int64(-256)},
+		{"a", int64(-255)},
+		{"a", int64(-1)},
+		{"a", int64(0)},
+		{"a", int64(1)},
+		{"a", int64(255)},
+		{"a", int64(256)},
+		{"a", int64(math.MaxInt64)},
+		{"a", math.Inf(-1)},
+		{"a", -1.5},
+		{"a", math.Copysign(0, -1)},
+		{"a", 0.0},
+		{"a", 1e-300},
+		{"a", 2.5},
+		{"a", math.Inf(1)},
+		{"a", false},
+		{"a", true},
+		{"a\x00"},
+		{"ab"},
+		{"b"},
+		{int64(-1)},
+		{int64(0), "z"},
+	}
+	for i := 1; i < len(tuples); i++ {
+		if a, b := pack(t, tuples[i-1]), pack(t, tuples[i]); bytes.Compare(a, b) >= 0 {
+			t.Errorf("%v packs to %x, not before %v at %x", tuples[i-1], a, tuples[i], b)
+		}
+	}
+	for _, tuple := range tuples {
+		got, err := Unpack(pack(t, tuple))
+		if err != nil || !reflect.DeepEqual(got, tuple) {
+			t.Errorf("Unpack(Pack(%v)) = %v, %v", tuple, got, err)
+		}
+	}
+}
+
+func TestTuple_Types(t *testing.T) {
+	got, err := Unpack(pack(t, Tuple{7, int8(-7), uint16(7), uint64(7), float32(0.5), []byte("b")}))
+	if err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+	want := Tuple{int64(7), int64(-7), int64(7), int64(7), 0.5, []byte("b")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unpack = %#v, want %#v", got, want)
+	}
+	if _, err := (Tuple{uint64(math.MaxUint64)}).Pack(); err == nil {
+		t.Errorf("Pack of an overflowing uint64: expected error")
+	}
+	if _, err := (Tuple{struct{}{}}).Pack(); err == nil {
+		t.Errorf("Pack of a struct: expected error")
+	}
+	for _, data := range [][]byte{{0x02, 'a'}, {0x05, 0x02, 'a', 0x00}, {0x16, 1}, {0x21, 0, 0}, {0xfe}} {
+		if _, err := Unpack(data); !errors.Is(err, ErrInvalidTuple) {
+			t.Errorf("Unpack(%x) error = %v, want ErrInvalidTuple", data, err)
+		}
+	}
+}
+
+func TestTuple_Range(t *testing.T) {
+	tenant := Tuple{"acme", "orders"}
+	start, end, err := tenant.Range()
+	if err != nil {
+		t.Fatalf("Range: %v", err)
+	}
+	in := func(key []byte) bool { return bytes.Compare(key, start) >= 0 && bytes.Compare(key, end) < 0 }
+	for _, tuple := range []Tuple{{"acme", "orders", int64(1)}, {"acme", "orders", nil}, {"acme", "orders", "x", true}} {
+		if !in(pack(t, tuple)) {
+			t.Errorf("%v outside Range of %v", tuple, tenant)
+		}
+	}
+	for _, tuple := range []Tuple{tenant, {"acme", "orders\x00"}, {"acme", "ordersx"}, {"acme"}, {"acmf"}} {
+		if in(pack(t, tuple)) {
+			t.Errorf("%v inside Range of %v", tuple, tenant)
+		}
+	}
+
+	start, end = PrefixRange(pack(t, tenant))
+	if !bytes.Equal(start, pack(t, tenant)) || bytes.Compare(pack(t, Tuple{"acme", "orders", true}), end) >= 0 {
+		t.Errorf("PrefixRange = [%x, %x)", start, end)
+	}
+	if _, end := PrefixRange([]byte{0xff, 0xff}); end != nil {
+		t.Errorf("PrefixRange(ff ff) end = %x, want unbounded", end)
+	}
+}
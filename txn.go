@@ -0,0 +1,194 @@
+package lmstree
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"github.com/maksymus/lmstree/entry"
+	"github.com/maksymus/lmstree/internal/lock"
+)
+
+var (
+	// ErrConflict is returned by Txn.Commit when a key the transaction read was
+	// changed by another writer after the transaction began.
+	ErrConflict = errors.New("lmstree: transaction conflict")
+	// ErrTxnDone is returned when a transaction is used after Commit or Rollback.
+	ErrTxnDone = errors.New("lmstree: transaction already committed or rolled back")
+	// ErrLockTimeout is returned when a pessimistic transaction cannot lock a key
+	// within Options.LockTimeout.
+	ErrLockTimeout = lock.ErrTimeout
+	// ErrDeadlock is returned to the pessimistic transaction whose lock request
+	// would complete a wait-for cycle. It should roll back and retry.
+	ErrDeadlock = lock.ErrDeadlock
+)
+
+// Txn is a transaction. Writes are buffered, visible to the transaction's own
+// reads, and applied atomically by Commit as one WAL record.
+//
+// An optimistic Txn (Begin) reads the tree as of Begin and takes no locks; Commit
+// fails with ErrConflict if a key the transaction read has since been changed, and
+// the caller is expected to retry.
+//
+// A pessimistic Txn (BeginPessimistic) locks keys as it goes: Put and Delete take
+// an exclusive lock, GetForUpdate a shared or exclusive one, all held until Commit
+// or Rollback. Its reads see the latest committed state and Commit never
+// conflicts. A failed lock request (ErrLockTimeout, ErrDeadlock) leaves the
+// transaction open; it should normally be rolled back. Plain LSMTree writes do
+// not take locks.
+//
+// A Txn is not safe for concurrent use.
+type Txn struct {
+	tree        *LSMTree
+	id          uint64
+	pessimistic bool
+	snapshot    *Snapshot // optimistic only
+	batch       WriteBatch
+	writes      map[string]*entry.Entry // latest buffered write per key
+	reads       map[string]struct{}     // keys read from the tree
+	done        bool
+}
+
+// Begin starts an optimistic transaction.
+func (t *LSMTree) Begin() *Txn {
+	return &Txn{
+		tree:     t,
+		id:       t.txnID.Add(1),
+		snapshot: t.NewSnapshot(),
+		writes:   make(map[string]*entry.Entry),
+		reads:    make(map[string]struct{}),
+	}
+}
+
+// BeginPessimistic starts a lock-based transaction.
+func (t *LSMTree) BeginPessimistic() *Txn {
+	return &Txn{
+		tree:        t,
+		id:          t.txnID.Add(1),
+		pessimistic: true,
+		writes:      make(map[string]*entry.Entry),
+	}
+}
+
+// Get returns the transaction's own pending write for key if there is one,
+// otherwise the value as of Begin (optimistic) or the latest committed value
+// (pessimistic, without locking). An optimistic Txn records the key for conflict
+// detection.
+func (txn *Txn) Get(key []byte) ([]byte, bool) {
+	if txn.done {
+		return nil, false
+	}
+	if e, ok := txn.writes[string(key)]; ok {
+		if e.Tombstone {
+			return nil, false
+		}
+		return e.Value, true
+	}
+	if txn.pessimistic {
+		return txn.tree.Get(key)
+	}
+	txn.reads[string(key)] = struct{}{}
+	return txn.tree.GetAt(txn.snapshot, key)
+}
+
+// GetForUpdate is Get for a key the transaction intends to act on. A pessimistic
+// Txn first locks key, exclusively or shared, so the value read cannot change
+// before Commit; an optimistic Txn behaves exactly like Get.
+func (txn *Txn) GetForUpdate(key []byte, exclusive bool) ([]byte, bool, error) {
+	if txn.done {
+		return nil, false, ErrTxnDone
+	}
+	if txn.pessimistic {
+		mode := lock.Shared
+		if exclusive {
+			mode = lock.Exclusive
+		}
+		if err := txn.lock(key, mode); err != nil {
+			return nil, false, err
+		}
+	}
+	value, ok := txn.Get(key)
+	return value, ok, nil
+}
+
+// Put buffers key → value until Commit.
+func (txn *Txn) Put(key, value []byte) error {
+	if txn.done {
+		return ErrTxnDone
+	}
+	if err := txn.lock(key, lock.Exclusive); err != nil {
+		return err
+	}
+	txn.batch.Put(key, value)
+	txn.writes[string(key)] = txn.batch.entries[len(txn.batch.entries)-1]
+	return nil
+}
+
+// Delete buffers a deletion of key until Commit.
+func (txn *Txn) Delete(key []byte) error {
+	if txn.done {
+		return ErrTxnDone
+	}
+	if err := txn.lock(key, lock.Exclusive); err != nil {
+		return err
+	}
+	txn.batch.Delete(key)
+	txn.writes[string(key)] = txn.batch.entries[len(txn.batch.entries)-1]
+	return nil
+}
+
+// Commit applies the buffered writes as one atomic batch. An optimistic Txn first
+// validates that no key it read has a version newer than Begin. The transaction
+// is finished, and its locks released, either way.
+func (txn *Txn) Commit() error {
+	if txn.done {
+		return ErrTxnDone
+	}
+	defer txn.finish()
+
+	t := txn.tree
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ctx := context.Background()
+	if err := t.stall(ctx); err != nil {
+		return err
+	}
+	for key := range txn.reads {
+		if e, ok, _ := t.defaultCF.getEntryAt(ctx, []byte(key), math.MaxUint64); ok && e.Seq > txn.snapshot.seq {
+			return ErrConflict
+		}
+		if t.defaultCF.rangeDeletedAt([]byte(key), math.MaxUint64) > txn.snapshot.seq {
+			return ErrConflict
+		}
+	}
+	if txn.batch.Count() == 0 {
+		return nil
+	}
+	return t.writeLocked(txn.batch.entries...)
+}
+
+// Rollback discards the buffered writes. Calling it after Commit is a no-op.
+func (txn *Txn) Rollback() {
+	if !txn.done {
+		txn.finish()
+	}
+}
+
+// lock takes key in mode for a pessimistic transaction; optimistic ones lock nothing.
+func (txn *Txn) lock(key []byte, mode lock.Mode) error {
+	if !txn.pessimistic {
+		return nil
+	}
+	return txn.tree.locks.Acquire(txn.id, key, mode, txn.tree.opts.LockTimeout)
+}
+
+// finish marks the transaction done and releases its snapshot or locks.
+func (txn *Txn) finish() {
+	txn.done = true
+	if txn.pessimistic {
+		txn.tree.locks.ReleaseAll(txn.id)
+	} else {
+		txn.snapshot.Release()
+	}
+}
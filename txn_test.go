@@ -0,0 +1,226 @@
+package lmstree
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTxn_ReadYourWritesAndCommit(t *testing.T) {
+	tree, err := Open(DefaultOptions(tempDir(t)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	tree.Put([]byte("a"), []byte("1"))
+	tree.Put([]byte("b"), []byte("2"))
+
+	txn := tree.Begin()
+	txn.Put([]byte("a"), []byte("10"))
+	txn.Delete([]byte("b"))
+
+	if got, ok := txn.Get([]byte("a")); !ok || !bytes.Equal(got, []byte("10")) {
+		t.Fatalf("txn.Get a = (%q, %v), want 10", got, ok)
+	}
+	if _, ok := txn.Get([]byte("b")); ok {
+		t.Fatal("txn.Get b: expected own delete to hide it")
+	}
+	// Nothing is visible outside the transaction before Commit.
+	if got, _ := tree.Get([]byte("a")); !bytes.Equal(got, []byte("1")) {
+		t.Fatalf("tree.Get a before commit = %q, want 1", got)
+	}
+
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if got, _ := tree.Get([]byte("a")); !bytes.Equal(got, []byte("10")) {
+		t.Fatalf("tree.Get a after commit = %q, want 10", got)
+	}
+	if _, ok := tree.Get([]byte("b")); ok {
+		t.Fatal("tree.Get b after commit: expected deleted")
+	}
+	if err := txn.Commit(); !errors.Is(err, ErrTxnDone) {
+		t.Fatalf("second Commit = %v, want ErrTxnDone", err)
+	}
+}
+
+func TestTxn_ConflictOnChangedRead(t *testing.T) {
+	tree, err := Open(DefaultOptions(tempDir(t)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	tree.Put([]byte("balance"), []byte("100"))
+
+	txn := tree.Begin()
+	txn.Get([]byte("balance"))
+	txn.Get([]byte("absent"))
+	txn.Put([]byte("balance"), []byte("50"))
+
+	blind := tree.Begin() // writes without reading never conflict
+	blind.Put([]byte("other"), []byte("x"))
+
+	tree.Put([]byte("balance"), []byte("200"))
+
+	if err := txn.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Commit = %v, want ErrConflict", err)
+	}
+	if got, _ := tree.Get([]byte("balance")); !bytes.Equal(got, []byte("200")) {
+		t.Fatalf("balance = %q, want the concurrent writer's 200", got)
+	}
+	if err := blind.Commit(); err != nil {
+		t.Fatalf("blind Commit: %v", err)
+	}
+
+	// A key that did not exist at Begin conflicts once someone creates it.
+	txn = tree.Begin()
+	txn.Get([]byte("absent"))
+	txn.Put([]byte("x"), []byte("y"))
+	tree.Put([]byte("absent"), []byte("now"))
+	if err := txn.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Commit after phantom insert = %v, want ErrConflict", err)
+	}
+}
+
+func TestTxn_ConcurrentIncrements(t *testing.T) {
+	tree, err := Open(DefaultOptions(tempDir(t)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	tree.Put([]byte("counter"), []byte("0"))
+
+	const workers, increments = 8, 25
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; {
+				txn := tree.Begin()
+				v, _ := txn.Get([]byte("counter"))
+				n, _ := strconv.Atoi(string(v))
+				txn.Put([]byte("counter"), []byte(strconv.Itoa(n+1)))
+				if err := txn.Commit(); err == nil {
+					i++
+				} else if !errors.Is(err, ErrConflict) {
+					t.Errorf("Commit: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, _ := tree.Get([]byte("counter"))
+	if want := strconv.Itoa(workers * increments); string(got) != want {
+		t.Fatalf("counter = %s, want %s", got, want)
+	}
+}
+
+func TestTxn_PessimisticIncrements(t *testing.T) {
+	tree, err := Open(DefaultOptions(tempDir(t)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	tree.Put([]byte("counter"), []byte("0"))
+
+	const workers, increments = 8, 25
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				txn := tree.BeginPessimistic()
+				v, _, err := txn.GetForUpdate([]byte("counter"), true)
+				if err != nil {
+					t.Errorf("GetForUpdate: %v", err)
+					txn.Rollback()
+					return
+				}
+				n, _ := strconv.Atoi(string(v))
+				txn.Put([]byte("counter"), []byte(strconv.Itoa(n+1)))
+				if err := txn.Commit(); err != nil {
+					t.Errorf("Commit: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, _ := tree.Get([]byte("counter"))
+	if want := strconv.Itoa(workers * increments); string(got) != want {
+		t.Fatalf("counter = %s, want %s", got, want)
+	}
+}
+
+func TestTxn_PessimisticDeadlockAndTimeout(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.LockTimeout = 50 * time.Millisecond
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	a := tree.BeginPessimistic()
+	b := tree.BeginPessimistic()
+	if err := a.Put([]byte("x"), []byte("a")); err != nil {
+		t.Fatalf("a.Put x: %v", err)
+	}
+	if err := b.Put([]byte("y"), []byte("b")); err != nil {
+		t.Fatalf("b.Put y: %v", err)
+	}
+
+	// b waits on a's lock; with no cycle it times out.
+	if _, _, err := b.GetForUpdate([]byte("x"), false); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("b.GetForUpdate x = %v, want ErrLockTimeout", err)
+	}
+
+	// a waiting on y while b waits on x is a cycle: whichever request closes it
+	// is refused, and once that transaction rolls back the other proceeds.
+	tree.opts.LockTimeout = time.Second
+	type result struct {
+		txn *Txn
+		err error
+	}
+	results := make(chan result, 2)
+	go func() { results <- result{a, a.Put([]byte("y"), []byte("a"))} }()
+	go func() { results <- result{b, b.Delete([]byte("x"))} }()
+
+	victim := <-results
+	if !errors.Is(victim.err, ErrDeadlock) {
+		t.Fatalf("first lock request to return = %v, want ErrDeadlock", victim.err)
+	}
+	victim.txn.Rollback()
+	winner := <-results
+	if winner.err != nil {
+		t.Fatalf("lock request after the victim rolled back: %v", winner.err)
+	}
+	if err := winner.txn.Commit(); err != nil {
+		t.Fatalf("winner Commit: %v", err)
+	}
+
+	want := map[string]string{"x": "a", "y": "a"}
+	if winner.txn == b {
+		want = map[string]string{"y": "b"}
+		if _, ok := tree.Get([]byte("x")); ok {
+			t.Error("Get(x): expected b's delete to win")
+		}
+	}
+	for key, v := range want {
+		if got, _ := tree.Get([]byte(key)); string(got) != v {
+			t.Errorf("Get(%s) = %q, want %q", key, got, v)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package typed
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/maksymus/lmstree/internal/keyenc"
+	"github.com/maksymus/lmstree/tuple"
+)
+
+// ErrInvalidKey is returned when stored key bytes cannot be decoded by a Store's
+// KeyCodec.
+var ErrInvalidKey = errors.New("typed: invalid key encoding")
+
+// KeyCodec converts keys of type K to bytes and back. Encodings are
+// order-preserving — bytes.Compare on two encoded keys agrees with the order of
+// the keys — and self-delimiting, so that a decoder finds where a key ends and
+// codecs compose into tuples. Stores built on a KeyCodec need the default
+// bytewise comparator.
+type KeyCodec[K any] interface {
+	// AppendKey appends the encoding of k to dst.
+	AppendKey(dst []byte, k K) []byte
+	// DecodeKey decodes the key at the start of src and returns the bytes after it.
+	DecodeKey(src []byte) (K, []byte, error)
+}
+
+var (
+	// Int64 encodes int64 keys as 8 big-endian bytes with the sign bit flipped,
+	// so negative keys sort before positive ones.
+	Int64 KeyCodec[int64] = int64Codec{}
+	// Uint64 encodes uint64 keys as 8 big-endian bytes.
+	Uint64 KeyCodec[uint64] = uint64Codec{}
+	// String encodes string keys by byte order. Zero bytes are escaped as
+	// 0x00 0xff and the key ends with 0x00 0x01, which sorts it before every
+	// longer key it is a prefix of.
+	String KeyCodec[string] = stringCodec{}
+	// Bytes encodes []byte keys like String.
+	Bytes KeyCodec[[]byte] = bytesCodec{}
+	// Time encodes time.Time keys as their Unix time in nanoseconds, like Int64.
+	// Keys decode in UTC, without monotonic clock reading; times outside the
+	// years 1678 to 2262 do not fit.
+	Time KeyCodec[time.Time] = timeCodec{}
+	// Tuple encodes tuple.Tuple keys as a tuple nesting them, in tuple order.
+	// AppendKey panics on a tuple that tuple.Tuple.Pack rejects.
+	Tuple KeyCodec[tuple.Tuple] = tupleCodec{}
+)
+
+type int64Codec struct{}
+
+func (int64Codec) AppendKey(dst []byte, k int64) []byte {
+	return binary.BigEndian.AppendUint64(dst, uint64(k)^(1<<63))
+}
+
+func (int64Codec) DecodeKey(src []byte) (int64, []byte, error) {
+	if len(src) < 8 {
+		return 0, nil, ErrInvalidKey
+	}
+	return int64(binary.BigEndian.Uint64(src) ^ (1 << 63)), src[8:], nil
+}
+
+type uint64Codec struct{}
+
+func (uint64Codec) AppendKey(dst []byte, k uint64) []byte {
+	return binary.BigEndian.AppendUint64(dst, k)
+}
+
+func (uint64Codec) DecodeKey(src []byte) (uint64, []byte, error) {
+	if len(src) < 8 {
+		return 0, nil, ErrInvalidKey
+	}
+	return binary.BigEndian.Uint64(src), src[8:], nil
+}
+
+type stringCodec struct{}
+
+func (stringCodec) AppendKey(dst []byte, k string) []byte {
+	return keyenc.AppendEscaped(dst, []byte(k))
+}
+
+func (stringCodec) DecodeKey(src []byte) (string, []byte, error) {
+	b, rest, err := decodeEscaped(src)
+	return string(b), rest, err
+}
+
+type bytesCodec struct{}
+
+func (bytesCodec) AppendKey(dst []byte, k []byte) []byte {
+	return keyenc.AppendEscaped(dst, k)
+}
+
+func (bytesCodec) DecodeKey(src []byte) ([]byte, []byte, error) {
+	return decodeEscaped(src)
+}
+
+// decodeEscaped decodes a String or Bytes key.
+func decodeEscaped(src []byte) ([]byte, []byte, error) {
+	b, rest, ok := keyenc.DecodeEscaped(src)
+	if !ok {
+		return nil, nil, ErrInvalidKey
+	}
+	return b, rest, nil
+}
+
+type timeCodec struct{}
+
+func (timeCodec) AppendKey(dst []byte, k time.Time) []byte {
+	return Int64.AppendKey(dst, k.UnixNano())
+}
+
+func (timeCodec) DecodeKey(src []byte) (time.Time, []byte, error) {
+	n, rest, err := Int64.DecodeKey(src)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	return time.Unix(0, n).UTC(), rest, nil
+}
+
+// Pair is a two-part key, ordered by First, then Second.
+type Pair[A, B any] struct {
+	First  A
+	Second B
+}
+
+// Triple is a three-part key, ordered by First, then Second, then Third.
+type Triple[A, B, C any] struct {
+	First  A
+	Second B
+	Third  C
+}
+
+// Tuple2 returns a codec for Pair keys that concatenates the encodings of a and b.
+func Tuple2[A, B any](a KeyCodec[A], b KeyCodec[B]) KeyCodec[Pair[A, B]] {
+	return tuple2Codec[A, B]{a, b}
+}
+
+// Tuple3 returns a codec for Triple keys that concatenates the encodings of a, b
+// and c.
+func Tuple3[A, B, C any](a KeyCodec[A], b KeyCodec[B], c KeyCodec[C]) KeyCodec[Triple[A, B, C]] {
+	return tuple3Codec[A, B, C]{a, b, c}
+}
+
+type tuple2Codec[A, B any] struct {
+	a KeyCodec[A]
+	b KeyCodec[B]
+}
+
+func (c tuple2Codec[A, B]) AppendKey(dst []byte, k Pair[A, B]) []byte {
+	return c.b.AppendKey(c.a.AppendKey(dst, k.First), k.Second)
+}
+
+func (c tuple2Codec[A, B]) DecodeKey(src []byte) (Pair[A, B], []byte, error) {
+	var k Pair[A, B]
+	var err error
+	if k.First, src, err = c.a.DecodeKey(src); err != nil {
+		return k, nil, err
+	}
+	if k.Second, src, err = c.b.DecodeKey(src); err != nil {
+		return k, nil, err
+	}
+	return k, src, nil
+}
+
+type tuple3Codec[A, B, C any] struct {
+	a KeyCodec[A]
+	b KeyCodec[B]
+	c KeyCodec[C]
+}
+
+func (c tuple3Codec[A, B, C]) AppendKey(dst []byte, k Triple[A, B, C]) []byte {
+	return c.c.AppendKey(c.b.AppendKey(c.a.AppendKey(dst, k.First), k.Second), k.Third)
+}
+
+func (c tuple3Codec[A, B, C]) DecodeKey(src []byte) (Triple[A, B, C], []byte, error) {
+	var k Triple[A, B, C]
+	var err error
+	if k.First, src, err = c.a.DecodeKey(src); err != nil {
+		return k, nil, err
+	}
+	if k.Second, src, err = c.b.DecodeKey(src); err != nil {
+		return k, nil, err
+	}
+	if k.Third, src, err = c.c.DecodeKey(src); err != nil {
+		return k, nil, err
+	}
+	return k, src, nil
+}
+
+type tupleCodec struct{}
+
+func (tupleCodec) AppendKey(dst []byte, k tuple.Tuple) []byte {
+	packed, err := tuple.Tuple{k}.Pack()
+	if err != nil {
+		panic(err)
+	}
+	return append(dst, packed...)
+}
+
+func (tupleCodec) DecodeKey(src []byte) (tuple.Tuple, []byte, error) {
+	k, rest, err := tuple.UnpackNested(src)
+	if err != nil {
+		return nil, nil, ErrInvalidKey
+	}
+	return k, rest, nil
+}
+
+// encodeKey returns the encoding of k alone.
+func encodeKey[K any](codec KeyCodec[K], k K) []byte {
+	return codec.AppendKey(nil, k)
+}
+
+// decodeKey decodes a key that must span all of src.
+func decodeKey[K any](codec KeyCodec[K], src []byte) (K, error) {
+	k, rest, err := codec.DecodeKey(src)
+	if err == nil && len(rest) > 0 {
+		err = ErrInvalidKey
+	}
+	return k, err
+}
@@ -0,0 +1,87 @@
+package typed
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/maksymus/lmstree/tuple"
+)
+
+// checkOrder fails unless the encodings of keys, given in ascending order, are
+// ascending too and decode back to the keys.
+func checkOrder[K any](t *testing.T, codec KeyCodec[K], equal func(a, b K) bool, keys ...K) {
+	t.Helper()
+	var prev []byte
+	for i, k := range keys {
+		data := encodeKey(codec, k)
+		if i > 0 && bytes.Compare(prev, data) >= 0 {
+			t.Errorf("encoding of %v does not sort after that of %v", k, keys[i-1])
+		}
+		got, err := decodeKey(codec, data)
+		if err != nil || !equal(got, k) {
+			t.Errorf("decode(encode(%v)) = %v, %v", k, got, err)
+		}
+		prev = data
+	}
+}
+
+func eq[K comparable](a, b K) bool { return a == b }
+
+func TestKeyCodecs_Order(t *testing.T) {
+	checkOrder(t, Int64, eq, math.MinInt64, -256, -1, 0, 1, 255, math.MaxInt64)
+	checkOrder(t, Uint64, eq, 0, 1, 255, 256, math.MaxUint64)
+	checkOrder(t, String, eq, "", "\x00", "\x00\x00", "\x00\x01", "a", "a\x00", "a\x00b", "ab", "b")
+	checkOrder(t, Bytes, bytes.Equal, []byte{}, []byte{0}, []byte{0, 0xff}, []byte{1})
+
+	base := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	checkOrder(t, Time, time.Time.Equal, base.Add(-time.Hour), base, base.Add(time.Nanosecond))
+
+	// The first part decides; a shorter string sorts before a longer one
+	// whatever follows it.
+	checkOrder(t, Tuple2(String, Int64), eq,
+		Pair[string, int64]{"a", 5},
+		Pair[string, int64]{"a\x00", -5},
+		Pair[string, int64]{"ab", -5},
+		Pair[string, int64]{"b", math.MinInt64})
+	checkOrder(t, Tuple3(Uint64, String, Int64), eq,
+		Triple[uint64, string, int64]{1, "x", 1},
+		Triple[uint64, string, int64]{1, "x", 2},
+		Triple[uint64, string, int64]{1, "y", 0},
+		Triple[uint64, string, int64]{2, "", 0})
+
+	// Tuples keep tuple order, and the encoding ends where the tuple does.
+	checkOrder(t, Tuple, tupleEqual,
+		tuple.Tuple{},
+		tuple.Tuple{nil},
+		tuple.Tuple{"a"},
+		tuple.Tuple{"a", int64(-1)},
+		tuple.Tuple{"a", int64(1)},
+		tuple.Tuple{"a\x00", tuple.Tuple{nil}},
+		tuple.Tuple{"b"},
+		tuple.Tuple{int64(0), true})
+	checkOrder(t, Tuple2(Tuple, String), eqPair,
+		Pair[tuple.Tuple, string]{tuple.Tuple{"a"}, "z"},
+		Pair[tuple.Tuple, string]{tuple.Tuple{"a", nil}, "a"},
+		Pair[tuple.Tuple, string]{tuple.Tuple{"b"}, ""})
+}
+
+func tupleEqual(a, b tuple.Tuple) bool { return reflect.DeepEqual(a, b) }
+
+func eqPair(a, b Pair[tuple.Tuple, string]) bool {
+	return tupleEqual(a.First, b.First) && a.Second == b.Second
+}
+
+func TestKeyCodecs_Invalid(t *testing.T) {
+	for _, data := range [][]byte{{1, 2}, {'a', 0}, {'a', 0, 2}, {'a', 0, 1, 'x'}} {
+		if _, err := decodeKey(String, data); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("decode String %v error = %v, want ErrInvalidKey", data, err)
+		}
+	}
+	if _, err := decodeKey(Int64, []byte{1, 2, 3}); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("decode Int64 of 3 bytes error = %v, want ErrInvalidKey", err)
+	}
+}
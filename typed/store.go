@@ -0,0 +1,144 @@
+// Package typed provides Store, a typed view of an lmstree keyspace: keys and
+// values are Go values, converted to bytes by pluggable codecs.
+package typed
+
+import (
+	lmstree "github.com/maksymus/lmstree"
+)
+
+// Keyspace is the part of an LSMTree or ColumnFamily a Store reads and writes.
+type Keyspace interface {
+	Get(key []byte) ([]byte, bool)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	Scan(start, end []byte) *lmstree.Iterator
+}
+
+var (
+	_ Keyspace = (*lmstree.LSMTree)(nil)
+	_ Keyspace = (*lmstree.ColumnFamily)(nil)
+)
+
+// Store maps keys of type K to values of type V in a Keyspace. Scans return keys
+// in K's order, as defined by the KeyCodec. A Store expects every key of its
+// keyspace to be written through it, or at least with the same KeyCodec.
+type Store[K, V any] struct {
+	ks     Keyspace
+	keys   KeyCodec[K]
+	values ValueCodec[V]
+}
+
+// New returns a Store over ks. ks must use lmstree.BytewiseComparator, the
+// order KeyCodecs preserve.
+func New[K, V any](ks Keyspace, keys KeyCodec[K], values ValueCodec[V]) *Store[K, V] {
+	return &Store[K, V]{ks: ks, keys: keys, values: values}
+}
+
+// Get returns the value stored under k, and whether there is one.
+func (s *Store[K, V]) Get(k K) (V, bool, error) {
+	var v V
+	data, ok := s.ks.Get(encodeKey(s.keys, k))
+	if !ok {
+		return v, false, nil
+	}
+	v, err := s.values.Decode(data)
+	if err != nil {
+		return v, false, err
+	}
+	return v, true, nil
+}
+
+// Put stores k → v.
+func (s *Store[K, V]) Put(k K, v V) error {
+	data, err := s.values.Encode(v)
+	if err != nil {
+		return err
+	}
+	return s.ks.Put(encodeKey(s.keys, k), data)
+}
+
+// Delete removes k.
+func (s *Store[K, V]) Delete(k K) error {
+	return s.ks.Delete(encodeKey(s.keys, k))
+}
+
+// Scan returns an Iterator over the keys in [start, end). A nil start or end
+// leaves that side of the range unbounded.
+func (s *Store[K, V]) Scan(start, end *K) *Iterator[K, V] {
+	var startKey, endKey []byte
+	if start != nil {
+		startKey = encodeKey(s.keys, *start)
+	}
+	if end != nil {
+		endKey = encodeKey(s.keys, *end)
+	}
+	it := &Iterator[K, V]{store: s, it: s.ks.Scan(startKey, endKey)}
+	it.load()
+	return it
+}
+
+// Iterator is a forward cursor over the entries of a Store, decoding each key and
+// value as it reaches them. It stops at the first one that does not decode, and
+// Err reports why. Like lmstree.Iterator, it must be closed.
+type Iterator[K, V any] struct {
+	store *Store[K, V]
+	it    *lmstree.Iterator
+	key   K
+	value V
+	valid bool
+	err   error
+}
+
+// load decodes the entry the underlying iterator is positioned at.
+func (it *Iterator[K, V]) load() {
+	it.valid = false
+	if it.err != nil || !it.it.Valid() {
+		return
+	}
+	key, err := decodeKey(it.store.keys, it.it.Key())
+	if err != nil {
+		it.err = err
+		return
+	}
+	value, err := it.store.values.Decode(it.it.Value())
+	if err != nil {
+		it.err = err
+		return
+	}
+	it.key, it.value, it.valid = key, value, true
+}
+
+// Next advances to the next entry.
+func (it *Iterator[K, V]) Next() {
+	if !it.valid {
+		return
+	}
+	it.it.Next()
+	it.load()
+}
+
+// Valid reports whether the iterator is positioned at an entry.
+func (it *Iterator[K, V]) Valid() bool { return it.valid }
+
+// Key returns the current key. Only valid while Valid() is true.
+func (it *Iterator[K, V]) Key() K { return it.key }
+
+// Value returns the current value. Only valid while Valid() is true.
+func (it *Iterator[K, V]) Value() V { return it.value }
+
+// Err returns the first decode or read error encountered.
+func (it *Iterator[K, V]) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.it.Err()
+}
+
+// Close releases the underlying iterator and returns Err().
+func (it *Iterator[K, V]) Close() error {
+	it.valid = false
+	if err := it.it.Close(); err != nil {
+		return err
+	}
+	return it.err
+}
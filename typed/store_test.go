@@ -0,0 +1,123 @@
+package typed
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	lmstree "github.com/maksymus/lmstree"
+	"github.com/maksymus/lmstree/tuple"
+)
+
+type user struct {
+	Name string
+	Age  int
+}
+
+func TestStore(t *testing.T) {
+	opts := lmstree.DefaultOptions(t.TempDir())
+	opts.MemTableSize = 256 // reads cross flushed SSTables
+	tree, err := lmstree.Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	for name, values := range map[string]ValueCodec[user]{"json": JSON[user](), "gob": Gob[user]()} {
+		cf, err := tree.CreateColumnFamily(name, lmstree.ColumnFamilyOptions{})
+		if err != nil {
+			t.Fatalf("CreateColumnFamily: %v", err)
+		}
+		users := New(cf, Tuple2(String, Int64), values)
+		for i := int64(-20); i < 20; i++ {
+			if err := users.Put(Pair[string, int64]{"team", i}, user{Name: fmt.Sprint("u", i), Age: int(i)}); err != nil {
+				t.Fatalf("%s: Put: %v", name, err)
+			}
+		}
+		users.Put(Pair[string, int64]{"other", 0}, user{Name: "o"})
+		users.Delete(Pair[string, int64]{"team", 3})
+
+		if u, ok, err := users.Get(Pair[string, int64]{"team", -7}); err != nil || !ok || u != (user{"u-7", -7}) {
+			t.Errorf("%s: Get(team, -7) = %v, %v, %v", name, u, ok, err)
+		}
+		if _, ok, err := users.Get(Pair[string, int64]{"team", 3}); err != nil || ok {
+			t.Errorf("%s: Get of a deleted key = %v, %v", name, ok, err)
+		}
+
+		start, end := Pair[string, int64]{"team", -2}, Pair[string, int64]{"team", 5}
+		it := users.Scan(&start, &end)
+		var got []int64
+		for ; it.Valid(); it.Next() {
+			if it.Value().Age != int(it.Key().Second) {
+				t.Errorf("%s: value %v under key %v", name, it.Value(), it.Key())
+			}
+			got = append(got, it.Key().Second)
+		}
+		if err := it.Close(); err != nil {
+			t.Fatalf("%s: Scan: %v", name, err)
+		}
+		if fmt.Sprint(got) != "[-2 -1 0 1 2 4]" {
+			t.Errorf("%s: Scan(team -2, team 5) = %v", name, got)
+		}
+	}
+
+	// A key written around the Store stops its scans with an error.
+	raw := New(tree, String, Raw)
+	raw.Put("k", []byte("v"))
+	tree.Put([]byte("not encoded"), []byte("v"))
+	it := raw.Scan(nil, nil)
+	for it.Valid() {
+		it.Next()
+	}
+	if err := it.Close(); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Scan over a foreign key error = %v, want ErrInvalidKey", err)
+	}
+}
+
+func TestStore_TupleKeys(t *testing.T) {
+	opts := lmstree.DefaultOptions(t.TempDir())
+	opts.MemTableSize = 256
+	tree, err := lmstree.Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	orders := New(tree, Tuple, JSON[string]())
+	for i := int64(0); i < 10; i++ {
+		if err := orders.Put(tuple.Tuple{"acme", i}, fmt.Sprint("order", i)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	orders.Put(tuple.Tuple{"acme\x00"}, "after")
+	orders.Put(tuple.Tuple{"ac"}, "before")
+
+	if v, ok, err := orders.Get(tuple.Tuple{"acme", int64(4)}); err != nil || !ok || v != "order4" {
+		t.Errorf("Get(acme, 4) = %v, %v, %v", v, ok, err)
+	}
+
+	start, end := tuple.Tuple{"acme", int64(2)}, tuple.Tuple{"acme", int64(5)}
+	it := orders.Scan(&start, &end)
+	var got []string
+	for ; it.Valid(); it.Next() {
+		got = append(got, fmt.Sprint(it.Key(), "=", it.Value()))
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if fmt.Sprint(got) != "[[acme 2]=order2 [acme 3]=order3 [acme 4]=order4]" {
+		t.Errorf("Scan(acme 2, acme 5) = %v", got)
+	}
+
+	it = orders.Scan(nil, nil)
+	var all []string
+	for ; it.Valid(); it.Next() {
+		all = append(all, it.Value())
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(all) != 12 || all[0] != "before" || all[11] != "after" {
+		t.Errorf("Scan(nil, nil) = %v", all)
+	}
+}
@@ -0,0 +1,54 @@
+package typed
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// ValueCodec converts values of type V to bytes and back.
+type ValueCodec[V any] interface {
+	Encode(v V) ([]byte, error)
+	Decode(data []byte) (V, error)
+}
+
+// Raw stores []byte values as they are.
+var Raw ValueCodec[[]byte] = rawCodec{}
+
+// JSON returns a codec that stores values as JSON with encoding/json.
+func JSON[V any]() ValueCodec[V] { return jsonCodec[V]{} }
+
+// Gob returns a codec that stores values with encoding/gob. Each value is encoded
+// on its own, type information included.
+func Gob[V any]() ValueCodec[V] { return gobCodec[V]{} }
+
+type rawCodec struct{}
+
+func (rawCodec) Encode(v []byte) ([]byte, error)    { return v, nil }
+func (rawCodec) Decode(data []byte) ([]byte, error) { return data, nil }
+
+type jsonCodec[V any] struct{}
+
+func (jsonCodec[V]) Encode(v V) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec[V]) Decode(data []byte) (V, error) {
+	var v V
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+type gobCodec[V any] struct{}
+
+func (gobCodec[V]) Encode(v V) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec[V]) Decode(data []byte) (V, error) {
+	var v V
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
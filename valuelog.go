@@ -0,0 +1,194 @@
+package lmstree
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/maksymus/lmstree/entry"
+	"github.com/maksymus/lmstree/internal/vlog"
+)
+
+// gcBatchSize is the number of live values ValueLogGC rewrites per write.
+const gcBatchSize = 64
+
+// separateValues moves values of at least ValueThreshold bytes into the value
+// log, returning entries with such values replaced by a pointer to them. The
+// entries passed in are not modified. Must be called with t.mu held.
+func (t *LSMTree) separateValues(entries []*entry.Entry) ([]*entry.Entry, error) {
+	threshold := t.opts.ValueThreshold
+	if threshold <= 0 {
+		return entries, nil
+	}
+	separated, copied := entries, false
+	for i, e := range entries {
+		if e.Tombstone || e.Operand || e.RangeDelete || e.ValuePointer || len(e.Value) < threshold {
+			continue
+		}
+		p, err := t.vlog.Append(e.Family, e.Key, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		if !copied {
+			separated, copied = slices.Clone(entries), true
+		}
+		ptr := *e
+		ptr.Value = p.Encode()
+		ptr.ValuePointer = true
+		separated[i] = &ptr
+	}
+	return separated, nil
+}
+
+// value returns the value of e, reading it from the value log if e holds a
+// pointer to it.
+func (t *LSMTree) value(e *entry.Entry) ([]byte, error) {
+	if !e.ValuePointer {
+		return e.Value, nil
+	}
+	p, err := vlog.DecodePointer(e.Value)
+	if err != nil {
+		return nil, err
+	}
+	return t.vlog.Read(p)
+}
+
+// ValueLogGC reclaims the oldest value-log file in which at least discardRatio
+// of the bytes hold values that are overwritten, deleted or expired. The values
+// still live in it are written again, to the newest file, and the file is
+// removed once iterators reading from it are closed. It reports whether a file
+// was reclaimed.
+//
+// Nothing is reclaimed while a snapshot or transaction is open, since it may
+// still read older values from the file. The rewritten values are synced, with
+// the WAL, before the file goes, whatever the WALSyncMode. Archived WAL
+// segments are not rewritten, though: a Subscription that reaches a change
+// whose value was in the file ends with ErrChangesNotRetained.
+func (t *LSMTree) ValueLogGC(discardRatio float64) (bool, error) {
+	for _, id := range t.vlog.Sealed() {
+		if t.hasSnapshots() {
+			return false, nil
+		}
+
+		var live []vlog.Record
+		var liveBytes int64
+		err := t.vlog.Scan(id, func(rec vlog.Record) error {
+			t.mu.RLock()
+			_, ok := t.liveValue(rec)
+			t.mu.RUnlock()
+			if ok {
+				live = append(live, rec)
+				liveBytes += int64(len(rec.Key)) + int64(rec.Ptr.Length)
+			}
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+		size := t.vlog.Size(id)
+		if size == 0 || float64(size-liveBytes) < discardRatio*float64(size) {
+			continue
+		}
+
+		for chunk := range slices.Chunk(live, gcBatchSize) {
+			if err := t.rewriteValues(chunk); err != nil {
+				return false, err
+			}
+		}
+
+		// The pointers to the old file are still durable; the new ones must be too.
+		if err := t.Sync(); err != nil {
+			return false, err
+		}
+		t.mu.Lock()
+		if len(t.snapshots) > 0 {
+			t.mu.Unlock()
+			return false, nil
+		}
+		err = t.vlog.Remove(id)
+		t.mu.Unlock()
+		return true, err
+	}
+	return false, nil
+}
+
+// hasSnapshots reports whether any snapshot is open.
+func (t *LSMTree) hasSnapshots() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.snapshots) > 0
+}
+
+// rewriteValues writes the still-live values of records again, so they no
+// longer depend on the file they were found in.
+func (t *LSMTree) rewriteValues(records []vlog.Record) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ctx := context.Background()
+	if err := t.stall(ctx); err != nil {
+		return err
+	}
+
+	var entries []*entry.Entry
+	for _, rec := range records {
+		base, ok := t.liveValue(rec)
+		if !ok {
+			continue
+		}
+		cf := t.familyIDs[rec.Family]
+		newest, _, err := cf.getEntryAt(ctx, rec.Key, t.seq)
+		if err != nil {
+			return err
+		}
+		if newest.Seq == base.Seq {
+			value, err := t.value(base)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, &entry.Entry{Key: base.Key, Value: value, ExpiresAt: base.ExpiresAt, Family: rec.Family})
+			continue
+		}
+		// Merge operands lie on top: fold them in, leaving nothing that refers
+		// to the old value.
+		value, ok, err := t.getAt(ctx, cf, rec.Key, t.seq)
+		if err != nil {
+			return err
+		}
+		if ok {
+			entries = append(entries, &entry.Entry{Key: rec.Key, Value: value, Family: rec.Family})
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return t.writeLocked(entries...)
+}
+
+// liveValue returns the newest version of rec's key that is not a merge operand
+// if it still points at rec, i.e. if rec is part of the key's current value.
+// Must be called with t.mu held (read or write).
+func (t *LSMTree) liveValue(rec vlog.Record) (*entry.Entry, bool) {
+	cf, ok := t.familyIDs[rec.Family]
+	if !ok {
+		return nil, false
+	}
+	ctx := context.Background()
+	now := time.Now().UnixNano()
+	deletedAt := cf.rangeDeletedAt(rec.Key, t.seq)
+	seq := t.seq
+	for {
+		e, ok, err := cf.getEntryAt(ctx, rec.Key, seq)
+		if err != nil || !ok || e.Seq < deletedAt {
+			return nil, false
+		}
+		if !e.Operand {
+			live := e.ValuePointer && !e.Tombstone && !e.Expired(now) && bytes.Equal(e.Value, rec.Ptr.Encode())
+			return e, live
+		}
+		if e.Seq == 0 {
+			return nil, false
+		}
+		seq = e.Seq - 1
+	}
+}
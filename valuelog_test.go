@@ -0,0 +1,168 @@
+package lmstree
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestLSMTree_ValueSeparation(t *testing.T) {
+	dir := tempDir(t)
+	opts := DefaultOptions(dir)
+	opts.ValueThreshold = 8
+	opts.MergeOperator = counterOperator{}
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	large := bytes.Repeat([]byte("v"), 100)
+	tree.Put([]byte("large"), large)
+	tree.Put([]byte("small"), []byte("tiny"))
+	tree.Put([]byte("counter"), u64(5)) // 8 bytes: separated
+	tree.Merge([]byte("counter"), u64(2))
+
+	check := func(when string) {
+		t.Helper()
+		if v, ok := tree.Get([]byte("large")); !ok || !bytes.Equal(v, large) {
+			t.Errorf("%s: Get(large) = %q, %v", when, v, ok)
+		}
+		if v, ok := tree.Get([]byte("small")); !ok || string(v) != "tiny" {
+			t.Errorf("%s: Get(small) = %q, %v", when, v, ok)
+		}
+		if v, ok := tree.Get([]byte("counter")); !ok || !bytes.Equal(v, u64(7)) {
+			t.Errorf("%s: Get(counter) = %v, %v, want 7", when, v, ok)
+		}
+		values, found := tree.MultiGet([][]byte{[]byte("large"), []byte("counter")})
+		if !found[0] || !bytes.Equal(values[0], large) || !bytes.Equal(values[1], u64(7)) {
+			t.Errorf("%s: MultiGet = %q, %v", when, values, found)
+		}
+
+		it := tree.Scan(nil, nil)
+		var got []string
+		for ; it.Valid(); it.Next() {
+			got = append(got, fmt.Sprintf("%s=%d", it.Key(), len(it.Value())))
+		}
+		for it.SeekToLast(); it.Valid(); it.Prev() {
+			got = append(got, fmt.Sprintf("%s=%d", it.Key(), len(it.Value())))
+		}
+		if err := it.Close(); err != nil {
+			t.Errorf("%s: iterator: %v", when, err)
+		}
+		want := "[counter=8 large=100 small=4 small=4 large=100 counter=8]"
+		if fmt.Sprint(got) != want {
+			t.Errorf("%s: Scan = %v, want %v", when, got, want)
+		}
+	}
+	check("in MemTable")
+
+	// Flushed to an SSTable, the operand is folded into the base read from the
+	// value log; the result is stored inline.
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	tree, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer tree.Close()
+	check("after reopen")
+
+	entries, err := tree.defaultCF.levels[0][0].reader.Entries()
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	for _, e := range entries {
+		if separated := string(e.Key) == "large"; e.ValuePointer != separated {
+			t.Errorf("SSTable entry %s has ValuePointer = %v", e.Key, e.ValuePointer)
+		}
+	}
+}
+
+func TestLSMTree_ValueLogGC(t *testing.T) {
+	dir := tempDir(t)
+	opts := DefaultOptions(dir)
+	opts.ValueThreshold = 16
+	opts.ValueLogFileSize = 1024
+
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+
+	value := func(key, version int) []byte {
+		return bytes.Repeat([]byte{byte(key), byte(version)}, 50)
+	}
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%02d", i)) }
+	for i := 0; i < 50; i++ {
+		tree.Put(key(i), value(i, 1))
+	}
+	for i := 0; i < 40; i++ {
+		tree.Put(key(i), value(i, 2))
+	}
+	for i := 40; i < 45; i++ {
+		tree.Delete(key(i))
+	}
+	files := func() int {
+		matches, _ := filepath.Glob(filepath.Join(dir, "vlog-*.log"))
+		return len(matches)
+	}
+	before := files()
+
+	snap := tree.NewSnapshot()
+	if ok, err := tree.ValueLogGC(0.5); ok || err != nil {
+		t.Errorf("ValueLogGC with an open snapshot = %v, %v; want nothing reclaimed", ok, err)
+	}
+	snap.Release()
+
+	it := tree.Scan(key(45), nil) // pins the files holding the oldest values
+	reclaimed := 0
+	for {
+		ok, err := tree.ValueLogGC(0.5)
+		if err != nil {
+			t.Fatalf("ValueLogGC: %v", err)
+		}
+		if !ok {
+			break
+		}
+		reclaimed++
+	}
+	if reclaimed == 0 {
+		t.Fatalf("ValueLogGC reclaimed nothing")
+	}
+	if !tree.vlog.Synced() {
+		t.Error("rewritten values not synced by ValueLogGC under WALSyncNone")
+	}
+	for i := 45; it.Valid(); it.Next() {
+		if !bytes.Equal(it.Key(), key(i)) || !bytes.Equal(it.Value(), value(i, 1)) {
+			t.Errorf("pinned iterator at %s = %v", it.Key(), it.Value())
+		}
+		i++
+	}
+	if err := it.Close(); err != nil {
+		t.Errorf("pinned iterator: %v", err)
+	}
+	if after := files(); after >= before {
+		t.Errorf("%d value-log files after GC, %d before", after, before)
+	}
+
+	for i := 0; i < 50; i++ {
+		got, ok := tree.Get(key(i))
+		switch {
+		case i < 40:
+			if !ok || !bytes.Equal(got, value(i, 2)) {
+				t.Errorf("Get(%s) = %v, %v; want version 2", key(i), got, ok)
+			}
+		case i < 45:
+			if ok {
+				t.Errorf("Get(%s) found a deleted key", key(i))
+			}
+		default:
+			if !ok || !bytes.Equal(got, value(i, 1)) {
+				t.Errorf("Get(%s) = %v, %v; want version 1", key(i), got, ok)
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package lmstree
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	walPkg "github.com/maksymus/lmstree/internal/wal"
+)
+
+// ErrWALFailed is returned by every write once a WAL write or sync has failed
+// for a record already logged. The tree must be reopened to accept writes again.
+var ErrWALFailed = errors.New("lmstree: WAL failed")
+
+// WALSyncMode selects when writes are forced from the WAL to stable storage.
+// Every mode survives a crash of the process; they differ in what a power
+// failure or operating-system crash can lose.
+type WALSyncMode int
+
+const (
+	// WALSyncNone never syncs the WAL before Close, leaving it to the operating
+	// system: any acknowledged write may be lost on power failure.
+	WALSyncNone WALSyncMode = iota
+	// WALSyncAlways syncs the WAL before a write is applied and returns, with the
+	// tree lock held. Writes are durable once acknowledged, and readers only see
+	// durable writes. Writes committed as one group share the sync.
+	WALSyncAlways
+	// WALSyncGroup syncs the WAL before each write returns, but after it is
+	// applied and with the tree lock released, so writers that arrive during an
+	// fsync share the next one. Writes are durable once acknowledged, though
+	// readers may see them a little earlier. If the sync fails, the write
+	// returns an error although readers may have seen it and recovery may
+	// replay it; every later write then fails with ErrWALFailed.
+	WALSyncGroup
+	// WALSyncInterval syncs the WAL in the background every
+	// Options.WALSyncInterval; writes return at once. Only writes made since the
+	// last sync may be lost on power failure.
+	WALSyncInterval
+)
+
+// Sync forces every write acknowledged so far to stable storage, whatever the
+// WALSyncMode.
+func (t *LSMTree) Sync() error {
+	t.mu.RLock()
+	w, old, seq := t.wal, t.oldWAL, t.seq
+	t.mu.RUnlock()
+	if err := t.vlog.Sync(); err != nil {
+		return err
+	}
+	if old != nil {
+		if err := old.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := w.Sync(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.markSynced(seq)
+	t.mu.Unlock()
+	return nil
+}
+
+// failWAL makes every later write fail once err has left the WAL holding a
+// record that was not applied, or not synced after it was: a later write would
+// reuse its sequence numbers, or be acknowledged on top of it. It returns the
+// error to report. Must be called with t.mu held for writing.
+func (t *LSMTree) failWAL(err error) error {
+	if t.walErr == nil {
+		t.walErr = fmt.Errorf("%w: %w", ErrWALFailed, err)
+	}
+	return t.walErr
+}
+
+// markSynced records that the writes up to seq are synced and wakes the
+// subscribers waiting for them. Must be called with t.mu held for writing.
+func (t *LSMTree) markSynced(seq uint64) {
+	if seq > t.syncedSeq {
+		t.syncedSeq = seq
+		t.notifyWatchers()
+	}
+}
+
+// committedSeq returns the sequence number of the last write a Subscription may
+// deliver: applied, and synced unless the WALSyncMode is WALSyncNone, so that a
+// subscriber never sees a write that a failed write or sync, or a crash the
+// mode protects against, takes back. Must be called with t.mu held.
+func (t *LSMTree) committedSeq() uint64 {
+	if t.opts.WALSyncMode == WALSyncNone {
+		return t.seq
+	}
+	return t.syncedSeq
+}
+
+// groupSync syncs the value log and then w, sharing the fsyncs with the other
+// writers waiting on them. t.mu is released during the sync, as in stall. Must
+// be called with t.mu held for writing.
+func (t *LSMTree) groupSync(w *walPkg.WAL) error {
+	t.mu.Unlock()
+	defer t.mu.Lock()
+	if err := t.vlog.Sync(); err != nil {
+		return err
+	}
+	return w.Sync()
+}
+
+// syncWorker runs in a background goroutine in WALSyncInterval mode and syncs
+// the WAL every Options.WALSyncInterval until Close.
+func (t *LSMTree) syncWorker() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(t.opts.WALSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = t.Sync()
+		case <-t.done:
+			return
+		}
+	}
+}
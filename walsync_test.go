@@ -0,0 +1,159 @@
+package lmstree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLSMTree_WALSyncModes(t *testing.T) {
+	modes := map[string]WALSyncMode{
+		"none":     WALSyncNone,
+		"always":   WALSyncAlways,
+		"group":    WALSyncGroup,
+		"interval": WALSyncInterval,
+	}
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			dir := tempDir(t)
+			opts := DefaultOptions(dir)
+			opts.MemTableSize = 4 * 1024
+			opts.WALSyncMode = mode
+			opts.WALSyncInterval = time.Millisecond
+			tree, err := Open(opts)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+
+			var wg sync.WaitGroup
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 50; i++ {
+						if err := tree.Put([]byte(fmt.Sprintf("w%d-%03d", w, i)), []byte("value")); err != nil {
+							t.Errorf("Put: %v", err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			if err := tree.Sync(); err != nil {
+				t.Fatalf("Sync: %v", err)
+			}
+			if err := tree.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			tree, err = Open(opts)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			defer tree.Close()
+			for w := 0; w < 8; w++ {
+				for i := 0; i < 50; i++ {
+					key := fmt.Sprintf("w%d-%03d", w, i)
+					if _, ok := tree.Get([]byte(key)); !ok {
+						t.Fatalf("Get(%s) after reopen: not found", key)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLSMTree_WALSyncModesSeparatedValues(t *testing.T) {
+	modes := map[string]WALSyncMode{
+		"none":     WALSyncNone,
+		"always":   WALSyncAlways,
+		"group":    WALSyncGroup,
+		"interval": WALSyncInterval,
+	}
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			dir := tempDir(t)
+			opts := DefaultOptions(dir)
+			opts.ValueThreshold = 16
+			opts.WALSyncMode = mode
+			opts.WALSyncInterval = time.Millisecond
+			tree, err := Open(opts)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+
+			value := bytes.Repeat([]byte("v"), 100)
+			for i := 0; i < 20; i++ {
+				if err := tree.Put([]byte(fmt.Sprintf("key%02d", i)), value); err != nil {
+					t.Fatalf("Put: %v", err)
+				}
+				// The WAL holds only a pointer: the value it points to must be
+				// durable whenever the pointer is.
+				if (mode == WALSyncAlways || mode == WALSyncGroup) && !tree.vlog.Synced() {
+					t.Fatalf("Put %d returned before its value was synced", i)
+				}
+			}
+			switch mode {
+			case WALSyncInterval:
+				deadline := time.Now().Add(5 * time.Second)
+				for !tree.vlog.Synced() && time.Now().Before(deadline) {
+					time.Sleep(time.Millisecond)
+				}
+				if !tree.vlog.Synced() {
+					t.Fatal("background sync left values unsynced")
+				}
+			case WALSyncNone:
+				if err := tree.Sync(); err != nil {
+					t.Fatalf("Sync: %v", err)
+				}
+				if !tree.vlog.Synced() {
+					t.Fatal("Sync left values unsynced")
+				}
+			}
+			if err := tree.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			tree, err = Open(opts)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			defer tree.Close()
+			for i := 0; i < 20; i++ {
+				if got, ok := tree.Get([]byte(fmt.Sprintf("key%02d", i))); !ok || !bytes.Equal(got, value) {
+					t.Fatalf("Get(key%02d) after reopen = %d bytes, %v", i, len(got), ok)
+				}
+			}
+		})
+	}
+}
+
+func TestLSMTree_WALFailureIsFatal(t *testing.T) {
+	opts := DefaultOptions(tempDir(t))
+	opts.WALSyncMode = WALSyncGroup
+	tree, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer tree.Close()
+	if err := tree.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// A WAL that can no longer be written to.
+	tree.mu.Lock()
+	tree.wal.Close()
+	tree.mu.Unlock()
+	if err := tree.Put([]byte("b"), []byte("2")); !errors.Is(err, ErrWALFailed) {
+		t.Fatalf("Put on a failed WAL error = %v, want ErrWALFailed", err)
+	}
+	if err := tree.Delete([]byte("a")); !errors.Is(err, ErrWALFailed) {
+		t.Errorf("later Delete error = %v, want ErrWALFailed", err)
+	}
+	if got, ok := tree.Get([]byte("a")); !ok || string(got) != "1" {
+		t.Errorf("Get(a) = %q, %v; want the value written before the failure", got, ok)
+	}
+}